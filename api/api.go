@@ -0,0 +1,254 @@
+// Package api implements the versioned JSON API, on the same service code as the
+// HTML handlers
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// Prefix is the path prefix of the API
+const Prefix = "/api/v1"
+
+// maxBodySize is the maximum size of a request body
+const maxBodySize = 64 << 10 // 64 KiB
+
+// operation is an API endpoint. The request and response types document it in
+// the OpenAPI document
+type operation struct {
+	method   string
+	path     string // relative to Prefix
+	summary  string
+	request  reflect.Type // nil for no body
+	response reflect.Type
+	handler  http.HandlerFunc
+}
+
+// operations are the API endpoints
+var operations []operation
+
+func init() {
+	operations = []operation{
+		{
+			method: http.MethodPost, path: "/deposits/prepare",
+			summary: "Prepare a deposit, returning its note and the txn group to sign",
+			request: typeOf[DepositPrepareRequest](), response: typeOf[DepositPrepareResponse](),
+			handler: handle(prepareDeposit),
+		},
+		{
+			method: http.MethodPost, path: "/deposits/submit",
+			summary: "Submit a prepared deposit with the signed txn and wait for its confirmation",
+			request: typeOf[DepositSubmitRequest](), response: typeOf[DepositSubmitResponse](),
+			handler: handle(submitDeposit),
+		},
+		{
+			method: http.MethodPost, path: "/withdrawals/quote",
+			summary: "Return how much can be withdrawn from a note",
+			request: typeOf[WithdrawalQuoteRequest](), response: typeOf[WithdrawalQuoteResponse](),
+			handler: handle(quoteWithdrawal),
+		},
+		{
+			method: http.MethodPost, path: "/withdrawals/prepare",
+			summary:  "Prepare a withdrawal, returning its change note",
+			request:  typeOf[WithdrawalPrepareRequest](),
+			response: typeOf[WithdrawalPrepareResponse](),
+			handler:  handle(prepareWithdrawal),
+		},
+		{
+			method: http.MethodPost, path: "/withdrawals/submit",
+			summary:  "Submit a prepared withdrawal and wait for its confirmation",
+			request:  typeOf[WithdrawalSubmitRequest](),
+			response: typeOf[WithdrawalSubmitResponse](),
+			handler:  handle(submitWithdrawal),
+		},
+		{
+			method: http.MethodPost, path: "/notes/status",
+			summary: "Return the state of a note",
+			request: typeOf[NoteStatusRequest](), response: typeOf[NoteStatusResponse](),
+			handler: handle(noteStatus),
+		},
+		{
+			method: http.MethodGet, path: "/stats",
+			summary:  "Return the protocol statistics",
+			response: typeOf[StatsResponse](),
+			handler:  statsHandler,
+		},
+	}
+}
+
+// RegisterHandlers registers the API handlers on the default mux
+func RegisterHandlers() {
+	for _, op := range operations {
+		http.HandleFunc(Prefix+op.path, allowMethod(op.method, op.handler))
+	}
+	http.HandleFunc(Prefix+"/openapi.json", allowMethod(http.MethodGet, OpenAPIHandler))
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// allowMethod returns a handler answering 405 to the other methods
+func allowMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, &service.Error{Code: service.CodeBadRequest,
+				Message: "Method not allowed", Status: http.StatusMethodNotAllowed})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handle returns a handler decoding the JSON request into Req and encoding the
+// response or error of f
+func handle[Req, Resp any](f func(context.Context, *Req) (*Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := new(Req)
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(req); err != nil {
+			slog.InfoContext(r.Context(), "Error decoding API request", "error", err)
+			msg := "The request body is not valid JSON for this endpoint"
+			if errors.Is(err, io.EOF) {
+				msg = "The request body is empty"
+			}
+			writeError(w, &service.Error{Code: service.CodeBadRequest, Message: msg,
+				Status: http.StatusBadRequest})
+			return
+		}
+		resp, err := f(r.Context(), req)
+		if err != nil {
+			writeError(w, service.AsError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding API response", "error", err)
+	}
+}
+
+// writeError writes the service error as the JSON response
+func writeError(w http.ResponseWriter, e *service.Error) {
+	writeJSON(w, e.Status, ErrorResponse{Error: ErrorBody{Code: e.Code, Message: e.Message}})
+}
+
+// RateLimited writes the response to a rate limited API request
+func RateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	writeError(w, service.RateLimitedError(retryAfter))
+}
+
+func prepareDeposit(ctx context.Context, req *DepositPrepareRequest,
+) (*DepositPrepareResponse, error) {
+	d, err := service.PrepareDeposit(ctx, req.Amount, req.Address, req.Seed.input())
+	if err != nil {
+		return nil, err
+	}
+	var txns []string
+	if err := json.Unmarshal([]byte(d.TxnsJson()), &txns); err != nil {
+		return nil, err
+	}
+	return &DepositPrepareResponse{
+		Amount:         newAmount(d.Amount),
+		Address:        string(d.Address),
+		Note:           d.Note.Text(),
+		Counter:        seedCounter(d.Note),
+		Txns:           txns,
+		IndexTxnToSign: d.IndexTxnToSign,
+	}, nil
+}
+
+func submitDeposit(ctx context.Context, req *DepositSubmitRequest,
+) (*DepositSubmitResponse, error) {
+	result, err := service.SubmitDeposit(ctx, service.DepositSubmission{
+		SignedTxn: req.SignedTxn,
+		Amount:    req.Amount,
+		Address:   req.Address,
+		Note:      req.Note,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DepositSubmitResponse{TxnID: result.TxnID, LeafIndex: result.LeafIndex,
+		Round: result.Round}, nil
+}
+
+func quoteWithdrawal(_ context.Context, req *WithdrawalQuoteRequest,
+) (*WithdrawalQuoteResponse, error) {
+	quote, err := service.QuoteWithdrawal(req.Note)
+	if err != nil {
+		return nil, err
+	}
+	return &WithdrawalQuoteResponse{
+		Balance:         newAmount(quote.Balance),
+		MaxWithdrawable: newAmount(quote.MaxWithdrawable),
+		MaxFee:          newAmount(quote.MaxFee),
+	}, nil
+}
+
+func prepareWithdrawal(ctx context.Context, req *WithdrawalPrepareRequest,
+) (*WithdrawalPrepareResponse, error) {
+	w, err := service.PrepareWithdrawal(ctx, req.Amount, req.Address, req.Note,
+		req.Seed.input())
+	if err != nil {
+		return nil, err
+	}
+	return &WithdrawalPrepareResponse{
+		SessionID:  w.SessionId,
+		Amount:     newAmount(w.Amount),
+		Fee:        newAmount(w.Fee),
+		Address:    string(w.Address),
+		ChangeNote: w.ChangeNote.Text(),
+		Counter:    seedCounter(w.ChangeNote),
+	}, nil
+}
+
+func submitWithdrawal(ctx context.Context, req *WithdrawalSubmitRequest,
+) (*WithdrawalSubmitResponse, error) {
+	result, err := service.SubmitWithdrawal(ctx, req.SessionID, req.ChangeNote, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WithdrawalSubmitResponse{TxnID: result.TxnID, LeafIndex: result.LeafIndex,
+		Round: result.Round}, nil
+}
+
+func noteStatus(ctx context.Context, req *NoteStatusRequest,
+) (*NoteStatusResponse, error) {
+	status, err := service.GetNoteStatus(ctx, req.Note)
+	if err != nil {
+		return nil, err
+	}
+	resp := &NoteStatusResponse{
+		State:   status.State,
+		Balance: newAmount(status.Balance),
+		TxnID:   status.TxnID,
+		SpentBy: status.SpentBy,
+	}
+	if status.LeafIndex != models.EmptyLeafIndex {
+		resp.LeafIndex = &status.LeafIndex
+	}
+	return resp, nil
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newStatsResponse(models.GetProtocolStats()))
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// The OpenAPI document is generated from the operations and their request and
+// response types by reflection, so that it cannot drift from the code
+
+// schema is an OpenAPI schema object
+type schema map[string]any
+
+// enums are the values of the string types with a closed set of values
+var enums = map[reflect.Type]func() []string{
+	typeOf[service.Code](): func() []string {
+		var codes []string
+		for _, c := range service.Codes() {
+			codes = append(codes, string(c))
+		}
+		return codes
+	},
+	typeOf[service.NoteState](): func() []string {
+		return []string{string(service.NotePending), string(service.NoteUnspent),
+			string(service.NoteSpent)}
+	},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument map[string]any
+)
+
+// OpenAPI returns the OpenAPI document of the API
+func OpenAPI() map[string]any {
+	openAPIOnce.Do(func() {
+		openAPIDocument = buildOpenAPI()
+	})
+	return openAPIDocument
+}
+
+// OpenAPIHandler serves the OpenAPI document of the API
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPI())
+}
+
+// buildOpenAPI generates the OpenAPI document from the operations
+func buildOpenAPI() map[string]any {
+	components := map[string]any{}
+	errorSchema := schemaOf(typeOf[ErrorResponse](), components)
+	paths := map[string]any{}
+	for _, op := range operations {
+		item, ok := paths[op.path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		operation := map[string]any{
+			"operationId": operationId(op),
+			"summary":     op.summary,
+			"responses": map[string]any{
+				"200": jsonContent("Success", schemaOf(op.response, components)),
+				"default": jsonContent("Error, see the code for its kind",
+					errorSchema),
+			},
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": schemaOf(op.request, components),
+					},
+				},
+			}
+		}
+		item[strings.ToLower(op.method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Hermes Vault API",
+			"version": "1.0.0",
+		},
+		"servers":    []any{map[string]any{"url": Prefix}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": components},
+	}
+}
+
+// jsonContent returns an OpenAPI response with a JSON body
+func jsonContent(description string, s schema) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": s},
+		},
+	}
+}
+
+// operationId returns the id of the operation, e.g. postDepositsPrepare
+func operationId(op operation) string {
+	id := strings.ToLower(op.method)
+	for _, part := range strings.Split(op.path, "/") {
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// schemaOf returns the schema of t. Named structs are added to the components and
+// referenced
+func schemaOf(t reflect.Type, components map[string]any) schema {
+	if values, ok := enums[t]; ok {
+		return schema{"type": "string", "enum": values()}
+	}
+	if t == typeOf[time.Time]() {
+		return schema{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), components)
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return schema{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": schemaOf(t.Elem(), components)}
+	case reflect.Struct:
+		ref := schema{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := components[t.Name()]; !ok {
+			components[t.Name()] = schema{} // placeholder for recursive types
+			components[t.Name()] = structSchema(t, components)
+		}
+		return ref
+	default:
+		log.Printf("OpenAPI: unsupported type %v", t)
+		return schema{}
+	}
+}
+
+// structSchema returns the schema of the JSON object of a struct
+func structSchema(t reflect.Type, components map[string]any) schema {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := schemaOf(f.Type, components)
+		if description := f.Tag.Get("description"); description != "" {
+			if _, isRef := s["$ref"]; isRef {
+				// siblings of $ref are ignored in OpenAPI 3.0
+				s = schema{"allOf": []any{s}, "description": description}
+			} else {
+				s["description"] = description
+			}
+		}
+		properties[name] = s
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// The request and response bodies of the API. The OpenAPI document is generated
+// from these types, the description tags document their fields
+
+// Amount is an amount of algo
+type Amount struct {
+	Algo       string `json:"algo" description:"amount in algo, as a decimal string"`
+	Microalgos uint64 `json:"microalgos"`
+}
+
+func newAmount(a models.Amount) Amount {
+	return Amount{Algo: a.Algostring, Microalgos: a.Microalgos}
+}
+
+// ErrorResponse is the body of all error responses
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error
+type ErrorBody struct {
+	Code    service.Code `json:"code"`
+	Message string       `json:"message" description:"plain text, one sentence per line"`
+}
+
+type DepositPrepareRequest struct {
+	Amount  string `json:"amount" description:"amount to deposit in algo"`
+	Address string `json:"address" description:"address making the deposit"`
+	Seed    Seed   `json:"seed,omitempty" description:"seed to derive the note from"`
+}
+
+// Seed is the optional seed to derive a note from, so that it can be recovered.
+// The server derives the note, so it sees the seed
+type Seed struct {
+	Mnemonic string  `json:"mnemonic,omitempty" description:"25 word mnemonic, unset for random notes; the server sees it and can derive all its notes"`
+	Counter  *uint64 `json:"counter,omitempty" description:"defaults to the next counter not used nor reserved"`
+}
+
+func (s Seed) input() service.SeedInput {
+	in := service.SeedInput{Mnemonic: s.Mnemonic}
+	if s.Counter != nil {
+		in.Counter = strconv.FormatUint(*s.Counter, 10)
+	}
+	return in
+}
+
+// seedCounter returns the seed counter of the note, or nil if it is not seeded
+func seedCounter(n *models.Note) *uint64 {
+	if !n.Seeded {
+		return nil
+	}
+	return &n.Counter
+}
+
+type DepositPrepareResponse struct {
+	Amount  Amount  `json:"amount"`
+	Address string  `json:"address"`
+	Note    string  `json:"note" description:"secret note of the deposit, to keep safe"`
+	Counter *uint64 `json:"counter,omitempty" description:"seed counter of the note, if seeded"`
+	// Txns are the txns of the group, base64 encoded msgpack
+	Txns           []string `json:"txns" description:"the deposit txn group, base64 msgpack"`
+	IndexTxnToSign int      `json:"index_txn_to_sign" description:"index of the txn to sign"`
+}
+
+type DepositSubmitRequest struct {
+	SignedTxn string `json:"signed_txn" description:"the signed txn, base64 msgpack"`
+	Amount    string `json:"amount,omitempty" description:"if set, must match the prepared deposit"`
+	Address   string `json:"address,omitempty" description:"if set, must match the prepared deposit"`
+	Note      string `json:"note,omitempty" description:"if set, must match the prepared deposit"`
+}
+
+type DepositSubmitResponse struct {
+	TxnID     string `json:"txn_id"`
+	LeafIndex int    `json:"leaf_index"`
+	Round     uint64 `json:"round" description:"round the deposit was confirmed in"`
+}
+
+type WithdrawalQuoteRequest struct {
+	Note string `json:"note" description:"secret note to withdraw from"`
+}
+
+type WithdrawalQuoteResponse struct {
+	Balance         Amount `json:"balance"`
+	MaxWithdrawable Amount `json:"max_withdrawable"`
+	MaxFee          Amount `json:"max_fee" description:"fee of the largest withdrawal"`
+}
+
+type WithdrawalPrepareRequest struct {
+	Amount  string `json:"amount" description:"amount to withdraw in algo"`
+	Address string `json:"address" description:"address receiving the withdrawal"`
+	Note    string `json:"note" description:"secret note to withdraw from"`
+	Seed    Seed   `json:"seed,omitempty" description:"seed to derive the change note from"`
+}
+
+type WithdrawalPrepareResponse struct {
+	SessionID  string  `json:"session_id" description:"id to submit the withdrawal with"`
+	Amount     Amount  `json:"amount"`
+	Fee        Amount  `json:"fee"`
+	Address    string  `json:"address"`
+	ChangeNote string  `json:"change_note" description:"secret note of the change, to keep safe"`
+	Counter    *uint64 `json:"counter,omitempty" description:"seed counter of the change note, if seeded"`
+}
+
+type WithdrawalSubmitRequest struct {
+	SessionID  string `json:"session_id"`
+	ChangeNote string `json:"change_note" description:"must match the prepared change note"`
+}
+
+type WithdrawalSubmitResponse struct {
+	TxnID     string `json:"txn_id"`
+	LeafIndex int    `json:"leaf_index" description:"leaf index of the change note"`
+	Round     uint64 `json:"round" description:"round the withdrawal was confirmed in"`
+}
+
+type NoteStatusRequest struct {
+	Note string `json:"note"`
+}
+
+type NoteStatusResponse struct {
+	State     service.NoteState `json:"state"`
+	Balance   Amount            `json:"balance"`
+	LeafIndex *int              `json:"leaf_index,omitempty" description:"unset if pending"`
+	TxnID     string            `json:"txn_id,omitempty" description:"txn inserting the note"`
+	SpentBy   string            `json:"spent_by,omitempty" description:"txn spending the note"`
+}
+
+type StatsResponse struct {
+	TVL              uint64        `json:"tvl" description:"microalgos held for the depositors"`
+	DepositCount     uint64        `json:"deposit_count"`
+	DepositVolume    uint64        `json:"deposit_volume" description:"microalgos"`
+	WithdrawalCount  uint64        `json:"withdrawal_count"`
+	WithdrawalVolume uint64        `json:"withdrawal_volume" description:"microalgos"`
+	Fees             uint64        `json:"fees" description:"microalgos"`
+	LeafCount        uint64        `json:"leaf_count"`
+	AnonymitySetSize uint64        `json:"anonymity_set_size" description:"unspent notes"`
+	HistoryByLeaf    []StatsBucket `json:"history_by_leaf" description:"activity by range of leaves, the txns have no round or timestamp"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+// StatsBucket is the activity of the txns inserting a range of leaves
+type StatsBucket struct {
+	FromLeaf         int    `json:"from_leaf"`
+	ToLeaf           int    `json:"to_leaf"`
+	DepositCount     uint64 `json:"deposit_count"`
+	DepositVolume    uint64 `json:"deposit_volume"`
+	WithdrawalCount  uint64 `json:"withdrawal_count"`
+	WithdrawalVolume uint64 `json:"withdrawal_volume"`
+}
+
+func newStatsResponse(s models.ProtocolStats) StatsResponse {
+	r := StatsResponse{
+		TVL:              s.TVL,
+		DepositCount:     s.DepositCount,
+		DepositVolume:    s.DepositVolume,
+		WithdrawalCount:  s.WithdrawalCount,
+		WithdrawalVolume: s.WithdrawalVolume,
+		Fees:             s.Fees,
+		LeafCount:        s.LeafCount,
+		AnonymitySetSize: s.AnonymitySetSize,
+		HistoryByLeaf:    make([]StatsBucket, len(s.HistoryByLeaf)),
+		UpdatedAt:        s.UpdatedAt,
+	}
+	for i, b := range s.HistoryByLeaf {
+		r.HistoryByLeaf[i] = StatsBucket(b)
+	}
+	return r
+}
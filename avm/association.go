@@ -0,0 +1,290 @@
+package avm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/disclosure"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/consensys/gnark/backend/plonk"
+)
+
+// AssociationPolicy decides which leaves of the main tree belong to an association
+// set. Leaves not in the set are replaced by the empty leaf in the association tree.
+// The set is built from public data only: the change note of a withdrawal is also
+// left out if the note it spent is out of the set and was linked to its nullifier
+// by a disclosure proof, see BuildAssociationSet
+type AssociationPolicy interface {
+	Name() string
+	Includes(txn *models.Txn) bool
+}
+
+// ErrNotInAssociationSet is returned proving a note that is not in the association set
+var ErrNotInAssociationSet = errors.New("note not in association set")
+
+// AssociationSet is an association set tree built from the txns in txnsDb
+type AssociationSet struct {
+	Policy    string `json:"policy"`
+	Root      []byte `json:"root"`
+	LeafCount int    `json:"leafCount"` // number of leaves in the main tree
+	leaves    [][]byte
+}
+
+var (
+	associationPolicies = map[string]AssociationPolicy{}
+	// associationSets caches the last association set built for each policy
+	associationSets = map[string]*AssociationSet{}
+	// disclosedNullifiers maps the commitments of the notes linked by the verified
+	// disclosure proofs to their nullifiers
+	disclosedNullifiers = map[string][]byte{}
+	associationSetsMu   sync.Mutex
+)
+
+// initAssociationPolicies registers the configured association policies.
+// It must be called after the app setup
+func initAssociationPolicies() {
+	if config.FlaggedAddressesPath != "" {
+		policy, err := NewExcludedAddressesPolicy("excluded-addresses",
+			config.FlaggedAddressesPath)
+		if err != nil {
+			log.Fatalf("failed to load flagged addresses: %v", err)
+		}
+		RegisterAssociationPolicy(policy)
+	}
+	if config.DisclosuresDirPath != "" {
+		if err := loadDisclosures(config.DisclosuresDirPath,
+			config.DisclosureVerifyingKeyPath); err != nil {
+			log.Fatalf("failed to load disclosures: %v", err)
+		}
+	}
+	if config.AssociationPolicy != "" {
+		if _, ok := associationPolicies[config.AssociationPolicy]; !ok {
+			log.Fatalf("unknown association policy: %s", config.AssociationPolicy)
+		}
+		if App.AssociationWithdrawalCc == nil {
+			log.Fatalf("association policy set but the app setup has no " +
+				"association withdrawal circuit")
+		}
+	}
+}
+
+// RegisterAssociationPolicy registers a policy to build association sets with,
+// replacing any policy with the same name
+func RegisterAssociationPolicy(p AssociationPolicy) {
+	associationSetsMu.Lock()
+	defer associationSetsMu.Unlock()
+	associationPolicies[p.Name()] = p
+	delete(associationSets, p.Name())
+}
+
+// DefaultAssociationPolicy returns the name of the association set policy that
+// withdrawals prove membership in, or an empty string if none is configured
+func DefaultAssociationPolicy() string {
+	return config.AssociationPolicy
+}
+
+// BuildAssociationSet builds the association set tree for the given policy from the
+// txns in txnsDb and records its root if it changed since the last build.
+// The link between a change note and the note it spent is private, so a change note
+// is only left out with its spent note if a disclosure proof linked the spent note
+// to the nullifier of the withdrawal
+func BuildAssociationSet(policyName string) (*AssociationSet, error) {
+	associationSetsMu.Lock()
+	defer associationSetsMu.Unlock()
+
+	policy, ok := associationPolicies[policyName]
+	if !ok {
+		return nil, fmt.Errorf("unknown association policy: %s", policyName)
+	}
+	_, leafCount, err := db.GetRoot()
+	if err != nil {
+		return nil, fmt.Errorf("error getting root: %v", err)
+	}
+	if set, ok := associationSets[policyName]; ok && set.LeafCount == leafCount {
+		return set, nil
+	}
+
+	// the subscriber may add txns after the root was read, drop them so that the set
+	// is built on the same leaves as the root
+	txns, err := db.GetAllTxns()
+	if err != nil {
+		return nil, fmt.Errorf("error getting txns: %v", err)
+	}
+	if len(txns) < leafCount {
+		return nil, fmt.Errorf("the tree has %d leaves, the root %d", len(txns), leafCount)
+	}
+	txns = txns[:leafCount]
+
+	leaves := make([][]byte, len(txns))
+	excludedNullifiers := map[string]bool{}
+	for i, txn := range txns {
+		if txn.LeafIndex != i {
+			return nil, fmt.Errorf("missing leaf at index %d", i)
+		}
+		included := policy.Includes(txn) && !(txn.Type == models.WithdrawalTxnType &&
+			excludedNullifiers[string(txn.FromNullifier)])
+		if included {
+			leaves[i] = txn.Commitment
+			continue
+		}
+		leaves[i] = App.TreeConfig.ZeroHashes[0]
+		if nullifier, ok := disclosedNullifiers[string(txn.Commitment)]; ok {
+			excludedNullifiers[string(nullifier)] = true
+		}
+	}
+	set := &AssociationSet{
+		Policy:    policyName,
+		Root:      merkleRoot(leaves),
+		LeafCount: len(leaves),
+		leaves:    leaves,
+	}
+
+	isNew, err := db.SaveAssociationRoot(set.Policy, set.Root, set.LeafCount)
+	if err != nil {
+		return nil, fmt.Errorf("error saving association root: %v", err)
+	}
+	if isNew {
+		log.Printf("New association set root for policy %s: %x (%d leaves)",
+			set.Policy, set.Root, set.LeafCount)
+	}
+	associationSets[policyName] = set
+	return set, nil
+}
+
+// PublishAssociationSets builds the association sets for all registered policies
+// and returns them
+func PublishAssociationSets() ([]*AssociationSet, error) {
+	associationSetsMu.Lock()
+	names := make([]string, 0, len(associationPolicies))
+	for name := range associationPolicies {
+		names = append(names, name)
+	}
+	associationSetsMu.Unlock()
+
+	sets := make([]*AssociationSet, 0, len(names))
+	for _, name := range names {
+		set, err := BuildAssociationSet(name)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// proof returns the Merkle proof for the leaf at the given index of the association
+// set tree. It returns an error if the leaf is not in the association set
+func (s *AssociationSet) proof(leafValue []byte, leafIndex int) ([][]byte, error) {
+	if leafIndex >= 0 && leafIndex < len(s.leaves) &&
+		bytes.Equal(s.leaves[leafIndex], App.TreeConfig.ZeroHashes[0]) {
+		return nil, fmt.Errorf("%w %s", ErrNotInAssociationSet, s.Policy)
+	}
+	return merkleProofFromLeaves(s.leaves, leafValue, leafIndex, s.Root)
+}
+
+// AddDisclosure verifies the disclosure proof against the txns in txnsDb and records
+// the notes it links, so that the association sets leave out the change notes of
+// the excluded ones
+func AddDisclosure(vk plonk.VerifyingKey, proof *disclosure.Proof) error {
+	if _, err := disclosure.Verify(vk, proof, txnsSource{}); err != nil {
+		return err
+	}
+	associationSetsMu.Lock()
+	defer associationSetsMu.Unlock()
+	for _, link := range proof.Links {
+		disclosedNullifiers[string(link.Commitment)] = link.Nullifier
+	}
+	clear(associationSets)
+	return nil
+}
+
+// loadDisclosures adds the disclosure proofs in dir, verified with the key at vkPath
+func loadDisclosures(dir, vkPath string) error {
+	vk, err := disclosure.ReadVerifyingKey(vkPath)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		proof, err := disclosure.ReadProof(file)
+		if err != nil {
+			return err
+		}
+		if err := AddDisclosure(vk, proof); err != nil {
+			return fmt.Errorf("invalid disclosure %s: %v", file, err)
+		}
+	}
+	log.Printf("Loaded %d disclosures from %s", len(files), dir)
+	return nil
+}
+
+// txnsSource reads the public txn data to verify disclosure proofs from txnsDb
+type txnsSource struct{}
+
+func (txnsSource) GetTxnByID(txnID string) (*models.Txn, error) {
+	return db.GetTxnByID(txnID)
+}
+
+func (txnsSource) GetTxnByCommitment(commitment []byte) (*models.Txn, error) {
+	return db.GetTxnByCommitment(commitment)
+}
+
+// ExcludedAddressesPolicy is an association policy excluding the deposits made by
+// and the change notes of the withdrawals sent to a list of flagged addresses.
+// BuildAssociationSet excludes as well the change notes of the disclosed notes
+// descending from them
+type ExcludedAddressesPolicy struct {
+	name      string
+	addresses map[string]bool
+}
+
+// NewExcludedAddressesPolicy returns an ExcludedAddressesPolicy reading the flagged
+// addresses from the given file, one per line; empty lines and lines starting
+// with # are ignored
+func NewExcludedAddressesPolicy(name string, filename string,
+) (*ExcludedAddressesPolicy, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	addresses := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		address, err := models.Input(line).ToAddress()
+		if err != nil {
+			return nil, fmt.Errorf("invalid flagged address %s: %v", line, err)
+		}
+		addresses[string(address)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &ExcludedAddressesPolicy{name: name, addresses: addresses}, nil
+}
+
+func (p *ExcludedAddressesPolicy) Name() string {
+	return p.name
+}
+
+func (p *ExcludedAddressesPolicy) Includes(txn *models.Txn) bool {
+	return !p.addresses[txn.Address]
+}
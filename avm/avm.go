@@ -9,10 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/giuliop/HermesVault-frontend/config"
 
-	"github.com/algorand/go-algorand-sdk/v2/abi"
 	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
 )
 
@@ -45,6 +45,15 @@ func algodClient() *algod.Client {
 	return client
 }
 
+// RoundTime returns the time of the block of a round
+func RoundTime(round uint64) (time.Time, error) {
+	block, err := algodClient().Block(round).Do(context.Background())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get block %d: %v", round, err)
+	}
+	return time.Unix(block.TimeStamp, 0), nil
+}
+
 func CompileTealFromFile(tealPath string) ([]byte, error) {
 	algodClient := algodClient()
 
@@ -65,19 +74,6 @@ func CompileTealFromFile(tealPath string) ([]byte, error) {
 	return binary, nil
 }
 
-// abiEncode encodes arg into its abi []byte representation
-func abiEncode(arg any, abiTypeName string) ([]byte, error) {
-	abiType, err := abi.TypeOf(abiTypeName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get abi type: %v", err)
-	}
-	abiArg, err := abiType.Encode(arg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode noChange: %v", err)
-	}
-	return abiArg, nil
-}
-
 // readAlgodConfigFromDir reads the algod URL and token from the given directory
 func readAlgodConfigFromDir(dir string) (*algodConfig, error) {
 	urlPath := filepath.Join(dir, "algod.net")
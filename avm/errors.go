@@ -1,113 +1,23 @@
 package avm
 
-import (
-	"fmt"
-	"strings"
-)
+import "github.com/giuliop/HermesVault-frontend/protocol"
+
+// The errors sending a txn are defined in package protocol
 
 // SendTxnErrorType represents the type of error sending a transaction
-type SendTxnErrorType int
+type SendTxnErrorType = protocol.SendTxnErrorType
 
 const (
-	ErrWaitTimeout SendTxnErrorType = iota
-	ErrRejected
-	ErrOverSpend
-	ErrExpired
-	ErrInternal
-	ErrMinimumBalanceRequirement
+	ErrWaitTimeout               = protocol.ErrWaitTimeout
+	ErrRejected                  = protocol.ErrRejected
+	ErrOverSpend                 = protocol.ErrOverSpend
+	ErrExpired                   = protocol.ErrExpired
+	ErrInternal                  = protocol.ErrInternal
+	ErrMinimumBalanceRequirement = protocol.ErrMinimumBalanceRequirement
 )
 
-func (e SendTxnErrorType) String() string {
-	switch e {
-	case ErrWaitTimeout:
-		return "TxnConfirmationTimeoutError"
-	case ErrRejected:
-		return "TxnConfirmationRejectionError"
-	case ErrOverSpend:
-		return "TxnConfirmationOverSpendError"
-	case ErrExpired:
-		return "TxnConfirmationExpiredError"
-	case ErrInternal:
-		return "TxnConfirmationInternalError"
-	case ErrMinimumBalanceRequirement:
-		return "TxnConfirmationMinimumBalanceRequirementError"
-	default:
-		return "TxnConfirmationUnknownError"
-	}
-}
+// SendTxnErrorTypes are all the SendTxnErrorType values
+var SendTxnErrorTypes = protocol.SendTxnErrorTypes
 
 // TxnConfirmationError represents an error waiting for a txn confirmation
-type TxnConfirmationError struct {
-	Type    SendTxnErrorType // The type of the error
-	Message string           // The original error message
-}
-
-// Implement the Error() method to satisfy the error interface
-func (e *TxnConfirmationError) Error() string {
-	return fmt.Sprintf("[%s] %s", e.Type.String(), e.Message)
-}
-
-// parseWaitForConfirmationError parses the error returned by WaitForConfirmation
-func parseWaitForConfirmationError(err error) *TxnConfirmationError {
-	if err == nil {
-		return nil
-	}
-	if strings.Contains(err.Error(), "timed out") {
-		return &TxnConfirmationError{
-			Type:    ErrWaitTimeout,
-			Message: err.Error(),
-		}
-	}
-	if strings.Contains(err.Error(), "Transaction rejected") {
-		return &TxnConfirmationError{
-			Type:    ErrRejected,
-			Message: err.Error(),
-		}
-	}
-	return &TxnConfirmationError{
-		Type:    ErrInternal,
-		Message: err.Error(),
-	}
-}
-
-func parseSendTransactionError(err error) *TxnConfirmationError {
-	if err == nil {
-		return nil
-	}
-	if strings.Contains(err.Error(), "logic eval error") {
-		return &TxnConfirmationError{
-			Type:    ErrRejected,
-			Message: err.Error(),
-		}
-	}
-	if strings.Contains(err.Error(), "overspend") {
-		return &TxnConfirmationError{
-			Type:    ErrOverSpend,
-			Message: err.Error(),
-		}
-	}
-	if strings.Contains(err.Error(), "txn dead") {
-		return &TxnConfirmationError{
-			Type:    ErrExpired,
-			Message: err.Error(),
-		}
-	}
-	if strings.Contains(err.Error(), "balance") &&
-		strings.Contains(err.Error(), "below min") {
-		return &TxnConfirmationError{
-			Type:    ErrMinimumBalanceRequirement,
-			Message: err.Error(),
-		}
-	}
-	return &TxnConfirmationError{
-		Type:    ErrRejected,
-		Message: err.Error(),
-	}
-}
-
-func InternalError(s string) *TxnConfirmationError {
-	return &TxnConfirmationError{
-		Type:    ErrInternal,
-		Message: s,
-	}
-}
+type TxnConfirmationError = protocol.TxnConfirmationError
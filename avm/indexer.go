@@ -0,0 +1,100 @@
+package avm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// IndexerLimits are the configured thresholds of the subscriber lag
+var IndexerLimits = models.IndexerLimits{
+	WarnRounds:    config.IndexerLagWarnRounds,
+	MaxRounds:     config.IndexerLagMaxRounds,
+	CheckInterval: config.IndexerCheckInterval,
+}
+
+// indexerStatus is the last indexer status
+var indexerStatus atomic.Pointer[models.IndexerStatus]
+
+func init() {
+	SetIndexerStatus(models.IndexerStatus{})
+}
+
+// SetIndexerStatus sets the last indexer status
+func SetIndexerStatus(s models.IndexerStatus) {
+	indexerStatus.Store(&s)
+}
+
+// GetIndexerStatus returns the last indexer status
+func GetIndexerStatus() models.IndexerStatus {
+	return *indexerStatus.Load()
+}
+
+// RefreshIndexerStatus compares the round processed by the subscriber service with
+// the last round of algod and makes the result live. On error the previous rounds
+// are kept with the error recorded
+func RefreshIndexerStatus() error {
+	status := GetIndexerStatus()
+	status.CheckedAt = time.Now()
+	err := readIndexerRounds(&status)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Error = ""
+	}
+	if previous := GetIndexerStatus(); status.Stale(IndexerLimits) &&
+		(previous.CheckedAt.IsZero() || !previous.Stale(IndexerLimits)) {
+		if err != nil {
+			log.Printf("ALERT: cannot check the subscriber service, withdrawals are "+
+				"blocked until it can: %v", err)
+		} else {
+			log.Printf("ALERT: the subscriber service is %d rounds behind algod, "+
+				"withdrawals are blocked until it catches up", status.Lag())
+		}
+	}
+	SetIndexerStatus(status)
+	return err
+}
+
+// readIndexerRounds reads the subscriber watermark and the algod last round
+func readIndexerRounds(status *models.IndexerStatus) error {
+	watermark, err := db.GetWatermark()
+	if err != nil {
+		return fmt.Errorf("failed to read the subscriber watermark: %v", err)
+	}
+	nodeStatus, err := algodClient().Status().Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get algod status: %v", err)
+	}
+	status.Watermark = watermark
+	status.LastRound = nodeStatus.LastRound
+	return nil
+}
+
+// StartIndexerStatusRoutine starts a goroutine that refreshes the indexer status
+// at the given interval. It returns a cancel function to stop it
+func StartIndexerStatusRoutine(ctx context.Context, interval time.Duration) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := RefreshIndexerStatus(); err != nil {
+					log.Printf("Error refreshing indexer status: %v", err)
+				}
+			case <-ctx.Done():
+				log.Println("Indexer status routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
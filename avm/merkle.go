@@ -1,11 +1,10 @@
 package avm
 
 import (
-	"bytes"
 	"fmt"
 
-	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/protocol"
 )
 
 // getRoot returns the Merkle root from the database
@@ -25,48 +24,24 @@ func getRoot(leafIndex int) ([]byte, error) {
 // and includes the sibling hashes up to but excluding the root.
 // It checks the validity of the proof against the provided root
 func createMerkleProof(leafValue []byte, leafIndex int, root []byte) ([][]byte, error) {
-	depth := config.MerkleTreeLevels
-	proof := make([][]byte, 1, depth+1)
-	proof[0] = leafValue
-
-	// We need to decide whether we are left and add the right sibling to
-	// the proof, or we are right and add the left sibling to the proof.
-	// We can do this by checking the last bit of leaf index:
-	// if it's 0, we are left, if it's 1, we are right.
-	// We rigth shift the index to check the next bit in the next iteration.
-	currentLevel, err := db.GetAllLeavesCommitments()
+	leaves, err := db.GetAllLeavesCommitments()
 	if err != nil {
 		return nil, fmt.Errorf("error getting all leaf commitments: %v", err)
 	}
-	if !bytes.Equal(config.Hash(leafValue), currentLevel[leafIndex]) {
-		return nil, fmt.Errorf("leaf commitment mismatch")
-	}
-	if len(currentLevel)%2 == 1 {
-		currentLevel = append(currentLevel, App.TreeConfig.ZeroHashes[0])
-	}
-	nextLevel := make([][]byte, (len(currentLevel)+1)/2)
-	for i := 0; i < depth; i++ {
-		if leafIndex&1 == 0 {
-			proof = append(proof, currentLevel[leafIndex+1])
-		} else {
-			proof = append(proof, currentLevel[leafIndex-1])
-		}
-
-		for j := 0; j < len(currentLevel); j += 2 {
-			nextLevel[j/2] = config.Hash(currentLevel[j], currentLevel[j+1])
-		}
-		if len(nextLevel)%2 == 1 {
-			nextLevel = append(nextLevel, App.TreeConfig.ZeroHashes[i+1])
-		}
+	return merkleProofFromLeaves(leaves, leafValue, leafIndex, root)
+}
 
-		currentLevel = nextLevel
-		nextLevel = nextLevel[:len(nextLevel)/2]
-		leafIndex >>= 1
-	}
-	// check if the root for the proof is the same as the supplied root
-	if len(nextLevel) != 1 || !bytes.Equal(nextLevel[0], root) {
-		return nil, fmt.Errorf("root mismatch")
-	}
+// merkleProofFromLeaves returns the Merkle proof for the leaf at the given index of
+// the tree made of the given leaves (i.e. the leaf commitments), padded with empty
+// leaves. It checks the validity of the proof against the provided root
+func merkleProofFromLeaves(leaves [][]byte, leafValue []byte, leafIndex int, root []byte,
+) ([][]byte, error) {
+	return protocol.MerkleProof(App.TreeConfig.ZeroHashes, leaves, leafValue, leafIndex,
+		root)
+}
 
-	return proof, nil
+// merkleRoot returns the root of the tree made of the given leaves (i.e. the leaf
+// commitments), padded with empty leaves
+func merkleRoot(leaves [][]byte) []byte {
+	return protocol.MerkleRoot(App.TreeConfig.ZeroHashes, leaves)
 }
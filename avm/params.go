@@ -0,0 +1,85 @@
+package avm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/protocol"
+)
+
+// protocolParams are the live protocol parameters
+var protocolParams atomic.Pointer[models.ProtocolParams]
+
+func init() {
+	SetProtocolParams(models.DefaultProtocolParams)
+}
+
+// SetProtocolParams sets the live protocol parameters
+func SetProtocolParams(p models.ProtocolParams) {
+	protocolParams.Store(&p)
+}
+
+// GetProtocolParams returns the live protocol parameters
+func GetProtocolParams() models.ProtocolParams {
+	return *protocolParams.Load()
+}
+
+// ReadProtocolParams reads the protocol parameters from the app global state.
+// The parameters not declared in the app schema keep their default value
+func ReadProtocolParams() (models.ProtocolParams, error) {
+	return protocol.ReadProtocolParams(algodClient(), App)
+}
+
+// recordedParams are the protocol parameters last recorded in the history
+var recordedParams atomic.Pointer[models.ProtocolParams]
+
+// RefreshProtocolParams reads the protocol parameters from the app global state
+// and makes them live, logging an alert when they change and differ from the
+// compiled-in defaults. The parameters are recorded in the history when they
+// change, for the recovery to know the fees of the past withdrawals
+func RefreshProtocolParams() error {
+	params, err := ReadProtocolParams()
+	if err != nil {
+		return err
+	}
+	if params != GetProtocolParams() && params != models.DefaultProtocolParams {
+		log.Printf("ALERT: on-chain protocol params %+v differ from the compiled-in "+
+			"defaults %+v, using the on-chain ones", params, models.DefaultProtocolParams)
+	}
+	SetProtocolParams(params)
+	if last := recordedParams.Load(); last != nil && *last == params {
+		return nil
+	}
+	if _, err := db.SaveProtocolParams(params); err != nil {
+		return fmt.Errorf("error recording protocol params: %v", err)
+	}
+	recordedParams.Store(&params)
+	return nil
+}
+
+// StartParamsRefreshRoutine starts a goroutine that refreshes the protocol
+// parameters at the given interval. It returns a cancel function to stop it
+func StartParamsRefreshRoutine(ctx context.Context, interval time.Duration) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := RefreshProtocolParams(); err != nil {
+					log.Printf("Error refreshing protocol params: %v", err)
+				}
+			case <-ctx.Done():
+				log.Println("Params refresh routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,24 @@
+package avm
+
+import (
+	"github.com/giuliop/HermesVault-frontend/protocol"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// errors validating a transaction signed by the user
+var (
+	ErrSignedTxnMismatch    = protocol.ErrSignedTxnMismatch
+	ErrSignedTxnGroup       = protocol.ErrSignedTxnGroup
+	ErrSignedTxnSigner      = protocol.ErrSignedTxnSigner
+	ErrSignedTxnSignature   = protocol.ErrSignedTxnSignature
+	ErrSignedTxnUnsupported = protocol.ErrSignedTxnUnsupported
+)
+
+// ValidateUserSignedTxn checks that signedTxn is the transaction at index of the
+// prepared group, correctly signed by its sender or by the account the sender is
+// rekeyed to. Single and multisig signatures are accepted
+func ValidateUserSignedTxn(signedTxn *types.SignedTxn, group []types.Transaction,
+	index int) error {
+	return protocol.ValidateUserSignedTxn(algodClient(), signedTxn, group, index)
+}
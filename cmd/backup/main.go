@@ -0,0 +1,112 @@
+// Command backup takes, lists and restores snapshots of the internal database.
+//
+// Usage:
+//
+//	backup create -dir backups
+//	backup list -dir backups
+//	backup restore -snapshot backups/internal-20250101T000000.000000000Z.db
+//
+// The server takes snapshots on its own when BackupDirPath is set. Stop the server
+// before restoring, the restore refuses to run while the database is open
+// elsewhere: the snapshot is checked against its checksum, its integrity and its
+// schema version before it replaces the live database, which is kept with a
+// .pre-restore-<UTC time> suffix.
+//
+// The commands do not need the internal database to be migrated: a snapshot keeps
+// the schema version of the database it was taken from, shown by list, and a
+// restored snapshot older than the binary is migrated at the next server start.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "create":
+		createCmd(os.Args[2:])
+	case "list":
+		listCmd(os.Args[2:])
+	case "restore":
+		restoreCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup create|list|restore [flags]")
+	os.Exit(2)
+}
+
+func createCmd(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := fs.String("dir", config.BackupDirPath, "backup directory")
+	keep := fs.Int("keep", config.BackupRetention, "number of snapshots to keep")
+	fs.Parse(args)
+	if *dir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer db.Close()
+	path, err := db.Backup(*dir)
+	if err != nil {
+		log.Fatalf("Error backing up internal database: %v", err)
+	}
+	if err := db.PruneBackups(*dir, *keep); err != nil {
+		log.Fatalf("Error pruning backups: %v", err)
+	}
+	log.Printf("Internal database backed up to %s", path)
+}
+
+func listCmd(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dir := fs.String("dir", config.BackupDirPath, "backup directory")
+	fs.Parse(args)
+	if *dir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	defer db.Close()
+	snapshots, err := db.ListBackups(*dir)
+	if err != nil {
+		log.Fatalf("Error listing backups: %v", err)
+	}
+	for _, path := range snapshots {
+		version, err := db.VerifyBackup(path)
+		if err != nil {
+			fmt.Printf("%s  INVALID: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s  ok, schema version %d\n", path, version)
+	}
+}
+
+func restoreCmd(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	snapshot := fs.String("snapshot", "", "snapshot to restore")
+	fs.Parse(args)
+	if *snapshot == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	preRestorePath, err := db.RestoreBackup(*snapshot)
+	if err != nil {
+		db.Close()
+		log.Fatalf("Error restoring backup: %v", err)
+	}
+	log.Printf("Internal database restored from %s, previous database kept at %s",
+		*snapshot, preRestorePath)
+}
@@ -0,0 +1,157 @@
+// Command disclose creates and verifies selective disclosure proofs linking a
+// withdrawal to its deposit without revealing the secret notes.
+//
+// Usage:
+//
+//	disclose setup -cc CompiledDisclosureCircuit.bin -vk DisclosureVerifyingKey.bin
+//	disclose prove -cc CompiledDisclosureCircuit.bin -notes notes.txt -txn <withdrawal txn id> -out proof.json
+//	disclose verify -vk DisclosureVerifyingKey.bin -proof proof.json -txns txns.db
+//
+// The notes file lists one secret note per line, from the deposit note to the note
+// spent by the withdrawal. The verify command works offline: it only needs the
+// verifying key and the public txn data in a copy of the subscriber transactions
+// database, opened read-only. None of the commands use the internal database, so
+// they do not depend on its schema version.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/disclosure"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/giuliop/algoplonk/utils"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "setup":
+		setupCmd(os.Args[2:])
+	case "prove":
+		proveCmd(os.Args[2:])
+	case "verify":
+		verifyCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: disclose setup|prove|verify [flags]")
+	os.Exit(2)
+}
+
+func setupCmd(args []string) {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	ccPath := fs.String("cc", "CompiledDisclosureCircuit.bin", "compiled circuit output file")
+	vkPath := fs.String("vk", "DisclosureVerifyingKey.bin", "verifying key output file")
+	fs.Parse(args)
+
+	cc, err := disclosure.Compile()
+	if err != nil {
+		log.Fatalf("Error compiling disclosure circuit: %v", err)
+	}
+	if err := utils.SerializeCompiledCircuit(cc, *ccPath); err != nil {
+		log.Fatalf("Error writing compiled circuit: %v", err)
+	}
+	if err := disclosure.WriteVerifyingKey(cc, *vkPath); err != nil {
+		log.Fatalf("Error writing verifying key: %v", err)
+	}
+	log.Printf("Compiled circuit written to %s, verifying key to %s", *ccPath, *vkPath)
+}
+
+func proveCmd(args []string) {
+	fs := flag.NewFlagSet("prove", flag.ExitOnError)
+	ccPath := fs.String("cc", "CompiledDisclosureCircuit.bin", "compiled circuit file")
+	notesPath := fs.String("notes", "", "file with the notes, deposit note first")
+	txnID := fs.String("txn", "", "id of the withdrawal txn to disclose")
+	outPath := fs.String("out", "disclosure.json", "proof output file")
+	fs.Parse(args)
+	if *notesPath == "" || *txnID == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	notes, err := readNotes(*notesPath)
+	if err != nil {
+		log.Fatalf("Error reading notes: %v", err)
+	}
+	cc, err := utils.DeserializeCompiledCircuit(*ccPath)
+	if err != nil {
+		log.Fatalf("Error reading compiled circuit: %v", err)
+	}
+	proof, err := disclosure.Prove(cc, notes, *txnID)
+	if err != nil {
+		log.Fatalf("Error creating disclosure proof: %v", err)
+	}
+	if err := disclosure.WriteProof(proof, *outPath); err != nil {
+		log.Fatalf("Error writing disclosure proof: %v", err)
+	}
+	log.Printf("Disclosure proof written to %s", *outPath)
+}
+
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	vkPath := fs.String("vk", "DisclosureVerifyingKey.bin", "verifying key file")
+	proofPath := fs.String("proof", "disclosure.json", "proof file")
+	txnsPath := fs.String("txns", "", "subscriber transactions database file")
+	fs.Parse(args)
+	if *txnsPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	vk, err := disclosure.ReadVerifyingKey(*vkPath)
+	if err != nil {
+		log.Fatalf("Error reading verifying key: %v", err)
+	}
+	proof, err := disclosure.ReadProof(*proofPath)
+	if err != nil {
+		log.Fatalf("Error reading disclosure proof: %v", err)
+	}
+	source, err := disclosure.OpenSQLiteSource(*txnsPath)
+	if err != nil {
+		log.Fatalf("Error opening transactions database: %v", err)
+	}
+	defer source.Close()
+	report, err := disclosure.Verify(vk, proof, source)
+	if err != nil {
+		log.Fatalf("Disclosure proof NOT valid: %v", err)
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println("Disclosure proof valid")
+	fmt.Println(string(out))
+}
+
+// readNotes reads one note per line from the file, skipping empty lines
+func readNotes(filename string) ([]*models.Note, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var notes []*models.Note
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		note, _, err := models.ParseNote(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid note at line %d: %v", len(notes)+1, err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, scanner.Err()
+}
@@ -0,0 +1,595 @@
+// Command hermes makes deposits and withdrawals from the command line, without the
+// browser and a wallet app, for operators, testers and automation.
+//
+// Usage:
+//
+//	hermes deposit -profile testnet -amount 1 -mnemonic-file account.txt -note-out note.txt
+//	hermes deposit -profile localnet -setup <app setup dir> -amount 1 -kmd-wallet unencrypted-default-wallet -note-out note.txt
+//	hermes withdraw -profile testnet -txns-db txns.db -note note.txt -to <address> -change-out change.txt
+//	hermes deposit -profile testnet -amount 1 -mnemonic-file account.txt -seed-file seed.txt -txns-db txns.db -note-out note.txt
+//	hermes status -profile testnet -txns-db txns.db -note note.txt
+//	hermes stats -profile testnet
+//
+// The profile sets the network, the algod node, the kmd node and the app setup
+// directory, each can be overridden by its flag. Only testnet has an app setup in
+// the repo, the other profiles need -setup. The mnemonic can also be passed
+// in the HERMES_MNEMONIC environment variable.
+// Withdrawals and the note status need the txns database of the subscriber
+// service to build the merkle tree. The stats are read from the JSON API of a
+// frontend.
+// With -seed-file the deposit and change notes are derived from the 25 word seed
+// mnemonic in the file, so that the recover command can find them again from the
+// seed. The counter of the note is -counter, or the next
+// unused one found in the txns database when not set.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/hermes"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/protocol"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/kmd"
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/mnemonic"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// profile are the defaults for a network
+type profile struct {
+	network    string
+	algodURL   string
+	algodToken string
+	kmdURL     string
+	kmdToken   string
+	setupDir   string // empty if the repo has no setup of the app on the network
+	apiURL     string // frontend serving the JSON API
+}
+
+const localnetToken = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+var profiles = map[string]profile{
+	"localnet": {
+		network:    "localnet",
+		algodURL:   "http://localhost:4001",
+		algodToken: localnetToken,
+		kmdURL:     "http://localhost:4002",
+		kmdToken:   localnetToken,
+		apiURL:     "https://localhost:3000",
+	},
+	"testnet": {
+		network:  "testnet",
+		algodURL: "https://testnet-api.algonode.cloud",
+		setupDir: "avm/testnet",
+		apiURL:   "https://hermesvault.org",
+	},
+	"mainnet": {
+		network:  "mainnet",
+		algodURL: "https://mainnet-api.algonode.cloud",
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "deposit":
+		depositCmd(os.Args[2:])
+	case "withdraw":
+		withdrawCmd(os.Args[2:])
+	case "status":
+		statusCmd(os.Args[2:])
+	case "stats":
+		statsCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: hermes deposit|withdraw|status|stats [flags]")
+	os.Exit(2)
+}
+
+// options are the flags common to all commands
+type options struct {
+	profile    *string
+	algodURL   *string
+	algodToken *string
+	setupDir   *string
+}
+
+func addOptions(fs *flag.FlagSet) *options {
+	return &options{
+		profile:    fs.String("profile", "testnet", "localnet, testnet or mainnet"),
+		algodURL:   fs.String("algod", "", "algod URL, defaults to the profile one"),
+		algodToken: fs.String("algod-token", "", "algod token, defaults to the profile one"),
+		setupDir:   fs.String("setup", "", "app setup directory, defaults to the profile one"),
+	}
+}
+
+// resolve returns the profile with the overrides of the flags
+func (o *options) resolve() profile {
+	p, ok := profiles[*o.profile]
+	if !ok {
+		log.Fatalf("Unknown profile %q", *o.profile)
+	}
+	if *o.algodURL != "" {
+		p.algodURL = *o.algodURL
+		p.algodToken = *o.algodToken
+	} else if *o.algodToken != "" {
+		p.algodToken = *o.algodToken
+	}
+	if *o.setupDir != "" {
+		p.setupDir = *o.setupDir
+	}
+	if p.setupDir == "" {
+		log.Fatalf("No app setup for profile %s, set -setup", *o.profile)
+	}
+	return p
+}
+
+// newClient returns the hermes client for the profile. txnsDbPath can be empty
+// for the commands not needing the merkle tree
+func newClient(p profile, txnsDbPath string) *hermes.Client {
+	algodClient, err := algod.MakeClient(p.algodURL, p.algodToken)
+	if err != nil {
+		log.Fatalf("Error creating algod client: %v", err)
+	}
+	var tree hermes.TreeSource = noTree{}
+	if txnsDbPath != "" {
+		if tree, err = hermes.OpenSQLiteTree(txnsDbPath); err != nil {
+			log.Fatalf("Error opening txns database: %v", err)
+		}
+	}
+	c, err := hermes.New(hermes.Config{
+		Algod:    algodClient,
+		SetupDir: p.setupDir,
+		Network:  p.network,
+		Tree:     tree,
+	})
+	if err != nil {
+		log.Fatalf("Error creating hermes client: %v", err)
+	}
+	return c
+}
+
+// noTree is the tree source of the commands not needing the merkle tree
+type noTree struct{}
+
+func (noTree) Leaves() ([][]byte, error) {
+	return nil, errors.New("no txns database, set -txns-db")
+}
+
+func (noTree) Root() ([]byte, int, error) {
+	return nil, 0, errors.New("no txns database, set -txns-db")
+}
+
+func depositCmd(args []string) {
+	fs := flag.NewFlagSet("deposit", flag.ExitOnError)
+	opts := addOptions(fs)
+	amountInput := fs.String("amount", "", "amount to deposit in algo")
+	mnemonicPath := fs.String("mnemonic-file", "", "file with the mnemonic of the depositor")
+	kmdURL := fs.String("kmd", "", "kmd URL, defaults to the profile one")
+	kmdToken := fs.String("kmd-token", "", "kmd token, defaults to the profile one")
+	kmdWallet := fs.String("kmd-wallet", "", "kmd wallet to sign with")
+	kmdPassword := fs.String("kmd-password", "", "kmd wallet password")
+	from := fs.String("from", "", "depositor address, defaults to the first of the kmd wallet")
+	noteOut := fs.String("note-out", "", "file to write the deposit note to")
+	txnsDbPath := fs.String("txns-db", "",
+		"txns database of the subscriber service, to find the next seed counter")
+	seedOpts := addSeedOptions(fs)
+	fs.Parse(args)
+	if *amountInput == "" || *noteOut == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	amount, err := models.Input(*amountInput).ToAmount()
+	if err != nil {
+		log.Fatalf("Invalid amount: %v", err)
+	}
+
+	p := opts.resolve()
+	if *kmdURL != "" {
+		p.kmdURL, p.kmdToken = *kmdURL, *kmdToken
+	} else if *kmdToken != "" {
+		p.kmdToken = *kmdToken
+	}
+	var signer signer
+	switch {
+	case *kmdWallet != "":
+		signer, err = newKmdSigner(p, *kmdWallet, *kmdPassword, *from)
+	default:
+		signer, err = newMnemonicSigner(*mnemonicPath)
+	}
+	if err != nil {
+		log.Fatalf("Error loading the signing account: %v", err)
+	}
+
+	c := newClient(p, *txnsDbPath)
+	d, err := c.PrepareDeposit(amount, signer.address(), seedOpts.noteSeed(c))
+	if err != nil {
+		log.Fatalf("Error preparing deposit: %v", err)
+	}
+	// the note is written before sending, it is the only way to get the funds back
+	if err := writeNote(*noteOut, c.EncodeNote(d.Note)); err != nil {
+		log.Fatalf("Error writing deposit note: %v", err)
+	}
+	signedTxn, err := signer.sign(d.Txns[d.IndexTxnToSign])
+	if err != nil {
+		log.Fatalf("Error signing deposit transaction: %v", err)
+	}
+	note, err := c.SubmitDeposit(d, signedTxn)
+	if err != nil {
+		log.Fatalf("Deposit failed: %v\nThe note in %s is valid only if the deposit "+
+			"is later confirmed", err, *noteOut)
+	}
+	fmt.Printf("Deposited %s algo from %s\ntxn: %s\nleaf index: %d\nnote: %s\n",
+		amount.Algostring, d.Address, note.TxnID, note.LeafIndex, *noteOut)
+	printSeedCounter(note)
+}
+
+func withdrawCmd(args []string) {
+	fs := flag.NewFlagSet("withdraw", flag.ExitOnError)
+	opts := addOptions(fs)
+	txnsDbPath := fs.String("txns-db", "", "txns database of the subscriber service")
+	notePath := fs.String("note", "", "file with the note to withdraw from")
+	to := fs.String("to", "", "address receiving the withdrawal")
+	amountInput := fs.String("amount", "", "amount to withdraw in algo, defaults to the maximum")
+	changeOut := fs.String("change-out", "", "file to write the change note to")
+	seedOpts := addSeedOptions(fs)
+	fs.Parse(args)
+	if *txnsDbPath == "" || *notePath == "" || *to == "" || *changeOut == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	address, err := models.Input(*to).ToAddress()
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+
+	c := newClient(opts.resolve(), *txnsDbPath)
+	note := readNote(c, *notePath)
+	params, err := c.Params()
+	if err != nil {
+		log.Fatalf("Error reading protocol params: %v", err)
+	}
+	amount := params.QuoteWithdrawal(note).MaxWithdrawable
+	if *amountInput != "" {
+		if amount, err = models.Input(*amountInput).ToAmount(); err != nil {
+			log.Fatalf("Invalid amount: %v", err)
+		}
+	}
+
+	w, err := c.PrepareWithdrawal(amount, address, note, seedOpts.noteSeed(c))
+	if err != nil {
+		log.Fatalf("Error preparing withdrawal: %v", err)
+	}
+	// the change note is written before sending, it holds what is left of the note
+	if err := writeNote(*changeOut, c.EncodeNote(w.ChangeNote)); err != nil {
+		log.Fatalf("Error writing change note: %v", err)
+	}
+	changeNote, err := c.SubmitWithdrawal(w)
+	if err != nil {
+		var confirmationError *protocol.TxnConfirmationError
+		if errors.As(err, &confirmationError) &&
+			confirmationError.Type == protocol.ErrWaitTimeout {
+			log.Fatalf("Withdrawal not confirmed yet: %v\nThe change note was written "+
+				"to %s", err, *changeOut)
+		}
+		log.Fatalf("Withdrawal failed: %v\nThe change note in %s is valid only if the "+
+			"withdrawal is later confirmed", err, *changeOut)
+	}
+	fmt.Printf("Withdrew %s algo to %s\ntxn: %s\nchange: %s algo\nchange note: %s\n",
+		amount.Algostring, address, changeNote.TxnID,
+		models.MicroAlgosToAlgoString(changeNote.Amount), *changeOut)
+	printSeedCounter(changeNote)
+}
+
+func statusCmd(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	opts := addOptions(fs)
+	txnsDbPath := fs.String("txns-db", "", "txns database of the subscriber service")
+	notePath := fs.String("note", "", "file with the note")
+	fs.Parse(args)
+	if *txnsDbPath == "" || *notePath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c := newClient(opts.resolve(), *txnsDbPath)
+	status, err := c.NoteStatus(readNote(c, *notePath))
+	if err != nil {
+		log.Fatalf("Error getting note status: %v", err)
+	}
+	fmt.Printf("state: %s\nbalance: %s algo\n", status.State, status.Balance.Algostring)
+	if status.LeafIndex != models.EmptyLeafIndex {
+		fmt.Printf("leaf index: %d\n", status.LeafIndex)
+	}
+}
+
+func statsCmd(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	profileName := fs.String("profile", "testnet", "localnet, testnet or mainnet")
+	apiURL := fs.String("api", "", "frontend URL, defaults to the profile one")
+	asJSON := fs.Bool("json", false, "print the stats as JSON")
+	fs.Parse(args)
+	p, ok := profiles[*profileName]
+	if !ok {
+		log.Fatalf("Unknown profile %q", *profileName)
+	}
+	if *apiURL != "" {
+		p.apiURL = *apiURL
+	}
+	if p.apiURL == "" {
+		log.Fatalf("No frontend for profile %s, set -api", *profileName)
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Get(strings.TrimSuffix(p.apiURL, "/") + "/api/v1/stats")
+	if err != nil {
+		log.Fatalf("Error getting stats: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error getting stats: %s", resp.Status)
+	}
+	var stats models.ProtocolStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		log.Fatalf("Error decoding stats: %v", err)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	algo := models.MicroAlgosToAlgoString
+	fmt.Printf("TVL:                %s algo\n", algo(stats.TVL))
+	fmt.Printf("Deposits:           %d (%s algo)\n", stats.DepositCount,
+		algo(stats.DepositVolume))
+	fmt.Printf("Withdrawals:        %d (%s algo)\n", stats.WithdrawalCount,
+		algo(stats.WithdrawalVolume))
+	fmt.Printf("Fees:               %s algo\n", algo(stats.Fees))
+	fmt.Printf("Notes in the tree:  %d\n", stats.LeafCount)
+	fmt.Printf("Anonymity set size: %d\n", stats.AnonymitySetSize)
+	fmt.Printf("Updated at:         %s\n", stats.UpdatedAt.Format(time.RFC3339))
+}
+
+// seedOptions are the flags to derive the new note from a seed
+type seedOptions struct {
+	seedPath string
+	counter  string
+}
+
+func addSeedOptions(fs *flag.FlagSet) *seedOptions {
+	s := &seedOptions{}
+	fs.StringVar(&s.seedPath, "seed-file", "",
+		"file with the seed mnemonic to derive the new note from")
+	fs.StringVar(&s.counter, "counter", "",
+		"seed counter of the new note, defaults to the next unused one")
+	return s
+}
+
+// noteSeed returns the seed and counter of the new note, nil without -seed-file.
+// Without -counter the next unused counter is looked up in the txns database
+func (s *seedOptions) noteSeed(c *hermes.Client) *models.NoteSeed {
+	if s.seedPath == "" {
+		if s.counter != "" {
+			log.Fatalf("-counter needs -seed-file")
+		}
+		return nil
+	}
+	data, err := os.ReadFile(s.seedPath)
+	if err != nil {
+		log.Fatalf("Error reading seed file: %v", err)
+	}
+	seed, err := models.SeedFromMnemonic(string(data))
+	if err != nil {
+		log.Fatalf("Invalid seed mnemonic in %s: %v", s.seedPath, err)
+	}
+	if s.counter != "" {
+		counter, err := strconv.ParseUint(s.counter, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid counter: %v", err)
+		}
+		return &models.NoteSeed{Seed: seed, Counter: counter}
+	}
+	counter, err := c.NextSeedCounter(seed)
+	if err != nil {
+		log.Fatalf("Error finding the next seed counter, set -txns-db or -counter: %v",
+			err)
+	}
+	return &models.NoteSeed{Seed: seed, Counter: counter}
+}
+
+// printSeedCounter prints the seed counter of the note, if derived from a seed
+func printSeedCounter(note *models.Note) {
+	if note.Seeded {
+		fmt.Printf("seed counter: %d\n", note.Counter)
+	}
+}
+
+// readNote reads the note from the first non-empty line of the file
+func readNote(c *hermes.Client, filename string) *models.Note {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening note file: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		note, err := c.DecodeNote(line)
+		if err != nil {
+			log.Fatalf("Invalid note in %s: %v", filename, err)
+		}
+		return note
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Error reading note file: %v", err)
+	}
+	log.Fatalf("No note in %s", filename)
+	return nil
+}
+
+// writeNote writes the note to a new file, readable only by the user. It fails if
+// the file exists, not to overwrite another note
+func writeNote(filename, note string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(note + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// signer signs the deposit txn of the depositor
+type signer interface {
+	address() models.Address
+	sign(txn types.Transaction) (*types.SignedTxn, error)
+}
+
+// mnemonicSigner signs with the private key of a mnemonic
+type mnemonicSigner struct {
+	account crypto.Account
+}
+
+// newMnemonicSigner reads the mnemonic from the file, or from the HERMES_MNEMONIC
+// environment variable if filename is empty
+func newMnemonicSigner(filename string) (*mnemonicSigner, error) {
+	words := os.Getenv("HERMES_MNEMONIC")
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		words = string(data)
+	}
+	if words == "" {
+		return nil, errors.New("set -mnemonic-file, HERMES_MNEMONIC or -kmd-wallet")
+	}
+	sk, err := mnemonic.ToPrivateKey(strings.Join(strings.Fields(words), " "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %v", err)
+	}
+	account, err := crypto.AccountFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &mnemonicSigner{account: account}, nil
+}
+
+func (s *mnemonicSigner) address() models.Address {
+	return models.Address(s.account.Address.String())
+}
+
+func (s *mnemonicSigner) sign(txn types.Transaction) (*types.SignedTxn, error) {
+	_, stxBytes, err := crypto.SignTransaction(s.account.PrivateKey, txn)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignedTxn(stxBytes)
+}
+
+// kmdSigner signs with a key of a kmd wallet
+type kmdSigner struct {
+	client   kmd.Client
+	walletId string
+	password string
+	from     string
+}
+
+// newKmdSigner returns the signer for the wallet with the given name. If from is
+// empty the first key of the wallet is used
+func newKmdSigner(p profile, walletName, password, from string) (*kmdSigner, error) {
+	if p.kmdURL == "" {
+		return nil, errors.New("no kmd for this profile, set -kmd")
+	}
+	client, err := kmd.MakeClient(p.kmdURL, p.kmdToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kmd client: %v", err)
+	}
+	wallets, err := client.ListWallets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wallets: %v", err)
+	}
+	s := &kmdSigner{client: client, password: password, from: from}
+	for _, wallet := range wallets.Wallets {
+		if wallet.Name == walletName {
+			s.walletId = wallet.ID
+		}
+	}
+	if s.walletId == "" {
+		return nil, fmt.Errorf("no wallet named %s", walletName)
+	}
+	if s.from != "" {
+		return s, nil
+	}
+	err = s.withHandle(func(handle string) error {
+		keys, err := client.ListKeys(handle)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %v", err)
+		}
+		if len(keys.Addresses) == 0 {
+			return fmt.Errorf("no keys in wallet %s", walletName)
+		}
+		s.from = keys.Addresses[0]
+		return nil
+	})
+	return s, err
+}
+
+func (s *kmdSigner) address() models.Address {
+	return models.Address(s.from)
+}
+
+func (s *kmdSigner) sign(txn types.Transaction) (*types.SignedTxn, error) {
+	var signedTxn *types.SignedTxn
+	err := s.withHandle(func(handle string) error {
+		resp, err := s.client.SignTransaction(handle, s.password, txn)
+		if err != nil {
+			return err
+		}
+		signedTxn, err = decodeSignedTxn(resp.SignedTransaction)
+		return err
+	})
+	return signedTxn, err
+}
+
+// withHandle calls f with a wallet handle, released on return
+func (s *kmdSigner) withHandle(f func(handle string) error) error {
+	resp, err := s.client.InitWalletHandle(s.walletId, s.password)
+	if err != nil {
+		return fmt.Errorf("failed to init wallet handle: %v", err)
+	}
+	defer s.client.ReleaseWalletHandle(resp.WalletHandleToken)
+	return f(resp.WalletHandleToken)
+}
+
+func decodeSignedTxn(stxBytes []byte) (*types.SignedTxn, error) {
+	var signedTxn types.SignedTxn
+	if err := msgpack.Decode(stxBytes, &signedTxn); err != nil {
+		return nil, fmt.Errorf("failed to decode signed txn: %v", err)
+	}
+	return &signedTxn, nil
+}
@@ -0,0 +1,74 @@
+// Command notekeys manages the keys encrypting the note records in the internal
+// database.
+//
+// Usage:
+//
+//	notekeys generate -id 2
+//	notekeys rotate
+//
+// The generate command prints a new random key line to add to the keys file. The
+// key with the highest id encrypts new rows, so after adding a key stop the server
+// and run the rotate command to re-encrypt all the rows with it. The older keys
+// can be removed from the keys file once the rotation is done. The rotate command
+// also re-encrypts the rows written before the encrypted values were bound to
+// their row, run it once after upgrading, once the server has migrated the
+// database: it refuses to run on a database of another version.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "generate":
+		generateCmd(os.Args[2:])
+	case "rotate":
+		rotateCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: notekeys generate|rotate [flags]")
+	os.Exit(2)
+}
+
+func generateCmd(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	id := fs.Int64("id", 1, "id of the new key, higher than the existing ones")
+	fs.Parse(args)
+
+	line, err := db.GenerateNoteKey(*id)
+	if err != nil {
+		log.Fatalf("Error generating note key: %v", err)
+	}
+	fmt.Println(line)
+}
+
+func rotateCmd(args []string) {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	fs.Parse(args)
+
+	defer db.Close()
+	if !db.NoteKeysLoaded() {
+		log.Fatalf("Error rotating note keys: %v", db.ErrNoNoteKeys)
+	}
+	if err := db.CheckSchemaVersion(); err != nil {
+		log.Fatalf("Error rotating note keys: %v", err)
+	}
+	n, err := db.RotateNoteKeys()
+	if err != nil {
+		log.Fatalf("Error rotating note keys: %v", err)
+	}
+	log.Printf("Note keys rotated, %d rows re-encrypted", n)
+}
@@ -0,0 +1,139 @@
+// Command receipts exports the signed compliance reports linking a withdrawal to
+// its original deposit, built from the receipts in the internal database.
+//
+// Usage:
+//
+//	receipts keygen -key receipts.key -pub receipts.pub
+//	receipts export -key receipts.key -txn <withdrawal txn id> -ref <case reference> -out report.json
+//	receipts verify -pub receipts.pub -report report.json
+//
+// The export command needs the note keys to decrypt the receipts and the signing
+// key to sign the report, and refuses to run on an internal database not migrated
+// to the version of the binary. The verify command only needs the public key.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/receipts"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "keygen":
+		keygenCmd(os.Args[2:])
+	case "export":
+		exportCmd(os.Args[2:])
+	case "verify":
+		verifyCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: receipts keygen|export|verify [flags]")
+	os.Exit(2)
+}
+
+func keygenCmd(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyPath := fs.String("key", "receipts.key", "private key output file")
+	pubPath := fs.String("pub", "receipts.pub", "public key output file")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*keyPath); err == nil {
+		log.Fatalf("Error generating signing key: %s already exists", *keyPath)
+	}
+	if err := receipts.GenerateKey(*keyPath, *pubPath); err != nil {
+		log.Fatalf("Error generating signing key: %v", err)
+	}
+	log.Printf("Signing key written to %s, public key to %s", *keyPath, *pubPath)
+}
+
+func exportCmd(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	keyPath := fs.String("key", "receipts.key", "private signing key file")
+	txnID := fs.String("txn", "", "id of the withdrawal txn to report")
+	reference := fs.String("ref", "", "reference of the legal request")
+	outPath := fs.String("out", "report.json", "signed report output file")
+	fs.Parse(args)
+	if *txnID == "" || *reference == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	key, err := receipts.ReadPrivateKey(*keyPath)
+	if err != nil {
+		log.Fatalf("Error reading signing key: %v", err)
+	}
+	defer db.Close()
+	if err := db.CheckSchemaVersion(); err != nil {
+		log.Fatalf("Error exporting report: %v", err)
+	}
+	if !db.NoteKeysLoaded() {
+		log.Fatalf("Error exporting report: %v", db.ErrNoNoteKeys)
+	}
+	report, err := receipts.Build(dbSource{}, *txnID, *reference)
+	if err != nil {
+		log.Fatalf("Error building report: %v", err)
+	}
+	signed, err := receipts.Sign(report, key)
+	if err != nil {
+		log.Fatalf("Error signing report: %v", err)
+	}
+	if err := receipts.WriteSignedReport(signed, *outPath); err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	log.Printf("Report for withdrawal %s (ref %s) written to %s, %d withdrawals back "+
+		"to deposit %s", *txnID, *reference, *outPath, len(report.Withdrawals),
+		report.Deposit.TxnID)
+}
+
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	pubPath := fs.String("pub", "receipts.pub", "public key file")
+	reportPath := fs.String("report", "report.json", "signed report file")
+	fs.Parse(args)
+
+	pub, err := receipts.ReadPublicKey(*pubPath)
+	if err != nil {
+		log.Fatalf("Error reading public key: %v", err)
+	}
+	signed, err := receipts.ReadSignedReport(*reportPath)
+	if err != nil {
+		log.Fatalf("Error reading report: %v", err)
+	}
+	report, err := receipts.Verify(signed, pub)
+	if err != nil {
+		log.Fatalf("Report NOT valid: %v", err)
+	}
+	fmt.Printf("Report valid: withdrawal %s links back to deposit %s through %d "+
+		"withdrawals (ref %s, created %s)\n", report.WithdrawalTxnID,
+		report.Deposit.TxnID, len(report.Withdrawals), report.Reference,
+		report.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+}
+
+// dbSource provides the receipts from the internal database and the public txn
+// data from the transactions database
+type dbSource struct{}
+
+func (dbSource) GetReceiptByWithdrawal(txnID string) (*models.Receipt, error) {
+	return db.GetReceiptByWithdrawal(txnID)
+}
+
+func (dbSource) GetTxnByID(txnID string) (*models.Txn, error) {
+	return db.GetTxnByID(txnID)
+}
+
+func (dbSource) GetTxnByCommitment(commitment []byte) (*models.Txn, error) {
+	return db.GetTxnByCommitment(commitment)
+}
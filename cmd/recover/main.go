@@ -0,0 +1,79 @@
+// Command recover rebuilds the unspent notes derived from a seed mnemonic.
+//
+// Usage:
+//
+//	recover [-gap 20] < mnemonic.txt
+//
+// The 25 word seed mnemonic is read from standard input. The recovered unspent
+// notes are printed with their balances, together with the next counter to use
+// for new notes derived from the same seed.
+//
+// The change notes are found with the fees of the protocol parameters recorded by
+// the frontend, the compiled-in and the on-chain ones. The internal database must
+// be migrated to the version of the binary, by starting the server first.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/recovery"
+)
+
+func main() {
+	gap := flag.Int("gap", recovery.DefaultGapLimit,
+		"consecutive unused counters after which to stop")
+	flag.Parse()
+
+	fmt.Fprintln(os.Stderr, "Enter the seed mnemonic:")
+	reader := bufio.NewReader(os.Stdin)
+	phrase, err := reader.ReadString('\n')
+	if err != nil && phrase == "" {
+		log.Fatalf("Error reading seed mnemonic: %v", err)
+	}
+	seed, err := models.SeedFromMnemonic(phrase)
+	if err != nil {
+		log.Fatalf("Error reading seed mnemonic: %v", err)
+	}
+
+	defer db.Close()
+	if err := db.CheckSchemaVersion(); err != nil {
+		log.Fatalf("Error checking the internal database: %v", err)
+	}
+	params, err := db.GetProtocolParamsHistory()
+	if err != nil {
+		log.Fatalf("Error reading protocol params history: %v", err)
+	}
+	params = append(params, models.DefaultProtocolParams)
+	if live, err := avm.ReadProtocolParams(); err == nil {
+		params = append(params, live)
+	} else {
+		log.Printf("Error reading the on-chain protocol params: %v", err)
+	}
+	result, err := recovery.Recover(seed, txnsDbSource{}, params, *gap)
+	if err != nil {
+		log.Fatalf("Error recovering notes: %v", err)
+	}
+
+	fmt.Printf("App %d: found %d notes, %d unspent\n", avm.App.Id, len(result.Notes),
+		len(result.Unspent()))
+	for _, n := range result.Unspent() {
+		fmt.Printf("\ncounter %d, leaf %d, %s algo\n%s\n", n.Counter, n.Note.LeafIndex,
+			models.MicroAlgosToAlgoString(n.Note.Amount), n.Note.Text())
+	}
+	fmt.Printf("\nTotal balance: %s algo\n", models.MicroAlgosToAlgoString(result.Balance()))
+	fmt.Printf("Next counter: %d\n", result.NextCounter)
+}
+
+// txnsDbSource provides the public txn data from the transactions database
+type txnsDbSource struct{}
+
+func (txnsDbSource) GetAllTxns() ([]*models.Txn, error) {
+	return db.GetAllTxns()
+}
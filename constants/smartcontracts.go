@@ -1,4 +1,6 @@
-package config
+// Package constants holds the constants of the app and its protocol. Unlike config
+// it reads no settings, so that the hermes package can embed the protocol
+package constants
 
 import (
 	"github.com/consensys/gnark-crypto/ecc"
@@ -10,6 +12,8 @@ const (
 	Curve               = ecc.BN254
 	RandomNonceByteSize = 31
 
+	// compiled-in defaults of the protocol parameters, the live values are read
+	// from the app global state when the contract exposes them
 	DepositMinimumAmount = 1e6  // 1 algo
 	WithDrawalFeeDivisor = 1000 // 0.1% (we divide by this to get the fee)
 	WithdrawalMinimumFee = 1e5  // 0.1 algo
@@ -21,6 +25,9 @@ const (
 	UserDepositTxnIndex = 1 // index of the user pay txn in the deposit txn group (0 based)
 )
 
+// Number of rounds to wait for a transaction to be confirmed
+const WaitRounds = 30
+
 // transaction fees required
 const (
 	// # top level transactions needed for logicsig verifier opcode budget
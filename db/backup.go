@@ -0,0 +1,329 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Snapshots of the internal database are named internal-<UTC time>.db, with
+// nanoseconds so that they do not collide and sort by time, and each has a
+// <name>.sha256 file with its checksum in the sha256sum format
+
+const (
+	snapshotPrefix     = "internal-"
+	snapshotExt        = ".db"
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+	checksumExt        = ".sha256"
+)
+
+// errors backing up and restoring the internal database
+var (
+	ErrSnapshotChecksum  = errors.New("snapshot does not match its checksum")
+	ErrDatabaseInUse     = errors.New("the internal database is in use, stop the server")
+	ErrBackupUnsupported = errors.New("online backup is only supported for SQLite, " +
+		"back up PostgreSQL with its own tools")
+)
+
+// Backup copies a consistent snapshot of the internal database to dir using the
+// SQLite online backup API, which does not block the writers, and returns its path
+func Backup(dir string) (string, error) {
+	if internalDb.dialect.driver != sqliteDriver {
+		return "", ErrBackupUnsupported
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotTimeFormat) + snapshotExt
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("snapshot %s already exists", path)
+	}
+	tmpPath := path + ".tmp"
+	defer os.Remove(tmpPath)
+
+	if err := backupTo(tmpPath); err != nil {
+		return "", err
+	}
+	sum, err := fileChecksum(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+	checksum := fmt.Sprintf("%s  %s\n", sum, name)
+	if err := os.WriteFile(path+checksumExt, []byte(checksum), 0600); err != nil {
+		return "", fmt.Errorf("failed to write snapshot checksum: %w", err)
+	}
+	return path, nil
+}
+
+// backupTo copies the internal database to a new database file at path
+func backupTo(path string) error {
+	ctx := context.Background()
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer destConn.Close()
+	srcConn, err := internalDb.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to internal database: %w", err)
+	}
+	defer srcConn.Close()
+
+	err = destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			backup, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main",
+				srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			// copy all pages in one step, the source is only read locked meanwhile
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	// the copy keeps the WAL mode of the source, a snapshot is a single file
+	if _, err := destConn.ExecContext(ctx, "PRAGMA journal_mode = DELETE"); err != nil {
+		return fmt.Errorf("failed to set snapshot journal mode: %w", err)
+	}
+	return nil
+}
+
+// PruneBackups removes the oldest snapshots in dir, keeping the last keep ones
+func PruneBackups(dir string, keep int) error {
+	snapshots, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) <= keep {
+		return nil
+	}
+	for _, path := range snapshots[:len(snapshots)-keep] {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+		if err := os.Remove(path + checksumExt); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove snapshot checksum: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListBackups returns the paths of the snapshots in dir, from the oldest
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+	var snapshots []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, snapshotPrefix) &&
+			strings.HasSuffix(name, snapshotExt) {
+			snapshots = append(snapshots, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// StartBackupRoutine starts a goroutine that periodically takes a snapshot of the
+// internal database to dir, keeping the last keep ones.
+// It returns a cancel function that can be used to stop the routine.
+func StartBackupRoutine(ctx context.Context, dir string, interval time.Duration,
+	keep int) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				path, err := Backup(dir)
+				if err != nil {
+					log.Printf("Error backing up internal database: %v", err)
+					continue
+				}
+				log.Printf("Internal database backed up to %s", path)
+				if err := PruneBackups(dir, keep); err != nil {
+					log.Printf("Error pruning internal database backups: %v", err)
+				}
+			case <-ctx.Done():
+				log.Println("Backup routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// VerifyBackup checks the snapshot at path against its checksum, runs the SQLite
+// integrity check on it and returns its schema version. It returns
+// ErrDatabaseNewer if the snapshot is newer than this binary
+func VerifyBackup(path string) (int, error) {
+	data, err := os.ReadFile(path + checksumExt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot checksum: %w", err)
+	}
+	expected, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return 0, err
+	}
+	if sum != expected {
+		return 0, ErrSnapshotChecksum
+	}
+
+	snapshot, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer snapshot.Close()
+	var result string
+	if err := snapshot.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return 0, fmt.Errorf("failed to check snapshot integrity: %w", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("snapshot integrity check failed: %s", result)
+	}
+	var version int
+	err = snapshot.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).
+		Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot schema version: %w", err)
+	}
+	if latest := sqliteMigrations[len(sqliteMigrations)-1].version; version > latest {
+		return 0, fmt.Errorf("%w: snapshot version %d, binary version %d",
+			ErrDatabaseNewer, version, latest)
+	}
+	return version, nil
+}
+
+// RestoreBackup verifies the snapshot at path and replaces the internal database
+// with it, returning the path the live database is kept at, with a .pre-restore-
+// <UTC time> suffix. It is meant to be run offline: it returns ErrDatabaseInUse if
+// another connection, such as a running server, has the database open. The
+// restored database is brought up to date by Migrate at the next start
+func RestoreBackup(path string) (string, error) {
+	if internalDb.dialect.driver != sqliteDriver {
+		return "", ErrBackupUnsupported
+	}
+	if _, err := VerifyBackup(path); err != nil {
+		return "", err
+	}
+	tmpPath := internalDbPath + ".restore"
+	defer os.Remove(tmpPath)
+	if err := copyFile(path, tmpPath); err != nil {
+		return "", fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+
+	conn, err := lockInternalDb()
+	if err != nil {
+		return "", err
+	}
+	defer internalDb.Close()
+	defer conn.Close()
+	preRestorePath := internalDbPath + ".pre-restore-" +
+		time.Now().UTC().Format(snapshotTimeFormat)
+	if err := os.Rename(internalDbPath, preRestorePath); err != nil {
+		return "", fmt.Errorf("failed to move the live database: %w", err)
+	}
+	if err := os.Rename(tmpPath, internalDbPath); err != nil {
+		return "", fmt.Errorf("failed to replace the live database: %w", err)
+	}
+	return preRestorePath, nil
+}
+
+// lockInternalDb takes an exclusive lock on the internal database, held until the
+// returned connection is closed. Leaving the WAL mode checkpoints the WAL into the
+// database file and fails if any other connection has the database open
+func lockInternalDb() (*sql.Conn, error) {
+	ctx := context.Background()
+	// close the idle connections of the pool, they would hold the WAL open
+	internalDb.SetMaxIdleConns(0)
+	conn, err := internalDb.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to internal database: %w", err)
+	}
+	var mode string
+	_, err = conn.ExecContext(ctx, "PRAGMA locking_mode = EXCLUSIVE")
+	if err == nil {
+		err = conn.QueryRowContext(ctx, "PRAGMA journal_mode = DELETE").Scan(&mode)
+	}
+	if err == nil && mode != "delete" {
+		err = fmt.Errorf("journal mode still %s", mode)
+	}
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", ErrDatabaseInUse, err)
+	}
+	return conn, nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 of the file
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyFile copies src to dst, syncing dst to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
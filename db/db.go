@@ -2,105 +2,108 @@
 package db
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
 	"log"
 
 	"github.com/giuliop/HermesVault-frontend/models"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-func RegisterUnconfirmedNote(n *models.Note) (int64, error) {
-	sql := `INSERT INTO unconfirmed_notes (
-		commitment,
-		nullifier,
-		txn_id
-		) VALUES (?, ?, ?)`
-	result, err := internalDb.Exec(sql,
-		n.Commitment(),
-		n.Nullifier(),
-		n.TxnID,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to register unconfirmed note %v: %w", n, err)
-	}
-	leafIndex, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
-	}
-	return leafIndex, nil
+// RegisterUnconfirmedNote saves a note whose txn is not confirmed yet, encrypting
+// its linking data, and returns its id. For the change note of a withdrawal
+// spentCommitment is the commitment of the spent note, recorded in the withdrawal
+// receipt once the note is confirmed; it is nil for deposits
+func RegisterUnconfirmedNote(n *models.Note, spentCommitment []byte) (int64, error) {
+	return unconfirmedNotes.RegisterUnconfirmedNote(n, spentCommitment)
 }
 
+// SaveNote saves a confirmed note, encrypting its linking data
 func SaveNote(n *models.Note) error {
 	isNoteConfirmed := n.TxnID != models.EmptyTxnId &&
 		n.LeafIndex != models.EmptyLeafIndex
 
 	if !isNoteConfirmed {
-		return fmt.Errorf("malformed confirmed note: %v", n)
-	}
-
-	sql := `INSERT INTO notes (leaf_index, commitment, txn_id, nullifier) VALUES (?, ?, ?, ?)`
-	_, err := internalDb.Exec(sql, n.LeafIndex, n.Commitment(), n.TxnID, n.Nullifier())
-	if err != nil {
-		return fmt.Errorf("failed to insert note: %w", err)
-	}
-
-	// TODO: remove after removel of debug_notes table before MainNet
-	debugSql := `INSERT INTO debug_notes (leaf_index, text) VALUES (?, ?)`
-	_, err = internalDb.Exec(debugSql, n.LeafIndex, n.Text())
-	if err != nil {
-		return fmt.Errorf("failed to insert debug note: %w", err)
+		return fmt.Errorf("malformed confirmed note: %v", n.TxnID)
 	}
+	return notes.SaveNote(n)
+}
 
-	return nil
+// IsNoteSaved returns true if the note inserted by the txn is in the notes table
+func IsNoteSaved(txnId string) (bool, error) {
+	return notes.IsNoteSaved(txnId)
 }
 
 // GetLeafIndexByCommitment returns the leaf index of a note given its commitment
 // error will be sql.ErrNoRows if no rows are returned
 func GetLeafIndexByCommitment(commitment []byte) (int, error) {
-	query := `SELECT leaf_index FROM txns WHERE commitment = ?`
-	var index int
-	err := txnsDb.QueryRow(query, commitment).Scan(&index)
-	return index, err
+	return txns.GetLeafIndexByCommitment(commitment)
 }
 
 // GetAllLeavesCommitments returns all leaf commitments in the database
 func GetAllLeavesCommitments() ([][]byte, error) {
-	query := `SELECT commitment FROM txns ORDER BY leaf_index ASC`
-	rows, err := txnsDb.Query(query)
-	if err != nil {
-		return nil, err
+	return txns.GetAllLeavesCommitments()
+}
+
+// GetAllTxns returns all the txns in the database ordered by leaf index
+func GetAllTxns() ([]*models.Txn, error) {
+	return txns.GetAllTxns()
+}
+
+// GetTxnByID returns the txn with the given id
+// error will be sql.ErrNoRows if no rows are returned
+func GetTxnByID(txnID string) (*models.Txn, error) {
+	return txns.GetTxnByID(txnID)
+}
+
+// GetTxnByCommitment returns the txn that inserted the note with the given commitment
+// error will be sql.ErrNoRows if no rows are returned
+func GetTxnByCommitment(commitment []byte) (*models.Txn, error) {
+	return txns.GetTxnByCommitment(commitment)
+}
+
+// GetTxnBySpentNullifier returns the withdrawal txn that spent the note with the
+// given nullifier
+// error will be sql.ErrNoRows if no rows are returned
+func GetTxnBySpentNullifier(nullifier []byte) (*models.Txn, error) {
+	return txns.GetTxnBySpentNullifier(nullifier)
+}
+
+// SaveAssociationRoot records the root of the association set tree built with the
+// given policy, unless it is the same as the last root recorded for that policy.
+// It returns true if a new root was recorded
+func SaveAssociationRoot(policy string, root []byte, leafCount int) (bool, error) {
+	var lastRoot []byte
+	err := internalDb.QueryRow(`SELECT root FROM association_roots WHERE policy = ?
+		ORDER BY id DESC LIMIT 1`, policy).Scan(&lastRoot)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to get last association root: %w", err)
 	}
-	defer rows.Close()
-
-	var commitments [][]byte
-	for rows.Next() {
-		var commitment []byte
-		if err := rows.Scan(&commitment); err != nil {
-			return nil, err
-		}
-		commitments = append(commitments, commitment)
+	if bytes.Equal(lastRoot, root) {
+		return false, nil
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
+	_, err = internalDb.Exec(`INSERT INTO association_roots (policy, root, leaf_count)
+		VALUES (?, ?, ?)`, policy, root, leafCount)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert association root: %w", err)
 	}
-
-	return commitments, nil
+	return true, nil
 }
 
 // GetRoot returns the Merkle root and the number of leaves in the tree
 func GetRoot() (root []byte, leafCount int, err error) {
-	query := `SELECT value, leaf_count FROM roots`
-	err = txnsDb.QueryRow(query).Scan(&root, &leafCount)
-	return
+	return txns.GetRoot()
+}
+
+// GetWatermark returns the last round processed by the subscriber service
+func GetWatermark() (uint64, error) {
+	return txns.GetWatermark()
 }
 
 // DeleteUnconfirmedNote deletes an unconfirmed note from the database.
 // It does not return an error if it fails
 func DeleteUnconfirmedNote(id int64) {
-	_, err := internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
-	if err != nil {
+	if err := unconfirmedNotes.DeleteUnconfirmedNote(id); err != nil {
 		log.Printf("Error deleting unconfirmed note: %v", err)
 	}
 }
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// The queries are written for SQLite, with ? placeholders. sqlDb and sqlTx wrap
+// the database/sql handles to rewrite them for the backend in use, so that the
+// same queries run on PostgreSQL
+
+// supported database drivers
+const (
+	sqliteDriver   = "sqlite3"
+	postgresDriver = "postgres"
+)
+
+// dialect describes the SQL differences of a backend
+type dialect struct {
+	driver string
+	// numbered is true if the placeholders are numbered as $1, $2...
+	numbered bool
+	// now is the SQL expression of the current UTC time as 2006-01-02 15:04:05
+	now string
+	// tableOptions are appended to the CREATE TABLE statements
+	tableOptions string
+	// tableExists is the query counting the tables with the name given as argument
+	tableExists string
+}
+
+var (
+	sqliteDialect = dialect{
+		driver:       sqliteDriver,
+		now:          "CURRENT_TIMESTAMP",
+		tableOptions: "STRICT",
+		tableExists:  `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+	}
+	postgresDialect = dialect{
+		driver:   postgresDriver,
+		numbered: true,
+		now:      "to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')",
+		tableExists: `SELECT COUNT(*) FROM information_schema.tables
+			WHERE table_schema = current_schema() AND table_name = ?`,
+	}
+)
+
+// rebind rewrites the ? placeholders of a query for the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sqlDb is a database handle rewriting the queries for its dialect
+type sqlDb struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *sqlDb) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDb) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDb) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+// Begin starts a transaction rewriting the queries for the dialect
+func (db *sqlDb) Begin() (*sqlTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// sqlTx is a transaction rewriting the queries for its dialect
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
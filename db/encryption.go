@@ -0,0 +1,409 @@
+package db
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// The note records and receipts in the internal database link deposits to
+// withdrawals, so their commitments, nullifiers and txn ids are encrypted with
+// AES-256-GCM. Each row stores the id of the key that encrypted it, rows with a
+// NULL key id are plaintext rows written before encryption was introduced.
+// Lookups use blind indexes, the HMAC-SHA256 of the value with a key derived from
+// the encryption key.
+// Each encrypted value is authenticated with its column and its row, identified by
+// the blind index of the row txn id, so that it cannot be moved to another column
+// or row. The rows encrypted before the row binding have aad_version 1 and are
+// authenticated with their column only, until RotateNoteKeys re-encrypts them.
+//
+// The keys are read from config.NoteKeysPath, or from the config.NoteKeysEnv
+// environment variable, one key per line as <id>:<64 hex chars>. The key with the
+// highest id encrypts new rows, the others are kept to read older rows until
+// RotateNoteKeys re-encrypts them with the highest one.
+
+// ErrNoNoteKeys is returned when reading or writing note records without keys
+var ErrNoNoteKeys = errors.New("no note encryption keys configured")
+
+// noteKeySize is the size of the note keys in bytes
+const noteKeySize = 32
+
+// rowBoundAAD is the aad_version of the rows whose values are authenticated with
+// their row too
+const rowBoundAAD = 2
+
+// noteKey is a note encryption key with its derived keys
+type noteKey struct {
+	id       int64
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// noteKeyring holds the note keys by id and the active key encrypting new rows
+type noteKeyring struct {
+	keys   map[int64]*noteKey
+	active *noteKey
+}
+
+// keyring is the note keyring, nil if no keys are configured
+var keyring *noteKeyring
+
+// NoteKeysLoaded returns true if the note encryption keys are configured
+func NoteKeysLoaded() bool {
+	return keyring != nil
+}
+
+// SetNoteKeys replaces the configured note keys with the given ones, in the format
+// of the keys file
+func SetNoteKeys(text string) error {
+	kr, err := parseNoteKeys(text)
+	if err != nil {
+		return err
+	}
+	keyring = kr
+	return nil
+}
+
+// loadNoteKeyring loads the note keys from the keys file or the environment.
+// It returns a nil keyring if none is configured
+func loadNoteKeyring() (*noteKeyring, error) {
+	var text string
+	switch {
+	case config.NoteKeysPath != "":
+		data, err := os.ReadFile(config.NoteKeysPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read note keys file: %w", err)
+		}
+		text = string(data)
+	case os.Getenv(config.NoteKeysEnv) != "":
+		text = strings.ReplaceAll(os.Getenv(config.NoteKeysEnv), ",", "\n")
+	default:
+		return nil, nil
+	}
+	return parseNoteKeys(text)
+}
+
+// parseNoteKeys parses the note keys, one per line as <id>:<hex key>, skipping
+// empty lines and comments
+func parseNoteKeys(text string) (*noteKeyring, error) {
+	kr := &noteKeyring{keys: map[int64]*noteKey{}}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idStr, hexKey, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.New("malformed note key line, expected <id>:<hex key>")
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid note key id: %s", idStr)
+		}
+		secret, err := hex.DecodeString(strings.TrimSpace(hexKey))
+		if err != nil || len(secret) != noteKeySize {
+			return nil, fmt.Errorf("note key %d must be %d hex encoded bytes", id, noteKeySize)
+		}
+		if _, ok := kr.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate note key id %d", id)
+		}
+		key, err := newNoteKey(id, secret)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = key
+		if kr.active == nil || id > kr.active.id {
+			kr.active = key
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if kr.active == nil {
+		return nil, errors.New("no note keys found")
+	}
+	return kr, nil
+}
+
+// newNoteKey derives the encryption and blind index keys from a note key
+func newNoteKey(id int64, secret []byte) (*noteKey, error) {
+	block, err := aes.NewCipher(deriveKey(secret, "hermesvault-note-encryption"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &noteKey{
+		id:       id,
+		aead:     aead,
+		indexKey: deriveKey(secret, "hermesvault-note-blind-index"),
+	}, nil
+}
+
+// GenerateNoteKey returns a new random note key line with the given id
+func GenerateNoteKey(id int64) (string, error) {
+	secret := make([]byte, noteKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return fmt.Sprintf("%d:%s", id, hex.EncodeToString(secret)), nil
+}
+
+// deriveKey derives a key for the given purpose from a secret
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// seal encrypts value with the key, binding it to the column and the row it is
+// stored in. row is nil for the values bound to their column only
+func (k *noteKey) seal(column string, row, value []byte) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return k.aead.Seal(nonce, nonce, value, additionalData(column, row)), nil
+}
+
+// open decrypts a value sealed for the given column and row
+func (k *noteKey) open(column string, row, sealed []byte) ([]byte, error) {
+	if sealed == nil {
+		return nil, nil
+	}
+	if len(sealed) < k.aead.NonceSize() {
+		return nil, fmt.Errorf("%s ciphertext too short", column)
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	value, err := k.aead.Open(nil, nonce, ciphertext, additionalData(column, row))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with key %d: %w", column, k.id, err)
+	}
+	return value, nil
+}
+
+// additionalData returns the GCM additional data binding a value to its column
+// and row
+func additionalData(column string, row []byte) []byte {
+	if row == nil {
+		return []byte(column)
+	}
+	return append([]byte(column+"\x00"), row...)
+}
+
+// blindIndex returns the blind index of a value for the given column
+func (k *noteKey) blindIndex(column string, value []byte) []byte {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(column))
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// activeKey returns the key encrypting new rows
+func activeKey() (*noteKey, error) {
+	if keyring == nil {
+		return nil, ErrNoNoteKeys
+	}
+	return keyring.active, nil
+}
+
+// keyById returns the key with the given id, or nil for a plaintext row
+func keyById(keyId sql.NullInt64) (*noteKey, error) {
+	if !keyId.Valid {
+		return nil, nil
+	}
+	if keyring == nil {
+		return nil, ErrNoNoteKeys
+	}
+	key, ok := keyring.keys[keyId.Int64]
+	if !ok {
+		return nil, fmt.Errorf("note key %d not found", keyId.Int64)
+	}
+	return key, nil
+}
+
+// blindIndexes returns the blind indexes of a value with every key, to look up
+// rows encrypted by any of them
+func blindIndexes(column string, value []byte) ([]any, error) {
+	if keyring == nil {
+		return nil, ErrNoNoteKeys
+	}
+	indexes := make([]any, 0, len(keyring.keys))
+	for _, key := range keyring.keys {
+		indexes = append(indexes, key.blindIndex(column, value))
+	}
+	return indexes, nil
+}
+
+// noteRecord is the linking data of a note as stored in the internal database
+type noteRecord struct {
+	keyId      sql.NullInt64
+	commitment []byte
+	nullifier  []byte
+	txnId      string // base64 encoded ciphertext if encrypted
+	txnIdIndex []byte
+	aadVersion int
+	// spentCommitment is the commitment of the note spent by the withdrawal of an
+	// unconfirmed change note, nil otherwise
+	spentCommitment []byte
+}
+
+// row returns the row identity the record values are bound to, nil if they are
+// bound to their column only
+func (r noteRecord) row() []byte {
+	if r.aadVersion < rowBoundAAD {
+		return nil
+	}
+	return r.txnIdIndex
+}
+
+// sealNoteRecord encrypts the linking data of a note with the active key, and
+// returns it with the blind indexes of its commitment and txn id
+func sealNoteRecord(commitment, nullifier []byte, txnId string) (
+	r noteRecord, commitmentIndex, txnIdIndex []byte, err error) {
+	key, err := activeKey()
+	if err != nil {
+		return noteRecord{}, nil, nil, err
+	}
+	r.keyId = sql.NullInt64{Int64: key.id, Valid: true}
+	r.txnIdIndex = key.blindIndex("txn_id", []byte(txnId))
+	r.aadVersion = rowBoundAAD
+	if r.commitment, err = key.seal("commitment", r.row(), commitment); err != nil {
+		return noteRecord{}, nil, nil, err
+	}
+	if r.nullifier, err = key.seal("nullifier", r.row(), nullifier); err != nil {
+		return noteRecord{}, nil, nil, err
+	}
+	sealedTxnId, err := key.seal("txn_id", r.row(), []byte(txnId))
+	if err != nil {
+		return noteRecord{}, nil, nil, err
+	}
+	r.txnId = base64.StdEncoding.EncodeToString(sealedTxnId)
+	return r, key.blindIndex("commitment", commitment), r.txnIdIndex, nil
+}
+
+// open decrypts the linking data of a note record
+func (r noteRecord) open() (commitment, nullifier []byte, txnId string, err error) {
+	key, err := keyById(r.keyId)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if key == nil {
+		return r.commitment, r.nullifier, r.txnId, nil
+	}
+	if commitment, err = key.open("commitment", r.row(), r.commitment); err != nil {
+		return nil, nil, "", err
+	}
+	if nullifier, err = key.open("nullifier", r.row(), r.nullifier); err != nil {
+		return nil, nil, "", err
+	}
+	sealedTxnId, err := base64.StdEncoding.DecodeString(r.txnId)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to decode txn_id ciphertext: %w", err)
+	}
+	txnIdBytes, err := key.open("txn_id", r.row(), sealedTxnId)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return commitment, nullifier, string(txnIdBytes), nil
+}
+
+// sealSpentCommitment encrypts the spent commitment of a record sealed by
+// sealNoteRecord, with its key and row
+func (r *noteRecord) sealSpentCommitment(spentCommitment []byte) error {
+	key, err := keyById(r.keyId)
+	if err != nil {
+		return err
+	}
+	r.spentCommitment, err = key.seal("spent_commitment", r.row(), spentCommitment)
+	return err
+}
+
+// openSpentCommitment decrypts the spent commitment of a record
+func (r noteRecord) openSpentCommitment() ([]byte, error) {
+	key, err := keyById(r.keyId)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return r.spentCommitment, nil
+	}
+	return key.open("spent_commitment", r.row(), r.spentCommitment)
+}
+
+// sealText encrypts a text with the active key for the column and row, returning
+// it base64 encoded
+func sealText(column string, row []byte, text string) (sql.NullInt64, string, error) {
+	key, err := activeKey()
+	if err != nil {
+		return sql.NullInt64{}, "", err
+	}
+	sealed, err := key.seal(column, row, []byte(text))
+	if err != nil {
+		return sql.NullInt64{}, "", err
+	}
+	return sql.NullInt64{Int64: key.id, Valid: true},
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openText decrypts a text encrypted by sealText
+func openText(keyId sql.NullInt64, column string, row []byte, text string) (string, error) {
+	key, err := keyById(keyId)
+	if err != nil || key == nil {
+		return text, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s ciphertext: %w", column, err)
+	}
+	value, err := key.open(column, row, sealed)
+	return string(value), err
+}
+
+// SealSession encrypts a user session with the active key, binding it to the
+// session id. The key id is prepended so that the session can still be opened
+// after a key rotation
+func SealSession(sessionId, data []byte) ([]byte, error) {
+	key, err := activeKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := key.seal("session", sessionId, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint64(nil, uint64(key.id)), sealed...), nil
+}
+
+// OpenSession decrypts a user session encrypted by SealSession
+func OpenSession(sessionId, sealed []byte) ([]byte, error) {
+	if len(sealed) < 8 {
+		return nil, errors.New("session ciphertext too short")
+	}
+	keyId := int64(binary.BigEndian.Uint64(sealed[:8]))
+	key, err := keyById(sql.NullInt64{Int64: keyId, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	return key.open("session", sessionId, sealed[8:])
+}
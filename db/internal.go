@@ -7,14 +7,15 @@ import (
 
 	"github.com/giuliop/HermesVault-frontend/config"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
 	// txnsDb is populated by the subscriber service reading txns from algod
-	txnsDb *sql.DB
+	txnsDb *sqlDb
 	// internalDb is populated by the frontend to store additional notes data
-	internalDb *sql.DB
+	internalDb *sqlDb
 )
 var (
 	internalDbPath = config.InternalDbPath
@@ -22,23 +23,48 @@ var (
 )
 
 func init() {
+	// commands not handling notes can run without the note keys, the server
+	// refuses to start without them
+	var err error
+	if keyring, err = loadNoteKeyring(); err != nil {
+		log.Printf("failed to load note encryption keys: %v", err)
+	}
+	switch config.DbDriver {
+	case sqliteDriver:
+		err = initializeSQLite()
+	case postgresDriver:
+		err = initializePostgres()
+	default:
+		err = fmt.Errorf("unsupported database driver %q", config.DbDriver)
+	}
+	if err != nil {
+		log.Fatalf("failed to initialize databases: %v", err)
+	}
+	notes = &sqlNoteStore{db: internalDb}
+	unconfirmedNotes = &sqlNoteStore{db: internalDb}
+	txns = &sqlTxnStore{db: txnsDb}
+}
+
+// initializeSQLite opens the SQLite internal and transactions databases
+func initializeSQLite() error {
 	if err := initializeInternalDB(); err != nil {
-		log.Fatalf("failed to initialize internal database: %v", err)
+		return fmt.Errorf("internal database: %w", err)
 	}
 	if err := initializeTxnsDB(); err != nil {
-		log.Fatalf("failed to initialize transactions database: %v", err)
+		return fmt.Errorf("transactions database: %w", err)
 	}
+	return nil
 }
 
 // initializeTxnsDB opens a connection to the txnsDb in read-only mode
 func initializeTxnsDB() error {
-	var err error
 	// Open connection in read-only mode using DSN parameters.
 	dsn := fmt.Sprintf("file:%s?mode=ro", txnsDbPath)
-	txnsDb, err = sql.Open("sqlite3", dsn)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to open transactions database in read-only mode: %w", err)
 	}
+	txnsDb = &sqlDb{DB: db, dialect: sqliteDialect}
 	// Set busy timeout to 5000ms (5 seconds)
 	_, err = txnsDb.Exec("PRAGMA busy_timeout = 5000")
 	if err != nil {
@@ -48,43 +74,15 @@ func initializeTxnsDB() error {
 	return nil
 }
 
-// InitializeDB initializes the internalDb with WAL mode and creates necessary tables
-// (if they don't exist already)
+// InitializeDB opens the internalDb with WAL mode. The schema is created and
+// updated by Migrate
 func initializeInternalDB() error {
-	var err error
-	internalDb, err = sql.Open("sqlite3", internalDbPath)
+	db, err := sql.Open("sqlite3", internalDbPath)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
+	internalDb = &sqlDb{DB: db, dialect: sqliteDialect}
 
-	// The unconfirmed_notes table stores notes that the frontend has not received confirmation
-	// for yet form the blockchain. Once the txn inserting the note is confirmed, it is
-	// removed from this table and added to the notes table.
-	// The debug_notes table is used to store notes for debugging purposes and will be removed
-	// before MainNet launch.
-	// TODO: unconfimed_notes cleanup and debug_notes removal
-	createTables := `
-	CREATE TABLE IF NOT EXISTS notes (
-		leaf_index INTEGER PRIMARY KEY,			-- note ndex in onchain merkle tree
-		commitment BLOB NOT NULL,          		-- note Value in onchain merkle tree
-		nullifier BLOB,                         -- note nullifier
-		txn_id TEXT UNIQUE NOT NULL 			-- id of first group txn that inserted the note
-	) STRICT;
-
-	CREATE TABLE IF NOT EXISTS unconfirmed_notes (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		commitment BLOB NOT NULL,          		-- note Value in onchain merkle tree
-		nullifier BLOB,                         -- note nullifier
-		txn_id TEXT UNIQUE NOT NULL, 			-- id of first group txn that will insert note
-		created_at TEXT DEFAULT CURRENT_TIMESTAMP
-	) STRICT;
-
-	CREATE TABLE IF NOT EXISTS debug_notes (
-		leaf_index INTEGER PRIMARY KEY,
-		text TEXT NOT NULL,
-		FOREIGN KEY(leaf_index) REFERENCES notes(leaf_index) ON DELETE CASCADE
-	) STRICT;
-	`
 	// Enable WAL
 	_, err = internalDb.Exec("PRAGMA journal_mode = WAL")
 	if err != nil {
@@ -95,11 +93,26 @@ func initializeInternalDB() error {
 	if err != nil {
 		return fmt.Errorf("failed to set busy timeout: %w", err)
 	}
-	_, err = internalDb.Exec(createTables)
+
+	log.Println("Internal database initialized successfully")
+	return nil
+}
+
+// initializePostgres connects to the PostgreSQL internal database. The
+// transactions database stays in SQLite, the subscriber service writing it has
+// no PostgreSQL backend
+func initializePostgres() error {
+	db, err := sql.Open("postgres", internalDbPath)
 	if err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+		return fmt.Errorf("failed to open internal database: %w", err)
 	}
-
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to internal database: %w", err)
+	}
+	internalDb = &sqlDb{DB: db, dialect: postgresDialect}
 	log.Println("Internal database initialized successfully")
+	if err := initializeTxnsDB(); err != nil {
+		return fmt.Errorf("transactions database: %w", err)
+	}
 	return nil
 }
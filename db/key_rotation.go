@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"log"
+)
+
+// RotateNoteKeys re-encrypts with the active key every note record and receipt
+// encrypted with another key, not bound to its row or still in plaintext,
+// recomputing its blind indexes, and returns the number of rows re-encrypted. It
+// runs in a single transaction and is meant to
+// be run offline, with the server stopped
+func RotateNoteKeys() (int, error) {
+	key, err := activeKey()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := internalDb.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin key rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	total := 0
+	for _, table := range []string{"notes", "unconfirmed_notes"} {
+		n, err := rotateNoteRecords(tx, table, key.id)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("Re-encrypted %d rows of %s", n, table)
+		total += n
+	}
+	n, err := rotateReceipts(tx, key.id)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Re-encrypted %d rows of receipts", n)
+	total += n
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+	return total, nil
+}
+
+// rotateNoteRecords re-encrypts the note records of a table not encrypted with the
+// active key or not bound to their row. The table must be notes or unconfirmed_notes
+func rotateNoteRecords(tx *sqlTx, table string, activeId int64) (int, error) {
+	// only the unconfirmed notes have a spent commitment
+	key, spentCommitment := "id", "spent_commitment"
+	if table == "notes" {
+		key, spentCommitment = "leaf_index", "NULL"
+	}
+	rows, err := tx.Query(`SELECT `+key+`, commitment, nullifier, txn_id, key_id,
+		txn_id_index, aad_version, `+spentCommitment+` FROM `+table+`
+		WHERE key_id IS NULL OR key_id != ? OR aad_version < ?`, activeId, rowBoundAAD)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+	type rowRecord struct {
+		id     int64
+		record noteRecord
+	}
+	var records []rowRecord
+	for rows.Next() {
+		var r rowRecord
+		if err := rows.Scan(&r.id, &r.record.commitment, &r.record.nullifier,
+			&r.record.txnId, &r.record.keyId, &r.record.txnIdIndex,
+			&r.record.aadVersion, &r.record.spentCommitment); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate %s: %w", table, err)
+	}
+
+	for _, r := range records {
+		commitment, nullifier, txnId, err := r.record.open()
+		if err != nil {
+			return 0, fmt.Errorf("%s row %d: %w", table, r.id, err)
+		}
+		spent, err := r.record.openSpentCommitment()
+		if err != nil {
+			return 0, fmt.Errorf("%s row %d: %w", table, r.id, err)
+		}
+		sealed, commitmentIndex, txnIdIndex, err := sealNoteRecord(commitment, nullifier,
+			txnId)
+		if err == nil {
+			err = sealed.sealSpentCommitment(spent)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s row %d: %w", table, r.id, err)
+		}
+		query := `UPDATE ` + table + ` SET commitment = ?, nullifier = ?, txn_id = ?,
+			key_id = ?, txn_id_index = ?, aad_version = ?, spent_commitment = ?
+			WHERE id = ?`
+		args := []any{sealed.commitment, sealed.nullifier, sealed.txnId, sealed.keyId,
+			txnIdIndex, sealed.aadVersion, sealed.spentCommitment, r.id}
+		if table == "notes" {
+			query = `UPDATE notes SET commitment = ?, nullifier = ?, txn_id = ?,
+				key_id = ?, txn_id_index = ?, aad_version = ?, commitment_index = ?
+				WHERE leaf_index = ?`
+			args = []any{sealed.commitment, sealed.nullifier, sealed.txnId, sealed.keyId,
+				txnIdIndex, sealed.aadVersion, commitmentIndex, r.id}
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			return 0, fmt.Errorf("failed to update %s row %d: %w", table, r.id, err)
+		}
+	}
+	return len(records), nil
+}
+
+// rotateReceipts re-encrypts the receipts not encrypted with the active key or not
+// bound to their row
+func rotateReceipts(tx *sqlTx, activeId int64) (int, error) {
+	rows, err := tx.Query(`SELECT id, `+receiptColumns+` FROM receipts
+		WHERE key_id != ? OR aad_version < ?`, activeId, rowBoundAAD)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query receipts: %w", err)
+	}
+	type rowRecord struct {
+		id     int64
+		record receiptRecord
+	}
+	var records []rowRecord
+	for rows.Next() {
+		var r rowRecord
+		err := rows.Scan(&r.id, &r.record.keyId, &r.record.txnId, &r.record.txnIdIndex,
+			&r.record.nullifier, &r.record.spentCommitment, &r.record.changeCommitment,
+			&r.record.aadVersion)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan receipts: %w", err)
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate receipts: %w", err)
+	}
+
+	for _, r := range records {
+		receipt, err := r.record.open()
+		if err != nil {
+			return 0, fmt.Errorf("receipt %d: %w", r.id, err)
+		}
+		sealed, txnIdIndex, err := sealReceipt(receipt)
+		if err != nil {
+			return 0, fmt.Errorf("receipt %d: %w", r.id, err)
+		}
+		if _, err := tx.Exec(`UPDATE receipts SET key_id = ?, withdrawal_txn_id = ?,
+			withdrawal_txn_id_index = ?, nullifier = ?, spent_commitment = ?,
+			change_commitment = ?, aad_version = ? WHERE id = ?`,
+			sealed.keyId, sealed.txnId, txnIdIndex, sealed.nullifier,
+			sealed.spentCommitment, sealed.changeCommitment, sealed.aadVersion,
+			r.id); err != nil {
+			return 0, fmt.Errorf("failed to update receipt %d: %w", r.id, err)
+		}
+	}
+	return len(records), nil
+}
@@ -6,9 +6,12 @@ import (
 	"database/sql"
 	"log"
 	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
 )
 
-// StartCleanupRoutine starts a goroutine that periodically runs cleanup.
+// StartCleanupRoutine starts a goroutine that periodically runs cleanup, as a
+// safety net for the notes the txns watcher missed.
 // It returns a cancel function that can be used to stop the routine.
 func StartCleanupRoutine(ctx context.Context, interval time.Duration) context.CancelFunc {
 	ctx, cancel := context.WithCancel(ctx)
@@ -33,109 +36,85 @@ func StartCleanupRoutine(ctx context.Context, interval time.Duration) context.Ca
 // For each note:
 //   - It retrieves the corresponding transaction from txnsDb using txn_id
 //   - If found, it checks that commitment also matches:
-//   - If so, it moves it tothe notes table
+//   - If so, it moves it tothe notes table, writing the receipt of its withdrawal
 //   - Otherwise, it logs an error and leaves the note unconfirmed
 //   - Finally, if the note is older than 7 days, it deletes it as stale
 func CleanupUnconfirmedNotes() {
-	// Query all rows from unconfirmed_notes.
-	rows, err := internalDb.Query(`
-		SELECT id, commitment, nullifier, txn_id, created_at
-		FROM unconfirmed_notes
-	`)
+	unconfirmed, err := unconfirmedNotes.UnconfirmedNotes()
 	if err != nil {
-		log.Printf("failed to query unconfirmed_notes: %v", err)
+		log.Printf("failed to get unconfirmed notes: %v", err)
 		return
 	}
-	defer rows.Close()
 
-	// Expected timestamp layout in SQLite (CURRENT_TIMESTAMP format).
+	// Expected timestamp layout (SQLite CURRENT_TIMESTAMP format).
 	const timeLayout = "2006-01-02 15:04:05"
 
-	for rows.Next() {
-		var id int
-		var commitment []byte
-		var nullifier []byte
-		var txnID string
-		var createdAt string
-
-		if err := rows.Scan(&id, &commitment, &nullifier, &txnID, &createdAt); err != nil {
-			log.Printf("failed to scan unconfirmed note id %d: %v", id, err)
-			continue
-		}
-
+	for _, n := range unconfirmed {
 		// Parse the created_at timestamp.
-		noteTime, err := time.Parse(timeLayout, createdAt)
+		noteTime, err := time.Parse(timeLayout, n.CreatedAt)
 		if err != nil {
-			log.Printf("failed to parse created_at (%s) for note id %d: %v", createdAt, id, err)
+			log.Printf("failed to parse created_at (%s) for note id %d: %v", n.CreatedAt,
+				n.Id, err)
 			// If we cannot parse the timestamp, skip cleanup for this note.
 			continue
 		}
 
 		// Query the transaction record by txn_id.
-		var txnLeafIndex int
-		var txnCommitment []byte
-		err = txnsDb.QueryRow(`
-			SELECT leaf_index, commitment
-			FROM txns
-			WHERE txn_id = ?
-		`, txnID).Scan(&txnLeafIndex, &txnCommitment)
+		txn, err := txns.GetTxnByID(n.TxnId)
 
 		if err == sql.ErrNoRows {
-			log.Printf("No matching transaction found for unconfirmed note id %d with txn_id %s", id, txnID)
+			log.Printf("No matching transaction found for unconfirmed note id %d with txn_id %s", n.Id, n.TxnId)
 			// Cleanup: if the note wasn't processed and is older than 7 days, delete it.
 			if time.Since(noteTime) > 7*24*time.Hour {
-				_, err = internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
-				if err != nil {
-					log.Printf("failed to delete stale unconfirmed note id %d: %v", id, err)
+				if err := unconfirmedNotes.DeleteUnconfirmedNote(n.Id); err != nil {
+					log.Printf("failed to delete stale unconfirmed note id %d: %v", n.Id, err)
 					continue
 				}
-				log.Printf("Deleted stale unconfirmed note id %d (created at %s)", id,
-					createdAt)
+				log.Printf("Deleted stale unconfirmed note id %d (created at %s)", n.Id,
+					n.CreatedAt)
 			}
 		} else if err != nil {
-			log.Printf("failed to query txn for unconfirmed note id %d: %v", id, err)
+			log.Printf("failed to query txn for unconfirmed note id %d: %v", n.Id, err)
 			continue
 		} else {
-			// Transaction found; verify that the commitment matches.
-			if bytes.Equal(txnCommitment, commitment) {
-				// Begin a transaction.
-				tx, err := internalDb.Begin()
-				if err != nil {
-					log.Printf("failed to begin transaction for unconfirmed note id %d: %v", id, err)
-					continue
-				}
-
-				// Insert the note into the notes table.
-				_, err = tx.Exec(
-					`INSERT INTO notes (leaf_index, commitment, nullifier, txn_id) VALUES (?, ?, ?, ?)`,
-					txnLeafIndex, commitment, nullifier, txnID)
-				if err != nil {
-					tx.Rollback()
-					log.Printf("failed to insert note for unconfirmed note id %d: %v", id, err)
-					continue
-				}
-				// Delete the note from unconfirmed_notes.
-				_, err = tx.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
-				if err != nil {
-					tx.Rollback()
-					log.Printf("failed to delete processed unconfirmed note id %d: %v", id, err)
-					continue
-				}
-
-				if err = tx.Commit(); err != nil {
-					log.Printf("failed to commit transaction for unconfirmed note id %d: %v", id, err)
-					continue
-				}
+			reconcileNote(n, txn)
+		}
+	}
+}
 
-				log.Printf("Processed unconfirmed note id %d: moved to notes with leaf_index %d", id, txnLeafIndex)
-			} else {
-				// Commitment mismatch: log an error and leave the note intact.
-				log.Printf("Commitment mismatch for unconfirmed note id %d with txn_id %s", id, txnID)
+// reconcileNote moves the unconfirmed note to the notes table, writing the receipt
+// of its withdrawal, if the txn that inserted it has the same commitment, or
+// deletes it if it was already saved.
+// It returns true if the note is no longer unconfirmed
+func reconcileNote(n *UnconfirmedNote, txn *models.Txn) bool {
+	// Transaction found; verify that the commitment matches.
+	saved, err := notes.IsNoteSaved(n.TxnId)
+	if err != nil {
+		log.Printf("failed to look up note for unconfirmed note id %d: %v", n.Id, err)
+		return false
+	}
+	if saved {
+		// The note was already saved when its txn was confirmed, make sure its
+		// withdrawal has a receipt too
+		if r := n.receipt(txn); r != nil {
+			if err := SaveReceipt(r); err != nil {
+				log.Printf("failed to save receipt for unconfirmed note id %d: %v", n.Id, err)
+				return false
 			}
 		}
+		DeleteUnconfirmedNote(n.Id)
+		return true
 	}
-
-	if err = rows.Err(); err != nil {
-		log.Printf("error iterating over unconfirmed_notes rows: %v", err)
+	if !bytes.Equal(txn.Commitment, n.Commitment) {
+		// Commitment mismatch: log an error and leave the note intact.
+		log.Printf("Commitment mismatch for unconfirmed note id %d with txn_id %s", n.Id, n.TxnId)
+		return false
+	}
+	// Move the note to the notes table, with its receipt, in one transaction.
+	if err := unconfirmedNotes.ConfirmNote(n, txn); err != nil {
+		log.Printf("failed to confirm unconfirmed note id %d: %v", n.Id, err)
+		return false
 	}
+	log.Printf("Processed unconfirmed note id %d: moved to notes with leaf_index %d", n.Id, txn.LeafIndex)
+	return true
 }
@@ -0,0 +1,508 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// ErrDatabaseNewer is returned when the internal database has migrations applied
+// that this binary does not know about
+var ErrDatabaseNewer = errors.New("the internal database is newer than this binary")
+
+// ErrDatabaseOutdated is returned when the internal database has pending
+// migrations, applied by the server at start or by Migrate
+var ErrDatabaseOutdated = errors.New("the internal database has pending migrations")
+
+// migration is a versioned change to the internal database schema
+type migration struct {
+	version int
+	name    string
+	up      string
+	// data, if set, runs after up in the same transaction to move the data that
+	// SQL alone cannot
+	data func(tx *sqlTx) error
+}
+
+// sqliteMigrations are the SQLite internal database migrations, in version order.
+// Migrations are never edited once released, any change needs a new migration,
+// added to postgresMigrations too
+var sqliteMigrations = []migration{
+	{
+		// The unconfirmed_notes table stores notes that the frontend has not received
+		// confirmation for yet form the blockchain. Once the txn inserting the note is
+		// confirmed, it is removed from this table and added to the notes table.
+		// The association_roots table stores the history of the published association
+		// set roots, so that a root used in a withdrawal proof can be traced to its policy.
+		// The protocol_params table records the protocol parameters each time they
+		// change on chain, so that the recovery can work out the change of the past
+		// withdrawals with the fee they paid.
+		// The debug_notes table is used to store notes for debugging purposes and will be
+		// removed before MainNet launch.
+		// The tables are created only if missing since databases created before the
+		// migrations were introduced already have them
+		version: 1,
+		name:    "initial schema",
+		up: `
+		CREATE TABLE IF NOT EXISTS notes (
+			leaf_index INTEGER PRIMARY KEY,			-- note ndex in onchain merkle tree
+			commitment BLOB NOT NULL,          		-- note Value in onchain merkle tree
+			nullifier BLOB,                         -- note nullifier
+			txn_id TEXT UNIQUE NOT NULL 			-- id of first group txn that inserted the note
+		) STRICT;
+
+		CREATE TABLE IF NOT EXISTS unconfirmed_notes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			commitment BLOB NOT NULL,          		-- note Value in onchain merkle tree
+			nullifier BLOB,                         -- note nullifier
+			txn_id TEXT UNIQUE NOT NULL, 			-- id of first group txn that will insert note
+			created_at TEXT DEFAULT CURRENT_TIMESTAMP
+		) STRICT;
+
+		CREATE TABLE IF NOT EXISTS association_roots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			policy TEXT NOT NULL,					-- name of the association set policy
+			root BLOB NOT NULL,						-- root of the association set tree
+			leaf_count INTEGER NOT NULL,			-- leaves in the main tree when built
+			created_at TEXT DEFAULT CURRENT_TIMESTAMP
+		) STRICT;
+
+		CREATE TABLE IF NOT EXISTS protocol_params (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deposit_minimum_amount INTEGER NOT NULL,
+			withdrawal_fee_divisor INTEGER NOT NULL,
+			withdrawal_minimum_fee INTEGER NOT NULL,
+			created_at TEXT DEFAULT CURRENT_TIMESTAMP
+		) STRICT;
+
+		CREATE TABLE IF NOT EXISTS debug_notes (
+			leaf_index INTEGER PRIMARY KEY,
+			text TEXT NOT NULL,
+			FOREIGN KEY(leaf_index) REFERENCES notes(leaf_index) ON DELETE CASCADE
+		) STRICT;
+		`,
+	},
+	{
+		version: 2,
+		name:    "index association roots by policy and unconfirmed notes by age",
+		up: `
+		CREATE INDEX association_roots_policy ON association_roots(policy, id);
+		CREATE INDEX unconfirmed_notes_created_at ON unconfirmed_notes(created_at);
+		`,
+	},
+	{
+		// The note linking data is encrypted with the note key key_id, NULL for the
+		// plaintext rows written before, which RotateNoteKeys encrypts.
+		// The *_index columns are the blind indexes used for lookups
+		version: 3,
+		name:    "encrypt note records",
+		up: `
+		ALTER TABLE notes ADD COLUMN key_id INTEGER;
+		ALTER TABLE notes ADD COLUMN commitment_index BLOB;
+		ALTER TABLE notes ADD COLUMN txn_id_index BLOB;
+		CREATE UNIQUE INDEX notes_txn_id_index ON notes(txn_id_index);
+		CREATE INDEX notes_commitment_index ON notes(commitment_index);
+
+		ALTER TABLE unconfirmed_notes ADD COLUMN key_id INTEGER;
+		ALTER TABLE unconfirmed_notes ADD COLUMN txn_id_index BLOB;
+		CREATE UNIQUE INDEX unconfirmed_notes_txn_id_index ON unconfirmed_notes(txn_id_index);
+
+		ALTER TABLE debug_notes ADD COLUMN key_id INTEGER;
+		`,
+	},
+	{
+		// The aad_version column tells what the encrypted values are authenticated
+		// with: 1 for their column only, 2 for their row too. RotateNoteKeys
+		// re-encrypts the rows at version 1
+		version: 4,
+		name:    "bind encrypted values to their row",
+		up: `
+		ALTER TABLE notes ADD COLUMN aad_version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE unconfirmed_notes ADD COLUMN aad_version INTEGER NOT NULL DEFAULT 1;
+		`,
+	},
+	{
+		// The receipts table replaces debug_notes, which stored the note secrets.
+		// Each withdrawal records the note it spent and the change note it inserted,
+		// so that the change notes can be walked back to the original deposit.
+		// The columns are encrypted with the note key key_id, bound to their row
+		// as aad_version tells, withdrawal_txn_id_index is the blind index used
+		// for lookups.
+		// The receipts of the past withdrawals are backfilled from the notes table,
+		// see backfillReceipts
+		version: 5,
+		name:    "replace debug notes with receipts",
+		up: `
+		CREATE TABLE receipts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key_id INTEGER NOT NULL,
+			withdrawal_txn_id TEXT NOT NULL,		-- id of the first withdrawal group txn
+			withdrawal_txn_id_index BLOB NOT NULL,
+			nullifier BLOB NOT NULL,				-- nullifier of the spent note
+			spent_commitment BLOB NOT NULL,			-- commitment of the spent note
+			change_commitment BLOB NOT NULL,		-- commitment of the change note
+			created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+			aad_version INTEGER NOT NULL
+		) STRICT;
+
+		CREATE UNIQUE INDEX receipts_withdrawal_txn_id_index
+			ON receipts(withdrawal_txn_id_index);
+
+		DROP TABLE debug_notes;
+		`,
+		data: backfillReceipts,
+	},
+	{
+		// The spent_commitment column records, encrypted, the commitment of the note
+		// spent by the withdrawal inserting an unconfirmed change note, so that the
+		// receipt of the withdrawal is written wherever the note is confirmed
+		version: 6,
+		name:    "record the spent note of unconfirmed withdrawals",
+		up: `
+		ALTER TABLE unconfirmed_notes ADD COLUMN spent_commitment BLOB;
+		`,
+	},
+}
+
+// postgresMigrations are the PostgreSQL internal database migrations, in version
+// order. PostgreSQL is supported from version 6, whose migration creates the whole
+// schema; the following versions match the SQLite ones
+var postgresMigrations = []migration{
+	{
+		// See the SQLite migrations for the meaning of the tables
+		version: 6,
+		name:    "initial postgres schema",
+		up: `
+		CREATE TABLE notes (
+			leaf_index BIGINT PRIMARY KEY,
+			commitment BYTEA NOT NULL,
+			nullifier BYTEA,
+			txn_id TEXT UNIQUE NOT NULL,
+			key_id BIGINT,
+			commitment_index BYTEA,
+			txn_id_index BYTEA,
+			aad_version INTEGER NOT NULL DEFAULT 1
+		);
+		CREATE UNIQUE INDEX notes_txn_id_index ON notes(txn_id_index);
+		CREATE INDEX notes_commitment_index ON notes(commitment_index);
+
+		CREATE TABLE unconfirmed_notes (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			commitment BYTEA NOT NULL,
+			nullifier BYTEA,
+			txn_id TEXT UNIQUE NOT NULL,
+			created_at TEXT DEFAULT ` + postgresDialect.now + `,
+			key_id BIGINT,
+			txn_id_index BYTEA,
+			aad_version INTEGER NOT NULL DEFAULT 1,
+			spent_commitment BYTEA
+		);
+		CREATE INDEX unconfirmed_notes_created_at ON unconfirmed_notes(created_at);
+		CREATE UNIQUE INDEX unconfirmed_notes_txn_id_index ON unconfirmed_notes(txn_id_index);
+
+		CREATE TABLE association_roots (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			policy TEXT NOT NULL,
+			root BYTEA NOT NULL,
+			leaf_count BIGINT NOT NULL,
+			created_at TEXT DEFAULT ` + postgresDialect.now + `
+		);
+		CREATE INDEX association_roots_policy ON association_roots(policy, id);
+
+		CREATE TABLE protocol_params (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			deposit_minimum_amount BIGINT NOT NULL,
+			withdrawal_fee_divisor BIGINT NOT NULL,
+			withdrawal_minimum_fee BIGINT NOT NULL,
+			created_at TEXT DEFAULT ` + postgresDialect.now + `
+		);
+
+		CREATE TABLE receipts (
+			id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			key_id BIGINT NOT NULL,
+			withdrawal_txn_id TEXT NOT NULL,
+			withdrawal_txn_id_index BYTEA NOT NULL,
+			nullifier BYTEA NOT NULL,
+			spent_commitment BYTEA NOT NULL,
+			change_commitment BYTEA NOT NULL,
+			created_at TEXT DEFAULT ` + postgresDialect.now + `,
+			aad_version INTEGER NOT NULL
+		);
+		CREATE UNIQUE INDEX receipts_withdrawal_txn_id_index
+			ON receipts(withdrawal_txn_id_index);
+		`,
+	},
+}
+
+// migrations returns the internal database migrations for its dialect
+func migrations() []migration {
+	if internalDb.dialect.driver == postgresDriver {
+		return postgresMigrations
+	}
+	return sqliteMigrations
+}
+
+// MigrationStatus is the status of a migration in the internal database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt string // empty if the migration is pending
+}
+
+// createMigrationsTable creates the schema_migrations table tracking the applied
+// migrations
+func createMigrationsTable(e execer) error {
+	_, err := e.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT DEFAULT ` + internalDb.dialect.now + `
+	) ` + internalDb.dialect.tableOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations returns the applied_at time of the applied migrations by
+// version, none if the schema_migrations table does not exist. It does not change
+// the database
+func appliedMigrations() (map[int]string, error) {
+	var tables int
+	err := internalDb.QueryRow(internalDb.dialect.tableExists,
+		"schema_migrations").Scan(&tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up schema_migrations: %w", err)
+	}
+	if tables == 0 {
+		return map[int]string{}, nil
+	}
+	rows, err := internalDb.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion returns the version of the last migration applied to the internal
+// database, 0 if it is unversioned, and the latest version known to this binary
+func SchemaVersion() (current int, latest int, err error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return 0, 0, err
+	}
+	for version := range applied {
+		current = max(current, version)
+	}
+	all := migrations()
+	return current, all[len(all)-1].version, nil
+}
+
+// CheckSchemaVersion returns ErrDatabaseOutdated or ErrDatabaseNewer unless the
+// internal database is at the version of this binary, for the commands that use it
+// without migrating it
+func CheckSchemaVersion() error {
+	current, latest, err := SchemaVersion()
+	switch {
+	case err != nil:
+		return err
+	case current < latest:
+		return fmt.Errorf("%w: database version %d, binary version %d",
+			ErrDatabaseOutdated, current, latest)
+	case current > latest:
+		return fmt.Errorf("%w: database version %d, binary version %d",
+			ErrDatabaseNewer, current, latest)
+	}
+	return nil
+}
+
+// MigrationsStatus returns the status of all the migrations known to this binary
+func MigrationsStatus() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	all := migrations()
+	status := make([]MigrationStatus, len(all))
+	for i, m := range all {
+		status[i] = MigrationStatus{Version: m.version, Name: m.name,
+			AppliedAt: applied[m.version]}
+	}
+	return status, nil
+}
+
+// Migrate applies the pending migrations to the internal database in order, each
+// in its own transaction, and returns the ones applied. With dryRun all pending
+// migrations are run in one transaction that is rolled back, so that the database
+// is left unchanged. It returns ErrDatabaseNewer if the database has unknown
+// migrations applied
+func Migrate(dryRun bool) ([]MigrationStatus, error) {
+	current, latest, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > latest {
+		return nil, fmt.Errorf("%w: database version %d, binary version %d",
+			ErrDatabaseNewer, current, latest)
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// even the schema_migrations table is created in the dry run transaction
+	var dryRunTx *sqlTx
+	if dryRun {
+		if dryRunTx, err = internalDb.Begin(); err != nil {
+			return nil, fmt.Errorf("failed to begin dry run: %w", err)
+		}
+		defer dryRunTx.Rollback()
+		err = createMigrationsTable(dryRunTx)
+	} else {
+		err = createMigrationsTable(internalDb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var done []MigrationStatus
+	for _, m := range migrations() {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if dryRun {
+			err = applyMigration(dryRunTx, m)
+		} else {
+			err = applyMigrationInTx(m)
+		}
+		if err != nil {
+			return done, err
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name})
+		if !dryRun {
+			log.Printf("Applied internal database migration %d: %s", m.version, m.name)
+		}
+	}
+	return done, nil
+}
+
+// applyMigrationInTx applies a migration in its own transaction
+func applyMigrationInTx(m migration) error {
+	tx, err := internalDb.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+	if err := applyMigration(tx, m); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records it in the schema_migrations table
+func applyMigration(tx *sqlTx, m migration) error {
+	if _, err := tx.Exec(m.up); err != nil {
+		return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+	}
+	if m.data != nil {
+		if err := m.data(tx); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name,
+				err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+		m.version, m.name); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// backfillReceipts writes the receipts of the past withdrawals whose change note and
+// spent note are both in the notes table, which links them as debug_notes did
+// without the note secrets
+func backfillReceipts(tx *sqlTx) error {
+	rows, err := tx.Query(`SELECT commitment, nullifier, txn_id, key_id, txn_id_index,
+		aad_version FROM notes`)
+	if err != nil {
+		return fmt.Errorf("failed to query notes: %w", err)
+	}
+	var records []noteRecord
+	for rows.Next() {
+		var r noteRecord
+		if err := rows.Scan(&r.commitment, &r.nullifier, &r.txnId, &r.keyId,
+			&r.txnIdIndex, &r.aadVersion); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan notes: %w", err)
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate notes: %w", err)
+	}
+
+	type note struct {
+		commitment []byte
+		txnId      string
+	}
+	notes := make([]note, len(records))
+	spent := make(map[string][]byte, len(records)) // nullifier -> commitment
+	for i, r := range records {
+		commitment, nullifier, txnId, err := r.open()
+		if err != nil {
+			return fmt.Errorf("failed to decrypt note: %w", err)
+		}
+		notes[i] = note{commitment, txnId}
+		spent[string(nullifier)] = commitment
+	}
+
+	var written, untraced int
+	for _, n := range notes {
+		txn, err := txns.GetTxnByID(n.txnId)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get txn %s: %w", n.txnId, err)
+		}
+		if txn.Type != models.WithdrawalTxnType {
+			continue
+		}
+		spentCommitment, ok := spent[string(txn.FromNullifier)]
+		if !ok {
+			// the spent note was deposited through another frontend
+			untraced++
+			continue
+		}
+		err = insertReceipt(tx, &models.Receipt{
+			WithdrawalTxnID:  txn.TxnID,
+			Nullifier:        txn.FromNullifier,
+			SpentCommitment:  spentCommitment,
+			ChangeCommitment: n.commitment,
+		})
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	if written+untraced > 0 {
+		log.Printf("Backfilled %d withdrawal receipts, %d withdrawals spend notes not "+
+			"in the notes table", written, untraced)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// SaveProtocolParams records the protocol parameters, unless they are the same as
+// the last ones recorded. It returns true if they were recorded
+func SaveProtocolParams(p models.ProtocolParams) (bool, error) {
+	var last models.ProtocolParams
+	err := internalDb.QueryRow(`SELECT deposit_minimum_amount, withdrawal_fee_divisor,
+		withdrawal_minimum_fee FROM protocol_params ORDER BY id DESC LIMIT 1`).Scan(
+		&last.DepositMinimumAmount, &last.WithdrawalFeeDivisor, &last.WithdrawalMinimumFee)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to get last protocol params: %w", err)
+	}
+	if err == nil && last == p {
+		return false, nil
+	}
+	_, err = internalDb.Exec(`INSERT INTO protocol_params (deposit_minimum_amount,
+		withdrawal_fee_divisor, withdrawal_minimum_fee) VALUES (?, ?, ?)`,
+		p.DepositMinimumAmount, p.WithdrawalFeeDivisor, p.WithdrawalMinimumFee)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert protocol params: %w", err)
+	}
+	return true, nil
+}
+
+// GetProtocolParamsHistory returns the protocol parameters recorded, oldest first
+func GetProtocolParamsHistory() ([]models.ProtocolParams, error) {
+	rows, err := internalDb.Query(`SELECT deposit_minimum_amount, withdrawal_fee_divisor,
+		withdrawal_minimum_fee FROM protocol_params ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query protocol params: %w", err)
+	}
+	defer rows.Close()
+	var history []models.ProtocolParams
+	for rows.Next() {
+		var p models.ProtocolParams
+		if err := rows.Scan(&p.DepositMinimumAmount, &p.WithdrawalFeeDivisor,
+			&p.WithdrawalMinimumFee); err != nil {
+			return nil, fmt.Errorf("failed to scan protocol params: %w", err)
+		}
+		history = append(history, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate protocol params: %w", err)
+	}
+	return history, nil
+}
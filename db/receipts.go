@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// SaveReceipt records the receipt of a withdrawal, encrypted with the active key.
+// It does nothing if the withdrawal already has a receipt
+func SaveReceipt(r *models.Receipt) error {
+	return insertReceipt(internalDb, r)
+}
+
+// insertReceipt inserts the receipt of a withdrawal unless it already has one
+func insertReceipt(e execer, r *models.Receipt) error {
+	rr, txnIdIndex, err := sealReceipt(r)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt receipt: %w", err)
+	}
+	_, err = e.Exec(`INSERT INTO receipts (key_id, withdrawal_txn_id,
+		withdrawal_txn_id_index, nullifier, spent_commitment, change_commitment,
+		aad_version) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		rr.keyId, rr.txnId, txnIdIndex, rr.nullifier, rr.spentCommitment,
+		rr.changeCommitment, rr.aadVersion)
+	if err != nil {
+		return fmt.Errorf("failed to insert receipt: %w", err)
+	}
+	return nil
+}
+
+// GetReceiptByWithdrawal returns the receipt of the withdrawal with the given txn id
+// error will be sql.ErrNoRows if no rows are returned
+func GetReceiptByWithdrawal(txnId string) (*models.Receipt, error) {
+	indexes, err := blindIndexes("withdrawal_txn_id", []byte(txnId))
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + receiptColumns + ` FROM receipts
+		WHERE withdrawal_txn_id_index IN (?` + strings.Repeat(", ?", len(indexes)-1) + `)`
+	rr, err := scanReceipt(internalDb.QueryRow(query, indexes...))
+	if err != nil {
+		return nil, err
+	}
+	return rr.open()
+}
+
+// receiptRecord is a receipt as stored in the internal database
+type receiptRecord struct {
+	keyId            sql.NullInt64
+	txnId            string // base64 encoded ciphertext
+	txnIdIndex       []byte
+	nullifier        []byte
+	spentCommitment  []byte
+	changeCommitment []byte
+	aadVersion       int
+}
+
+// receiptColumns are the columns read by scanReceipt
+const receiptColumns = `key_id, withdrawal_txn_id, withdrawal_txn_id_index, nullifier,
+	spent_commitment, change_commitment, aad_version`
+
+// scanReceipt scans a receipt record selected with receiptColumns
+func scanReceipt(row interface{ Scan(...any) error }) (rr receiptRecord, err error) {
+	err = row.Scan(&rr.keyId, &rr.txnId, &rr.txnIdIndex, &rr.nullifier,
+		&rr.spentCommitment, &rr.changeCommitment, &rr.aadVersion)
+	return rr, err
+}
+
+// row returns the row identity the receipt values are bound to, nil if they are
+// bound to their column only
+func (rr receiptRecord) row() []byte {
+	if rr.aadVersion < rowBoundAAD {
+		return nil
+	}
+	return rr.txnIdIndex
+}
+
+// sealReceipt encrypts a receipt with the active key, and returns it with the
+// blind index of its withdrawal txn id
+func sealReceipt(r *models.Receipt) (rr receiptRecord, txnIdIndex []byte, err error) {
+	key, err := activeKey()
+	if err != nil {
+		return receiptRecord{}, nil, err
+	}
+	rr.txnIdIndex = key.blindIndex("withdrawal_txn_id", []byte(r.WithdrawalTxnID))
+	rr.aadVersion = rowBoundAAD
+	rr.keyId, rr.txnId, err = sealText("withdrawal_txn_id", rr.row(), r.WithdrawalTxnID)
+	if err != nil {
+		return receiptRecord{}, nil, err
+	}
+	if rr.nullifier, err = key.seal("nullifier", rr.row(), r.Nullifier); err != nil {
+		return receiptRecord{}, nil, err
+	}
+	rr.spentCommitment, err = key.seal("spent_commitment", rr.row(), r.SpentCommitment)
+	if err != nil {
+		return receiptRecord{}, nil, err
+	}
+	rr.changeCommitment, err = key.seal("change_commitment", rr.row(), r.ChangeCommitment)
+	if err != nil {
+		return receiptRecord{}, nil, err
+	}
+	return rr, rr.txnIdIndex, nil
+}
+
+// open decrypts a receipt record
+func (rr receiptRecord) open() (*models.Receipt, error) {
+	key, err := keyById(rr.keyId)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("receipt without key id")
+	}
+	r := &models.Receipt{}
+	r.WithdrawalTxnID, err = openText(rr.keyId, "withdrawal_txn_id", rr.row(), rr.txnId)
+	if err != nil {
+		return nil, err
+	}
+	if r.Nullifier, err = key.open("nullifier", rr.row(), rr.nullifier); err != nil {
+		return nil, err
+	}
+	r.SpentCommitment, err = key.open("spent_commitment", rr.row(), rr.spentCommitment)
+	if err != nil {
+		return nil, err
+	}
+	r.ChangeCommitment, err = key.open("change_commitment", rr.row(), rr.changeCommitment)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// The protocol statistics are computed incrementally: the txns above a leaf_index
+// high-water mark are added to a running aggregate, as for the txns watcher. The
+// totals of the stats table are read as they are, they are a single row each
+
+// keys of the stats table
+const (
+	statsTotalDeposits    = "total_deposits"
+	statsTotalWithdrawals = "total_withdrawals"
+	statsTotalFees        = "total_fees"
+)
+
+// statsAggregate is the aggregate of the txns up to the leaf index mark
+type statsAggregate struct {
+	mu      sync.Mutex
+	mark    int
+	stats   models.ProtocolStats
+	history []models.StatsBucket
+}
+
+var aggregate = statsAggregate{mark: -1}
+
+// RefreshStats adds the new txns to the protocol statistics and makes them live
+func RefreshStats() error {
+	aggregate.mu.Lock()
+	defer aggregate.mu.Unlock()
+
+	newTxns, err := txns.GetTxnsAfter(aggregate.mark)
+	if err != nil {
+		return fmt.Errorf("failed to read new txns: %w", err)
+	}
+	totals, err := txns.GetStats()
+	if err != nil {
+		return fmt.Errorf("failed to read stats: %w", err)
+	}
+
+	s := &aggregate.stats
+	for _, txn := range newTxns {
+		aggregate.addToHistory(txn)
+		switch txn.Type {
+		case models.DepositTxnType:
+			s.DepositCount++
+			s.DepositVolume += txn.Amount
+		case models.WithdrawalTxnType:
+			s.WithdrawalCount++
+			s.WithdrawalVolume += txn.Amount
+		}
+		s.LeafCount++
+		aggregate.mark = txn.LeafIndex
+	}
+	// each withdrawal spends a note and inserts the change note
+	s.AnonymitySetSize = s.LeafCount - s.WithdrawalCount
+	s.Fees = totals[statsTotalFees]
+	s.TVL = 0
+	outflow := totals[statsTotalWithdrawals] + totals[statsTotalFees]
+	if deposits := totals[statsTotalDeposits]; deposits > outflow {
+		s.TVL = deposits - outflow
+	}
+	s.UpdatedAt = time.Now().UTC()
+
+	// the live history must not share the last bucket, which is still growing
+	live := *s
+	live.HistoryByLeaf = append([]models.StatsBucket(nil), aggregate.history...)
+	models.SetProtocolStats(live)
+	return nil
+}
+
+// addToHistory adds the txn to the bucket of its leaf index
+func (a *statsAggregate) addToHistory(txn *models.Txn) {
+	from := txn.LeafIndex - txn.LeafIndex%config.StatsBucketLeaves
+	if n := len(a.history); n == 0 || a.history[n-1].FromLeaf != from {
+		a.history = append(a.history, models.StatsBucket{FromLeaf: from})
+	}
+	b := &a.history[len(a.history)-1]
+	b.ToLeaf = txn.LeafIndex
+	switch txn.Type {
+	case models.DepositTxnType:
+		b.DepositCount++
+		b.DepositVolume += txn.Amount
+	case models.WithdrawalTxnType:
+		b.WithdrawalCount++
+		b.WithdrawalVolume += txn.Amount
+	}
+}
+
+// StartStatsRoutine starts a goroutine that refreshes the protocol statistics
+// at the given interval. It returns a cancel function to stop it
+func StartStatsRoutine(ctx context.Context, interval time.Duration) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := RefreshStats(); err != nil {
+					log.Printf("Error refreshing protocol stats: %v", err)
+				}
+			case <-ctx.Done():
+				log.Println("Stats routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
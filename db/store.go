@@ -0,0 +1,411 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// NoteStore stores the confirmed notes, encrypting their linking data
+type NoteStore interface {
+	// SaveNote saves a confirmed note, succeeding if it is already saved
+	SaveNote(n *models.Note) error
+	// IsNoteSaved returns true if the note inserted by the txn is saved
+	IsNoteSaved(txnId string) (bool, error)
+}
+
+// UnconfirmedNoteStore stores the notes whose txn is not confirmed yet,
+// encrypting their linking data
+type UnconfirmedNoteStore interface {
+	// RegisterUnconfirmedNote saves a note and returns its id. spentCommitment is
+	// the commitment of the note spent by the withdrawal inserting a change note,
+	// nil for deposits, to write the withdrawal receipt once the note is confirmed
+	RegisterUnconfirmedNote(n *models.Note, spentCommitment []byte) (int64, error)
+	// UnconfirmedNotes returns the unconfirmed notes, skipping the ones that cannot
+	// be decrypted
+	UnconfirmedNotes() ([]*UnconfirmedNote, error)
+	// GetUnconfirmedNoteByTxnId returns the unconfirmed note the txn will insert
+	// error will be sql.ErrNoRows if no rows are returned
+	GetUnconfirmedNoteByTxnId(txnId string) (*UnconfirmedNote, error)
+	// ConfirmNote moves the unconfirmed note to the confirmed notes, with the leaf
+	// index its txn inserted it at, and writes the receipt of its withdrawal
+	ConfirmNote(n *UnconfirmedNote, txn *models.Txn) error
+	// DeleteUnconfirmedNote deletes an unconfirmed note
+	DeleteUnconfirmedNote(id int64) error
+}
+
+// TxnStore reads the txns and the tree root written by the subscriber service
+type TxnStore interface {
+	GetLeafIndexByCommitment(commitment []byte) (int, error)
+	GetAllLeavesCommitments() ([][]byte, error)
+	GetAllTxns() ([]*models.Txn, error)
+	// GetTxnsAfter returns the txns with a leaf index greater than the given one,
+	// ordered by leaf index
+	GetTxnsAfter(leafIndex int) ([]*models.Txn, error)
+	// GetLastLeafIndex returns the highest leaf index, -1 if there are no txns
+	GetLastLeafIndex() (int, error)
+	GetTxnByID(txnID string) (*models.Txn, error)
+	GetTxnByCommitment(commitment []byte) (*models.Txn, error)
+	// GetTxnBySpentNullifier returns the withdrawal txn spending the nullifier
+	GetTxnBySpentNullifier(nullifier []byte) (*models.Txn, error)
+	GetRoot() (root []byte, leafCount int, err error)
+	// GetWatermark returns the last round processed by the subscriber service
+	GetWatermark() (uint64, error)
+	// GetStats returns the totals kept by the subscriber service in the stats
+	// table, keyed by name
+	GetStats() (map[string]uint64, error)
+}
+
+// UnconfirmedNote is the decrypted linking data of an unconfirmed note
+type UnconfirmedNote struct {
+	Id         int64
+	Commitment []byte
+	Nullifier  []byte
+	TxnId      string
+	CreatedAt  string // UTC time as 2006-01-02 15:04:05
+	// SpentCommitment is the commitment of the note spent by the withdrawal of a
+	// change note, nil for deposits
+	SpentCommitment []byte
+}
+
+// receipt returns the receipt of the withdrawal txn inserting the unconfirmed
+// change note, nil for deposits
+func (n *UnconfirmedNote) receipt(txn *models.Txn) *models.Receipt {
+	if n.SpentCommitment == nil {
+		return nil
+	}
+	return &models.Receipt{
+		WithdrawalTxnID:  txn.TxnID,
+		Nullifier:        txn.FromNullifier,
+		SpentCommitment:  n.SpentCommitment,
+		ChangeCommitment: n.Commitment,
+	}
+}
+
+// the stores used by the package functions, set by init for the configured driver
+var (
+	notes            NoteStore
+	unconfirmedNotes UnconfirmedNoteStore
+	txns             TxnStore
+)
+
+// sqlNoteStore implements NoteStore and UnconfirmedNoteStore on the internal
+// database, SQLite or PostgreSQL
+type sqlNoteStore struct {
+	db *sqlDb
+}
+
+// execer is implemented by both *sqlDb and *sqlTx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// SaveNote succeeds if the note is already saved, by the txns watcher confirming
+// it before the request that sent its txn
+func (s *sqlNoteStore) SaveNote(n *models.Note) error {
+	err := insertNote(s.db, n.LeafIndex, n.Commitment(), n.Nullifier(), n.TxnID)
+	if err == nil {
+		return nil
+	}
+	if saved, errSaved := s.IsNoteSaved(n.TxnID); errSaved == nil && saved {
+		return nil
+	}
+	return err
+}
+
+// insertNote inserts a confirmed note in the notes table, encrypting its linking data
+func insertNote(e execer, leafIndex int, commitment, nullifier []byte, txnId string) error {
+	record, commitmentIndex, txnIdIndex, err := sealNoteRecord(commitment, nullifier, txnId)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt note: %w", err)
+	}
+	_, err = e.Exec(`INSERT INTO notes (leaf_index, commitment, nullifier, txn_id, key_id,
+		commitment_index, txn_id_index, aad_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		leafIndex, record.commitment, record.nullifier, record.txnId, record.keyId,
+		commitmentIndex, txnIdIndex, record.aadVersion)
+	if err != nil {
+		return fmt.Errorf("failed to insert note: %w", err)
+	}
+	return nil
+}
+
+// IsNoteSaved looks up the txn id by its blind index with every key, and in clear
+// for the rows not encrypted yet
+func (s *sqlNoteStore) IsNoteSaved(txnId string) (bool, error) {
+	indexes, err := blindIndexes("txn_id", []byte(txnId))
+	if err != nil {
+		return false, err
+	}
+	query := `SELECT COUNT(*) FROM notes WHERE txn_id_index IN (?` +
+		strings.Repeat(", ?", len(indexes)-1) + `) OR (key_id IS NULL AND txn_id = ?)`
+	var count int
+	err = s.db.QueryRow(query, append(indexes, txnId)...).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up note: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *sqlNoteStore) RegisterUnconfirmedNote(n *models.Note, spentCommitment []byte,
+) (int64, error) {
+	record, _, txnIdIndex, err := sealNoteRecord(n.Commitment(), n.Nullifier(), n.TxnID)
+	if err == nil {
+		err = record.sealSpentCommitment(spentCommitment)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt unconfirmed note: %w", err)
+	}
+	query := `INSERT INTO unconfirmed_notes (
+		commitment,
+		nullifier,
+		txn_id,
+		key_id,
+		txn_id_index,
+		aad_version,
+		spent_commitment
+		) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`
+	var id int64
+	err = s.db.QueryRow(query,
+		record.commitment,
+		record.nullifier,
+		record.txnId,
+		record.keyId,
+		txnIdIndex,
+		record.aadVersion,
+		record.spentCommitment,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register unconfirmed note %v: %w", n.TxnID, err)
+	}
+	return id, nil
+}
+
+func (s *sqlNoteStore) GetUnconfirmedNoteByTxnId(txnId string) (*UnconfirmedNote, error) {
+	indexes, err := blindIndexes("txn_id", []byte(txnId))
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + unconfirmedNoteColumns + ` FROM unconfirmed_notes
+		WHERE txn_id_index IN (?` + strings.Repeat(", ?", len(indexes)-1) + `)
+		OR (key_id IS NULL AND txn_id = ?)`
+	n, err := scanUnconfirmedNote(s.db.QueryRow(query, append(indexes, txnId)...))
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (s *sqlNoteStore) UnconfirmedNotes() ([]*UnconfirmedNote, error) {
+	rows, err := s.db.Query(`SELECT ` + unconfirmedNoteColumns + ` FROM unconfirmed_notes`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unconfirmed_notes: %w", err)
+	}
+	defer rows.Close()
+
+	var unconfirmed []*UnconfirmedNote
+	for rows.Next() {
+		n, err := scanUnconfirmedNote(rows)
+		if err != nil {
+			log.Printf("failed to read unconfirmed note: %v", err)
+			continue
+		}
+		unconfirmed = append(unconfirmed, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over unconfirmed_notes rows: %w", err)
+	}
+	return unconfirmed, nil
+}
+
+func (s *sqlNoteStore) ConfirmNote(n *UnconfirmedNote, txn *models.Txn) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := insertNote(tx, txn.LeafIndex, n.Commitment, n.Nullifier, n.TxnId); err != nil {
+		return err
+	}
+	if r := n.receipt(txn); r != nil {
+		if err := insertReceipt(tx, r); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, n.Id); err != nil {
+		return fmt.Errorf("failed to delete unconfirmed note: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// unconfirmedNoteColumns are the columns read by scanUnconfirmedNote
+const unconfirmedNoteColumns = `id, commitment, nullifier, txn_id, key_id, txn_id_index,
+	aad_version, spent_commitment, created_at`
+
+// scanUnconfirmedNote scans and decrypts an unconfirmed note read with
+// unconfirmedNoteColumns
+func scanUnconfirmedNote(row interface{ Scan(...any) error }) (*UnconfirmedNote, error) {
+	n := &UnconfirmedNote{}
+	var record noteRecord
+	if err := row.Scan(&n.Id, &record.commitment, &record.nullifier, &record.txnId,
+		&record.keyId, &record.txnIdIndex, &record.aadVersion, &record.spentCommitment,
+		&n.CreatedAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if n.Commitment, n.Nullifier, n.TxnId, err = record.open(); err == nil {
+		n.SpentCommitment, err = record.openSpentCommitment()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt unconfirmed note id %d: %w", n.Id, err)
+	}
+	return n, nil
+}
+
+func (s *sqlNoteStore) DeleteUnconfirmedNote(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
+	return err
+}
+
+// sqlTxnStore implements TxnStore on the transactions database, always SQLite
+// since the subscriber service writing it has no PostgreSQL backend
+type sqlTxnStore struct {
+	db *sqlDb
+}
+
+func (s *sqlTxnStore) GetLeafIndexByCommitment(commitment []byte) (int, error) {
+	query := `SELECT leaf_index FROM txns WHERE commitment = ?`
+	var index int
+	err := s.db.QueryRow(query, commitment).Scan(&index)
+	return index, err
+}
+
+func (s *sqlTxnStore) GetAllLeavesCommitments() ([][]byte, error) {
+	query := `SELECT commitment FROM txns ORDER BY leaf_index ASC`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commitments [][]byte
+	for rows.Next() {
+		var commitment []byte
+		if err := rows.Scan(&commitment); err != nil {
+			return nil, err
+		}
+		commitments = append(commitments, commitment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return commitments, nil
+}
+
+func (s *sqlTxnStore) GetAllTxns() ([]*models.Txn, error) {
+	return s.GetTxnsAfter(-1)
+}
+
+func (s *sqlTxnStore) GetTxnsAfter(leafIndex int) ([]*models.Txn, error) {
+	query := `SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE leaf_index > ? ORDER BY leaf_index ASC`
+	rows, err := s.db.Query(query, leafIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []*models.Txn
+	for rows.Next() {
+		t := &models.Txn{}
+		if err := rows.Scan(&t.LeafIndex, &t.Commitment, &t.TxnID, &t.Type, &t.Address,
+			&t.Amount, &t.FromNullifier); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return txns, nil
+}
+
+func (s *sqlTxnStore) GetLastLeafIndex() (int, error) {
+	var leafIndex int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(leaf_index), -1) FROM txns`).Scan(&leafIndex)
+	return leafIndex, err
+}
+
+func (s *sqlTxnStore) GetTxnByID(txnID string) (*models.Txn, error) {
+	query := `SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE txn_id = ?`
+	return scanTxn(s.db.QueryRow(query, txnID))
+}
+
+func (s *sqlTxnStore) GetTxnByCommitment(commitment []byte) (*models.Txn, error) {
+	query := `SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE commitment = ?`
+	return scanTxn(s.db.QueryRow(query, commitment))
+}
+
+func (s *sqlTxnStore) GetTxnBySpentNullifier(nullifier []byte) (*models.Txn, error) {
+	query := `SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE from_nullifier = ?`
+	return scanTxn(s.db.QueryRow(query, nullifier))
+}
+
+// scanTxn scans a txns table row into a Txn
+func scanTxn(row *sql.Row) (*models.Txn, error) {
+	t := &models.Txn{}
+	err := row.Scan(&t.LeafIndex, &t.Commitment, &t.TxnID, &t.Type, &t.Address,
+		&t.Amount, &t.FromNullifier)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *sqlTxnStore) GetRoot() (root []byte, leafCount int, err error) {
+	query := `SELECT value, leaf_count FROM roots`
+	err = s.db.QueryRow(query).Scan(&root, &leafCount)
+	return
+}
+
+func (s *sqlTxnStore) GetWatermark() (uint64, error) {
+	var round uint64
+	err := s.db.QueryRow(`SELECT value FROM watermark`).Scan(&round)
+	return round, err
+}
+
+func (s *sqlTxnStore) GetStats() (map[string]uint64, error) {
+	rows, err := s.db.Query(`SELECT key, COALESCE(value, 0) FROM stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]uint64)
+	for rows.Next() {
+		var key string
+		var value uint64
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		stats[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
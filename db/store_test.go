@@ -0,0 +1,427 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// postgresDsnEnv is the environment variable with the connection string of a
+// PostgreSQL database to run the store tests on too, set by test-postgres.sh. Each
+// run works in its own schema, dropped at the end
+const postgresDsnEnv = "HERMES_TEST_POSTGRES_DSN"
+
+// testDbs returns the internal databases to run the store tests on: a new SQLite
+// file, and PostgreSQL if postgresDsnEnv is set
+func testDbs(t *testing.T) map[string]*sqlDb {
+	t.Helper()
+	dbs := map[string]*sqlDb{}
+
+	sqliteDb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "internal.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	t.Cleanup(func() { sqliteDb.Close() })
+	dbs[sqliteDriver] = &sqlDb{DB: sqliteDb, dialect: sqliteDialect}
+
+	dsn := os.Getenv(postgresDsnEnv)
+	if dsn == "" {
+		t.Logf("%s not set, skipping PostgreSQL", postgresDsnEnv)
+		return dbs
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to open PostgreSQL database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("hermes_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create test schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+	postgresDb, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("failed to open PostgreSQL test schema: %v", err)
+	}
+	t.Cleanup(func() { postgresDb.Close() })
+	dbs[postgresDriver] = &sqlDb{DB: postgresDb, dialect: postgresDialect}
+	return dbs
+}
+
+// withSearchPath adds the search_path run-time parameter to a connection string,
+// in URL or key=value form
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// useInternalDb makes db the internal database, with a test note key, until the
+// end of the test
+func useInternalDb(t *testing.T, db *sqlDb) {
+	t.Helper()
+	savedDb, savedKeyring := internalDb, keyring
+	t.Cleanup(func() { internalDb, keyring = savedDb, savedKeyring })
+	kr, err := parseNoteKeys("1:" + strings.Repeat("ab", noteKeySize))
+	if err != nil {
+		t.Fatalf("failed to parse test note key: %v", err)
+	}
+	internalDb, keyring = db, kr
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{sqliteDialect, `SELECT a FROM t WHERE b = ? AND c IN (?, ?)`,
+			`SELECT a FROM t WHERE b = ? AND c IN (?, ?)`},
+		{postgresDialect, `SELECT a FROM t WHERE b = ? AND c IN (?, ?)`,
+			`SELECT a FROM t WHERE b = $1 AND c IN ($2, $3)`},
+		{postgresDialect, `SELECT a FROM t`, `SELECT a FROM t`},
+		{postgresDialect, `INSERT INTO t (a, b) VALUES (?, ?) RETURNING id`,
+			`INSERT INTO t (a, b) VALUES ($1, $2) RETURNING id`},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.rebind(tt.query); got != tt.want {
+			t.Errorf("%s rebind(%q) = %q, want %q", tt.dialect.driver, tt.query, got,
+				tt.want)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for name, db := range testDbs(t) {
+		t.Run(name, func(t *testing.T) {
+			useInternalDb(t, db)
+			current, latest, err := SchemaVersion()
+			if err != nil || current != 0 {
+				t.Fatalf("SchemaVersion() of a new database = %d, %v, want 0", current, err)
+			}
+			if err := CheckSchemaVersion(); !errors.Is(err, ErrDatabaseOutdated) {
+				t.Fatalf("CheckSchemaVersion() = %v, want %v", err, ErrDatabaseOutdated)
+			}
+			if _, err := Migrate(true); err != nil {
+				t.Fatalf("Migrate(dry run): %v", err)
+			}
+			if current, _, _ := SchemaVersion(); current != 0 {
+				t.Fatalf("dry run applied migrations, version %d", current)
+			}
+			var tables int
+			if err := db.QueryRow(db.dialect.tableExists,
+				"schema_migrations").Scan(&tables); err != nil || tables != 0 {
+				t.Fatalf("dry run left the schema_migrations table, %v", err)
+			}
+			applied, err := Migrate(false)
+			if err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			if want := len(migrations()); len(applied) != want {
+				t.Fatalf("Migrate applied %d migrations, want %d", len(applied), want)
+			}
+			if err := CheckSchemaVersion(); err != nil {
+				t.Fatalf("CheckSchemaVersion() after Migrate = %v", err)
+			}
+			if applied, err := Migrate(false); err != nil || len(applied) != 0 {
+				t.Fatalf("second Migrate applied %d migrations, %v", len(applied), err)
+			}
+			status, err := MigrationsStatus()
+			if err != nil {
+				t.Fatalf("MigrationsStatus: %v", err)
+			}
+			for _, m := range status {
+				if m.AppliedAt == "" {
+					t.Errorf("migration %d not applied", m.Version)
+				}
+			}
+			if _, err := db.Exec(`INSERT INTO schema_migrations (version, name)
+				VALUES (?, ?)`, latest+1, "future"); err != nil {
+				t.Fatalf("failed to record future migration: %v", err)
+			}
+			if _, err := Migrate(false); !errors.Is(err, ErrDatabaseNewer) {
+				t.Fatalf("Migrate on a newer database = %v, want %v", err, ErrDatabaseNewer)
+			}
+		})
+	}
+}
+
+// TestBackfillReceipts runs on SQLite only, PostgreSQL databases never had the
+// debug_notes table
+func TestBackfillReceipts(t *testing.T) {
+	db := testDbs(t)[sqliteDriver]
+	useInternalDb(t, db)
+	savedTxns := txns
+	t.Cleanup(func() { txns = savedTxns })
+	txns = newTestTxnStore(t)
+
+	if err := createMigrationsTable(internalDb); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sqliteMigrations[:3] {
+		if err := applyMigrationInTx(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the deposit D0 inserted the note with nullifier aa, spent by W1
+	_, err := db.Exec(`INSERT INTO notes (leaf_index, commitment, nullifier, txn_id)
+		VALUES (0, x'01', x'aa', 'D0'), (1, x'02', x'bb', 'W1')`)
+	if err != nil {
+		t.Fatalf("failed to insert notes: %v", err)
+	}
+	if _, err := Migrate(false); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	want := &models.Receipt{WithdrawalTxnID: "W1", Nullifier: []byte{0xaa},
+		SpentCommitment: []byte{0x01}, ChangeCommitment: []byte{0x02}}
+	check := func(when string) {
+		got, err := GetReceiptByWithdrawal("W1")
+		if err != nil || !bytes.Equal(got.Nullifier, want.Nullifier) ||
+			!bytes.Equal(got.SpentCommitment, want.SpentCommitment) ||
+			!bytes.Equal(got.ChangeCommitment, want.ChangeCommitment) {
+			t.Fatalf("GetReceiptByWithdrawal(W1) %s = %+v, %v, want %+v", when, got, err,
+				want)
+		}
+	}
+	check("after Migrate")
+	if _, err := GetReceiptByWithdrawal("D0"); err != sql.ErrNoRows {
+		t.Fatalf("GetReceiptByWithdrawal(D0) = %v, want %v", err, sql.ErrNoRows)
+	}
+	if _, err := RotateNoteKeys(); err != nil {
+		t.Fatalf("RotateNoteKeys: %v", err)
+	}
+	check("after RotateNoteKeys")
+}
+
+func TestNoteStore(t *testing.T) {
+	for name, db := range testDbs(t) {
+		t.Run(name, func(t *testing.T) {
+			useInternalDb(t, db)
+			if _, err := Migrate(false); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			s := &sqlNoteStore{db: db}
+
+			notes := make([]*models.Note, 3)
+			ids := make([]int64, len(notes))
+			// the first note is the change note of a withdrawal
+			spentCommitment := []byte{0xcc}
+			for i := range notes {
+				n, err := models.GenerateNote(uint64(1000 + i))
+				if err != nil {
+					t.Fatalf("GenerateNote: %v", err)
+				}
+				n.TxnID = fmt.Sprintf("TXN%d", i)
+				notes[i] = n
+				var spent []byte
+				if i == 0 {
+					spent = spentCommitment
+				}
+				if ids[i], err = s.RegisterUnconfirmedNote(n, spent); err != nil {
+					t.Fatalf("RegisterUnconfirmedNote: %v", err)
+				}
+				if i > 0 && ids[i] <= ids[i-1] {
+					t.Fatalf("RegisterUnconfirmedNote returned id %d after %d", ids[i],
+						ids[i-1])
+				}
+			}
+
+			unconfirmed, err := s.UnconfirmedNotes()
+			if err != nil || len(unconfirmed) != len(notes) {
+				t.Fatalf("UnconfirmedNotes() = %d notes, %v, want %d", len(unconfirmed),
+					err, len(notes))
+			}
+			for i, n := range notes {
+				u, err := s.GetUnconfirmedNoteByTxnId(n.TxnID)
+				if err != nil {
+					t.Fatalf("GetUnconfirmedNoteByTxnId(%s): %v", n.TxnID, err)
+				}
+				if u.Id != ids[i] || u.TxnId != n.TxnID ||
+					!bytes.Equal(u.Commitment, n.Commitment()) ||
+					!bytes.Equal(u.Nullifier, n.Nullifier()) ||
+					(i == 0) != bytes.Equal(u.SpentCommitment, spentCommitment) {
+					t.Fatalf("GetUnconfirmedNoteByTxnId(%s) = %+v, want note %d", n.TxnID,
+						u, ids[i])
+				}
+			}
+			if _, err := s.GetUnconfirmedNoteByTxnId("MISSING"); err != sql.ErrNoRows {
+				t.Fatalf("GetUnconfirmedNoteByTxnId(MISSING) = %v, want %v", err,
+					sql.ErrNoRows)
+			}
+
+			u, _ := s.GetUnconfirmedNoteByTxnId(notes[0].TxnID)
+			txn := &models.Txn{LeafIndex: 7, TxnID: "TXN0", Type: models.WithdrawalTxnType,
+				FromNullifier: []byte{0xaa}}
+			if err := s.ConfirmNote(u, txn); err != nil {
+				t.Fatalf("ConfirmNote: %v", err)
+			}
+			receipt, err := GetReceiptByWithdrawal("TXN0")
+			if err != nil || !bytes.Equal(receipt.SpentCommitment, spentCommitment) ||
+				!bytes.Equal(receipt.Nullifier, txn.FromNullifier) ||
+				!bytes.Equal(receipt.ChangeCommitment, notes[0].Commitment()) {
+				t.Fatalf("GetReceiptByWithdrawal(TXN0) = %+v, %v after ConfirmNote",
+					receipt, err)
+			}
+			if err := SaveReceipt(receipt); err != nil {
+				t.Fatalf("SaveReceipt of a saved receipt: %v", err)
+			}
+			notes[1].LeafIndex = 8
+			if err := s.SaveNote(notes[1]); err != nil {
+				t.Fatalf("SaveNote: %v", err)
+			}
+			// the request that sent the txn saves the note after the watcher
+			notes[0].LeafIndex = 7
+			if err := s.SaveNote(notes[0]); err != nil {
+				t.Fatalf("SaveNote of a confirmed note: %v", err)
+			}
+			notes[2].LeafIndex = 8
+			if err := s.SaveNote(notes[2]); err == nil {
+				t.Fatalf("SaveNote of another note at a saved leaf index succeeded")
+			}
+			if err := s.DeleteUnconfirmedNote(ids[2]); err != nil {
+				t.Fatalf("DeleteUnconfirmedNote: %v", err)
+			}
+
+			for txnId, want := range map[string]bool{"TXN0": true, "TXN1": true,
+				"TXN2": false} {
+				if saved, err := s.IsNoteSaved(txnId); err != nil || saved != want {
+					t.Errorf("IsNoteSaved(%s) = %v, %v, want %v", txnId, saved, err, want)
+				}
+			}
+			unconfirmed, err = s.UnconfirmedNotes()
+			if err != nil || len(unconfirmed) != 1 || unconfirmed[0].Id != ids[1] {
+				t.Fatalf("UnconfirmedNotes() = %d notes, %v, want only note %d",
+					len(unconfirmed), err, ids[1])
+			}
+		})
+	}
+}
+
+func TestInternalRecords(t *testing.T) {
+	for name, db := range testDbs(t) {
+		t.Run(name, func(t *testing.T) {
+			useInternalDb(t, db)
+			if _, err := Migrate(false); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+
+			receipt := &models.Receipt{WithdrawalTxnID: "WTXN", Nullifier: []byte{1},
+				SpentCommitment: []byte{2}, ChangeCommitment: []byte{3}}
+			if err := SaveReceipt(receipt); err != nil {
+				t.Fatalf("SaveReceipt: %v", err)
+			}
+			got, err := GetReceiptByWithdrawal("WTXN")
+			if err != nil || !bytes.Equal(got.Nullifier, receipt.Nullifier) ||
+				!bytes.Equal(got.ChangeCommitment, receipt.ChangeCommitment) {
+				t.Fatalf("GetReceiptByWithdrawal = %+v, %v, want %+v", got, err, receipt)
+			}
+			if _, err := GetReceiptByWithdrawal("MISSING"); err != sql.ErrNoRows {
+				t.Fatalf("GetReceiptByWithdrawal(MISSING) = %v, want %v", err,
+					sql.ErrNoRows)
+			}
+
+			p := models.ProtocolParams{DepositMinimumAmount: 1, WithdrawalFeeDivisor: 2,
+				WithdrawalMinimumFee: 3}
+			for i, want := range []bool{true, false} {
+				if saved, err := SaveProtocolParams(p); err != nil || saved != want {
+					t.Fatalf("SaveProtocolParams #%d = %v, %v, want %v", i, saved, err, want)
+				}
+			}
+			p.WithdrawalMinimumFee = 4
+			if _, err := SaveProtocolParams(p); err != nil {
+				t.Fatalf("SaveProtocolParams: %v", err)
+			}
+			history, err := GetProtocolParamsHistory()
+			if err != nil || len(history) != 2 || history[1] != p {
+				t.Fatalf("GetProtocolParamsHistory() = %v, %v", history, err)
+			}
+
+			for i, want := range []bool{true, false} {
+				saved, err := SaveAssociationRoot("policy", []byte{9}, 1)
+				if err != nil || saved != want {
+					t.Fatalf("SaveAssociationRoot #%d = %v, %v, want %v", i, saved, err,
+						want)
+				}
+			}
+		})
+	}
+}
+
+// newTestTxnStore returns a transactions database with the deposit D0 and the
+// withdrawal W1 spending the note with nullifier aa. It is always SQLite, written
+// by the subscriber service
+func newTestTxnStore(t *testing.T) *sqlTxnStore {
+	t.Helper()
+	sqliteDb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "txns.db"))
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	t.Cleanup(func() { sqliteDb.Close() })
+	_, err = sqliteDb.Exec(`
+		CREATE TABLE txns (leaf_index INTEGER PRIMARY KEY, commitment BLOB, txn_id TEXT,
+			txn_type INTEGER, address TEXT, amount INTEGER, from_nullifier BLOB);
+		CREATE TABLE roots (value BLOB, leaf_count INTEGER);
+		CREATE TABLE watermark (value INTEGER);
+		INSERT INTO txns VALUES (0, x'01', 'D0', 0, 'A', 10, NULL),
+			(1, x'02', 'W1', 1, 'B', 4, x'aa');
+		INSERT INTO roots VALUES (x'ff', 2);
+		INSERT INTO watermark VALUES (123);
+	`)
+	if err != nil {
+		t.Fatalf("failed to create txns tables: %v", err)
+	}
+	return &sqlTxnStore{db: &sqlDb{DB: sqliteDb, dialect: sqliteDialect}}
+}
+
+// TestTxnStore runs on SQLite only, the subscriber service writes the transactions
+// database with no PostgreSQL backend
+func TestTxnStore(t *testing.T) {
+	s := newTestTxnStore(t)
+
+	if all, err := s.GetAllTxns(); err != nil || len(all) != 2 || all[1].TxnID != "W1" {
+		t.Fatalf("GetAllTxns() = %v, %v", all, err)
+	}
+	if after, err := s.GetTxnsAfter(0); err != nil || len(after) != 1 {
+		t.Fatalf("GetTxnsAfter(0) = %v, %v", after, err)
+	}
+	if last, err := s.GetLastLeafIndex(); err != nil || last != 1 {
+		t.Fatalf("GetLastLeafIndex() = %d, %v, want 1", last, err)
+	}
+	if i, err := s.GetLeafIndexByCommitment([]byte{2}); err != nil || i != 1 {
+		t.Fatalf("GetLeafIndexByCommitment = %d, %v, want 1", i, err)
+	}
+	txn, err := s.GetTxnByID("D0")
+	if err != nil || txn.Type != models.DepositTxnType || txn.FromNullifier != nil {
+		t.Fatalf("GetTxnByID(D0) = %+v, %v", txn, err)
+	}
+	if txn, err := s.GetTxnBySpentNullifier([]byte{0xaa}); err != nil || txn.TxnID != "W1" {
+		t.Fatalf("GetTxnBySpentNullifier = %+v, %v", txn, err)
+	}
+	if _, err := s.GetTxnByCommitment([]byte{3}); err != sql.ErrNoRows {
+		t.Fatalf("GetTxnByCommitment(missing) = %v, want %v", err, sql.ErrNoRows)
+	}
+	if root, n, err := s.GetRoot(); err != nil || n != 2 || !bytes.Equal(root, []byte{0xff}) {
+		t.Fatalf("GetRoot() = %x, %d, %v", root, n, err)
+	}
+	if round, err := s.GetWatermark(); err != nil || round != 123 {
+		t.Fatalf("GetWatermark() = %d, %v, want 123", round, err)
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The txns watcher checks the transactions database for new txns with a high-water
+// mark on leaf_index, which works on every backend and, unlike PRAGMA data_version,
+// does not depend on reusing the same SQLite connection. Only the txns above the
+// mark are reconciled with the unconfirmed notes.
+
+// ReconciliationMetrics are the metrics of the txns watcher, reported by the
+// health check
+type ReconciliationMetrics struct {
+	// WatchedLeafIndex is the highest leaf index seen by the watcher
+	WatchedLeafIndex int `json:"watched_leaf_index"`
+	// ReconciledNotes counts the unconfirmed notes reconciled by the watcher
+	ReconciledNotes int64 `json:"reconciled_notes"`
+	// LastLagSeconds is the time between the registration of the last reconciled
+	// note and its reconciliation
+	LastLagSeconds float64 `json:"last_lag_seconds"`
+	// LastDetectionLagSeconds is the time between the subscriber writing the txn
+	// of the last reconciled note and its reconciliation, measured from the round
+	// of the subscriber watermark when the watcher found the txn, so it includes
+	// the watch interval
+	LastDetectionLagSeconds float64 `json:"last_detection_lag_seconds"`
+}
+
+var (
+	reconciliationMu      sync.Mutex
+	reconciliationMetrics = ReconciliationMetrics{WatchedLeafIndex: -1}
+)
+
+// GetReconciliationMetrics returns the current metrics of the txns watcher
+func GetReconciliationMetrics() ReconciliationMetrics {
+	reconciliationMu.Lock()
+	defer reconciliationMu.Unlock()
+	return reconciliationMetrics
+}
+
+// updateReconciliationMetrics applies f to the metrics of the txns watcher
+func updateReconciliationMetrics(f func(m *ReconciliationMetrics)) {
+	reconciliationMu.Lock()
+	defer reconciliationMu.Unlock()
+	f(&reconciliationMetrics)
+}
+
+// setWatchedLeafIndex records the highest leaf index seen by the watcher
+func setWatchedLeafIndex(mark int) {
+	updateReconciliationMetrics(func(m *ReconciliationMetrics) {
+		m.WatchedLeafIndex = mark
+	})
+}
+
+// RoundTime returns the time of the block of a round
+type RoundTime func(round uint64) (time.Time, error)
+
+// StartTxnsWatcher starts a goroutine that checks the transactions database for
+// new txns every interval and reconciles the unconfirmed notes they insert.
+// roundTime dates the watermark of the subscriber for the detection lag.
+// It returns a cancel function that can be used to stop the routine.
+func StartTxnsWatcher(ctx context.Context, interval time.Duration,
+	roundTime RoundTime) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		// the txns before the start are left to CleanupUnconfirmedNotes
+		mark, err := txns.GetLastLeafIndex()
+		if err != nil {
+			log.Printf("Error starting txns watcher: %v", err)
+			mark = -1
+		}
+		setWatchedLeafIndex(mark)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mark = reconcileNewTxns(mark, roundTime)
+				setWatchedLeafIndex(mark)
+			case <-ctx.Done():
+				log.Println("Txns watcher stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// reconcileNewTxns reconciles the unconfirmed notes inserted by the txns after
+// the mark, and returns the new mark
+func reconcileNewTxns(mark int, roundTime RoundTime) int {
+	last, err := txns.GetLastLeafIndex()
+	if err != nil {
+		log.Printf("Error checking for new txns: %v", err)
+		return mark
+	}
+	if last <= mark {
+		return mark
+	}
+	writtenAt, errWrittenAt := watermarkTime(roundTime)
+	if errWrittenAt != nil {
+		log.Printf("Error dating the new txns: %v", errWrittenAt)
+	}
+	newTxns, err := txns.GetTxnsAfter(mark)
+	if err != nil {
+		log.Printf("Error reading new txns: %v", err)
+		return mark
+	}
+	for _, txn := range newTxns {
+		n, err := unconfirmedNotes.GetUnconfirmedNoteByTxnId(txn.TxnID)
+		switch {
+		case err == sql.ErrNoRows:
+			// the note was saved when its txn was confirmed, or was not ours
+		case err != nil:
+			// left to CleanupUnconfirmedNotes
+			log.Printf("Error looking up unconfirmed note for txn %s: %v", txn.TxnID, err)
+		case reconcileNote(n, txn):
+			createdAt, errCreatedAt := time.Parse("2006-01-02 15:04:05", n.CreatedAt)
+			updateReconciliationMetrics(func(m *ReconciliationMetrics) {
+				m.ReconciledNotes++
+				if errWrittenAt == nil {
+					m.LastDetectionLagSeconds = time.Since(writtenAt).Seconds()
+				}
+				if errCreatedAt == nil {
+					m.LastLagSeconds = time.Since(createdAt).Seconds()
+				}
+			})
+		}
+		mark = txn.LeafIndex
+	}
+	return mark
+}
+
+// watermarkTime returns the time of the last round processed by the subscriber,
+// by which it has written the txns read after it
+func watermarkTime(roundTime RoundTime) (time.Time, error) {
+	round, err := txns.GetWatermark()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the subscriber watermark: %w", err)
+	}
+	return roundTime(round)
+}
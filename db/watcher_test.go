@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+func TestReconcileNewTxns(t *testing.T) {
+	db := testDbs(t)[sqliteDriver]
+	useInternalDb(t, db)
+	if _, err := Migrate(false); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	savedNotes, savedUnconfirmed, savedTxns := notes, unconfirmedNotes, txns
+	savedMetrics := GetReconciliationMetrics()
+	t.Cleanup(func() {
+		notes, unconfirmedNotes, txns = savedNotes, savedUnconfirmed, savedTxns
+		updateReconciliationMetrics(func(m *ReconciliationMetrics) { *m = savedMetrics })
+	})
+	noteStore := &sqlNoteStore{db: db}
+	txnStore := newTestTxnStore(t)
+	notes, unconfirmedNotes, txns = noteStore, noteStore, txnStore
+
+	n, err := models.GenerateNote(1000)
+	if err != nil {
+		t.Fatalf("GenerateNote: %v", err)
+	}
+	n.TxnID = "D2"
+	if _, err := noteStore.RegisterUnconfirmedNote(n, nil); err != nil {
+		t.Fatalf("RegisterUnconfirmedNote: %v", err)
+	}
+	if _, err := txnStore.db.Exec(`INSERT INTO txns VALUES (2, ?, 'D2', 0, 'C', 1000,
+		NULL)`, n.Commitment()); err != nil {
+		t.Fatalf("failed to insert txn: %v", err)
+	}
+
+	// the subscriber wrote the txn by the watermark round, 10 seconds ago
+	roundTime := func(round uint64) (time.Time, error) {
+		if round != 123 {
+			t.Errorf("roundTime(%d), want the watermark 123", round)
+		}
+		return time.Now().Add(-10 * time.Second), nil
+	}
+	if mark := reconcileNewTxns(1, roundTime); mark != 2 {
+		t.Fatalf("reconcileNewTxns(1) = %d, want 2", mark)
+	}
+	if saved, err := noteStore.IsNoteSaved("D2"); err != nil || !saved {
+		t.Fatalf("IsNoteSaved(D2) = %v, %v after reconciliation", saved, err)
+	}
+	m := GetReconciliationMetrics()
+	if m.ReconciledNotes != savedMetrics.ReconciledNotes+1 {
+		t.Errorf("ReconciledNotes = %d, want %d", m.ReconciledNotes,
+			savedMetrics.ReconciledNotes+1)
+	}
+	if m.LastDetectionLagSeconds < 10 || m.LastDetectionLagSeconds > 11 {
+		t.Errorf("LastDetectionLagSeconds = %v, want about 10", m.LastDetectionLagSeconds)
+	}
+}
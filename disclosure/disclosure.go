@@ -0,0 +1,206 @@
+// Package disclosure implements selective disclosure proofs, which let a user show
+// that a withdrawal comes from one of their deposits without revealing their notes
+package disclosure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp/circuits"
+
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/frontend"
+	"github.com/giuliop/algoplonk"
+	"github.com/giuliop/algoplonk/setup"
+)
+
+// ProofVersion is the version of the disclosure proof format
+const ProofVersion = 1
+
+// Link proves that the note with Commitment is the note with Nullifier
+type Link struct {
+	Commitment []byte `json:"commitment"`
+	Nullifier  []byte `json:"nullifier"`
+	Proof      []byte `json:"proof"`
+}
+
+// Proof links the withdrawal with WithdrawalTxnID to its original deposit.
+// Links[0] is the deposit note, each following link is the change note of the
+// withdrawal spending the previous one, and the last link is the note spent by
+// the withdrawal
+type Proof struct {
+	Version         int    `json:"version"`
+	WithdrawalTxnID string `json:"withdrawalTxnId"`
+	Links           []Link `json:"links"`
+}
+
+// ChainSource provides the public txn data to verify a disclosure proof against
+type ChainSource interface {
+	GetTxnByID(txnID string) (*models.Txn, error)
+	GetTxnByCommitment(commitment []byte) (*models.Txn, error)
+}
+
+// Report is the result of a successful verification of a disclosure proof
+type Report struct {
+	Deposit     *models.Txn   `json:"deposit"`
+	Withdrawals []*models.Txn `json:"withdrawals"` // from the first to the disclosed one
+}
+
+// Compile compiles the disclosure circuit with the trusted setup
+func Compile() (*algoplonk.CompiledCircuit, error) {
+	return algoplonk.Compile(&circuits.DisclosureCircuit{}, constants.Curve, setup.Trusted)
+}
+
+// Prove creates a disclosure proof linking the withdrawal with the given txn id to
+// its deposit. The notes go from the deposit note to the note spent by the withdrawal
+func Prove(cc *algoplonk.CompiledCircuit, notes []*models.Note, withdrawalTxnID string,
+) (*Proof, error) {
+	if len(notes) == 0 {
+		return nil, fmt.Errorf("no notes to disclose")
+	}
+	proof := &Proof{Version: ProofVersion, WithdrawalTxnID: withdrawalTxnID}
+	for i, note := range notes {
+		assignment := &circuits.DisclosureCircuit{
+			Commitment: note.Commitment(),
+			Nullifier:  note.Nullifier(),
+			Amount:     note.Amount,
+			K:          note.K[:],
+			R:          note.R[:],
+		}
+		verifiedProof, err := cc.Verify(assignment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prove note %d: %v", i, err)
+		}
+		var buf bytes.Buffer
+		if _, err := verifiedProof.Proof.WriteTo(&buf); err != nil {
+			return nil, fmt.Errorf("failed to serialize proof for note %d: %v", i, err)
+		}
+		proof.Links = append(proof.Links, Link{
+			Commitment: note.Commitment(),
+			Nullifier:  note.Nullifier(),
+			Proof:      buf.Bytes(),
+		})
+	}
+	return proof, nil
+}
+
+// Verify checks the disclosure proof against the verifying key and the public txn
+// data. It returns a report of the deposit and withdrawals the proof links
+func Verify(vk plonk.VerifyingKey, proof *Proof, source ChainSource) (*Report, error) {
+	if proof.Version != ProofVersion {
+		return nil, fmt.Errorf("unsupported proof version %d", proof.Version)
+	}
+	if len(proof.Links) == 0 {
+		return nil, fmt.Errorf("proof has no links")
+	}
+	withdrawal, err := source.GetTxnByID(proof.WithdrawalTxnID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawal txn %s: %v",
+			proof.WithdrawalTxnID, err)
+	}
+	if withdrawal.Type != models.WithdrawalTxnType {
+		return nil, fmt.Errorf("txn %s is not a withdrawal", proof.WithdrawalTxnID)
+	}
+	last := proof.Links[len(proof.Links)-1]
+	if !bytes.Equal(last.Nullifier, withdrawal.FromNullifier) {
+		return nil, fmt.Errorf("last note is not the one spent by the withdrawal")
+	}
+
+	report := &Report{}
+	for i, link := range proof.Links {
+		if err := verifyLink(vk, &link); err != nil {
+			return nil, fmt.Errorf("invalid proof for note %d: %v", i, err)
+		}
+		txn, err := source.GetTxnByCommitment(link.Commitment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get txn inserting note %d: %v", i, err)
+		}
+		if i == 0 {
+			if txn.Type != models.DepositTxnType {
+				return nil, fmt.Errorf("first note was not inserted by a deposit")
+			}
+			report.Deposit = txn
+			continue
+		}
+		// note i must be the change note of the withdrawal spending note i-1
+		if txn.Type != models.WithdrawalTxnType ||
+			!bytes.Equal(txn.FromNullifier, proof.Links[i-1].Nullifier) {
+			return nil, fmt.Errorf("note %d is not the change of note %d", i, i-1)
+		}
+		report.Withdrawals = append(report.Withdrawals, txn)
+	}
+	report.Withdrawals = append(report.Withdrawals, withdrawal)
+	return report, nil
+}
+
+// verifyLink verifies the zk proof of a link
+func verifyLink(vk plonk.VerifyingKey, link *Link) error {
+	p := plonk.NewProof(constants.Curve)
+	if _, err := p.ReadFrom(bytes.NewReader(link.Proof)); err != nil {
+		return fmt.Errorf("failed to read proof: %v", err)
+	}
+	assignment := &circuits.DisclosureCircuit{
+		Commitment: link.Commitment,
+		Nullifier:  link.Nullifier,
+	}
+	publicWitness, err := frontend.NewWitness(assignment, constants.Curve.ScalarField(),
+		frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("failed to create public witness: %v", err)
+	}
+	return plonk.Verify(p, vk, publicWitness)
+}
+
+// WriteVerifyingKey writes the verifying key of the compiled circuit to file, so
+// that it can be distributed to verifiers without the much larger proving key
+func WriteVerifyingKey(cc *algoplonk.CompiledCircuit, filepath string) error {
+	file, err := os.Create(filepath)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	defer file.Close()
+	if _, err := cc.Vk.WriteTo(file); err != nil {
+		return fmt.Errorf("error writing verifying key: %v", err)
+	}
+	return nil
+}
+
+// ReadVerifyingKey reads a verifying key written by WriteVerifyingKey
+func ReadVerifyingKey(filepath string) (plonk.VerifyingKey, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	defer file.Close()
+	vk := plonk.NewVerifyingKey(constants.Curve)
+	if _, err := vk.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("error reading verifying key: %v", err)
+	}
+	return vk, nil
+}
+
+// WriteProof writes the proof to file as JSON
+func WriteProof(proof *Proof, filepath string) error {
+	data, err := json.MarshalIndent(proof, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding proof: %v", err)
+	}
+	return os.WriteFile(filepath, data, 0644)
+}
+
+// ReadProof reads a proof written by WriteProof
+func ReadProof(filepath string) (*Proof, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading proof: %v", err)
+	}
+	proof := &Proof{}
+	if err := json.Unmarshal(data, proof); err != nil {
+		return nil, fmt.Errorf("error decoding proof: %v", err)
+	}
+	return proof, nil
+}
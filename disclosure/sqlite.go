@@ -0,0 +1,55 @@
+package disclosure
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSource is a ChainSource reading the SQLite txns database of the subscriber
+// service, so that proofs can be verified without the frontend databases
+type SQLiteSource struct {
+	db *sql.DB
+}
+
+// OpenSQLiteSource opens the SQLite txns database at path in read-only mode
+func OpenSQLiteSource(path string) (*SQLiteSource, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transactions database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open transactions database: %w", err)
+	}
+	return &SQLiteSource{db: db}, nil
+}
+
+// Close closes the database
+func (s *SQLiteSource) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteSource) GetTxnByID(txnID string) (*models.Txn, error) {
+	return s.scanTxn(`SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE txn_id = ?`, txnID)
+}
+
+func (s *SQLiteSource) GetTxnByCommitment(commitment []byte) (*models.Txn, error) {
+	return s.scanTxn(`SELECT leaf_index, commitment, txn_id, txn_type, address, amount,
+		from_nullifier FROM txns WHERE commitment = ?`, commitment)
+}
+
+// scanTxn returns the txn of the query
+func (s *SQLiteSource) scanTxn(query string, arg any) (*models.Txn, error) {
+	t := &models.Txn{}
+	err := s.db.QueryRow(query, arg).Scan(&t.LeafIndex, &t.Commitment, &t.TxnID, &t.Type,
+		&t.Address, &t.Amount, &t.FromNullifier)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
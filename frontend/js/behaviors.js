@@ -28,7 +28,10 @@ const Trim = {
      * data-full-value attribute (if it exists)
      */
     restoreAll: function (event) {
-        let form = event.target;
+        let form = event.detail.elt.closest('form');
+        if (!form) {
+            return;
+        }
         let formData = event.detail.parameters;
         for (let input of form.elements) {
             if (input.dataset.fullValue) {
@@ -77,6 +80,49 @@ const Show = {
         else {
             f();
         }
+    },
+
+    /**
+     * Show the error box `elem` with the width of the form above it
+     */
+    errorBox: function (elem) {
+        let baseElement = document.querySelector('form').parentElement;
+        elem.style.width = window.getComputedStyle(baseElement).width;
+        elem.style.display = '';
+    },
+
+    /**
+     * Hide the error box and scroll to the spinner, on submitting a form
+     */
+    submitting: function () {
+        let errorBox = document.querySelector('#errorBox');
+        if (errorBox) {
+            errorBox.style.display = 'none';
+        }
+        Show.scrollTo('#spinner');
+    },
+
+    /**
+     * Open the modals in `elem`, just loaded
+     */
+    modals: function (elem) {
+        let dialogs = Array.from(elem.querySelectorAll('dialog[data-modal]'));
+        if (elem.matches('dialog[data-modal]')) {
+            dialogs.push(elem);
+        }
+        for (let dialog of dialogs) {
+            if (!dialog.open) {
+                dialog.showModal();
+            }
+        }
+    },
+
+    /**
+     * Copy `text` to the clipboard, showing a tooltip on `elem`
+     */
+    copy: function (elem, text) {
+        navigator.clipboard.writeText(text);
+        Show.fadingTooltip(elem, 'copied !');
     }
 }
 
@@ -94,10 +140,252 @@ const Style = {
     }
 }
 
+const QR = {
+    /**
+     * Return true if the browser can read QR codes, with its BarcodeDetector
+     */
+    supported: async function () {
+        if (!('BarcodeDetector' in window)) {
+            return false;
+        }
+        try {
+            const formats = await BarcodeDetector.getSupportedFormats();
+            return formats.includes('qr_code');
+        } catch (error) {
+            return false;
+        }
+    },
+
+    /**
+     * Hide the QR code imports in `elem`, just loaded, if the browser cannot read
+     * QR codes, rather than offering a button that can only fail
+     */
+    hideUnsupported: async function (elem) {
+        const inputs = Array.from(elem.querySelectorAll('[data-qr-import]'));
+        if (!inputs.length || await QR.supported()) {
+            return;
+        }
+        for (let input of inputs) {
+            input.parentElement.hidden = true;
+        }
+    },
+
+    /**
+     * Read a secret note from the QR code in the image selected in `fileInput`
+     * and set it as the value of the input element defined by `selector`.
+     * It uses the browser BarcodeDetector, the import is hidden where unavailable
+     */
+    importNote: async function (fileInput, selector) {
+        const file = fileInput.files[0];
+        const button = fileInput.nextElementSibling;
+        fileInput.value = '';
+        if (!file) {
+            return;
+        }
+        if (!('BarcodeDetector' in window)) {
+            Show.fadingTooltip(button, 'QR codes not supported by this browser', 2000);
+            return;
+        }
+        try {
+            const detector = new BarcodeDetector({ formats: ['qr_code'] });
+            const bitmap = await createImageBitmap(file);
+            const codes = await detector.detect(bitmap);
+            if (!codes.length) {
+                Show.fadingTooltip(button, 'No QR code found', 2000);
+                return;
+            }
+            const elem = document.querySelector(selector);
+            elem.value = codes[0].rawValue.trim().toLowerCase();
+            elem.dispatchEvent(new Event('change'));
+            // Trigger the blur event to trim the note in the UI
+            elem.dispatchEvent(new Event('blur'));
+        } catch (error) {
+            console.log(error);
+            Show.fadingTooltip(button, 'Could not read the QR code', 2000);
+        }
+    }
+}
+
+const Confirm = {
+    /**
+     * Check the box confirming the new secret note was saved, enabling the
+     * confirm button if the note was also pasted back
+     */
+    check: function (elem) {
+        if (elem.dataset.checked) {
+            return;
+        }
+        let box = elem.parentElement;
+        box.classList.remove('bad');
+        box.classList.add('ok');
+        elem.dataset.checked = 'true';
+        elem.classList.add('checked');
+        elem.style.cursor = 'default';
+        if (document.querySelector('#confirmNote').readOnly) {
+            document.querySelector('#confirmButton').disabled = false;
+        }
+    },
+
+    /**
+     * Validate the note pasted back in `elem` against its data-expected-note,
+     * enabling the confirm button if the box was also checked
+     */
+    validateNote: function (elem) {
+        if (elem.readOnly) {
+            return;
+        }
+        if (elem.value.trim() !== elem.dataset.expectedNote) {
+            elem.value = '';
+            elem.placeholder = 'The note you pasted does not match the new secret note';
+            return;
+        }
+        elem.classList.remove('bad');
+        elem.classList.add('ok');
+        elem.classList.add('<small>');
+        elem.readOnly = true;
+        if (document.querySelector('#confirmCheckbox').dataset.checked) {
+            document.querySelector('#confirmButton').disabled = false;
+        }
+    },
+}
+
+const Csrf = {
+    /**
+     * Return the CSRF token of the page
+     */
+    token: function () {
+        let meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : '';
+    },
+
+    /**
+     * Send the CSRF token with the htmx requests
+     */
+    header: function (event) {
+        event.detail.headers['X-CSRF-Token'] = Csrf.token();
+    },
+
+    /**
+     * Add the CSRF token to the plain form posts, not made by htmx
+     */
+    field: function (event) {
+        let form = event.target;
+        if (form.method !== 'post' || form.querySelector('[name="csrf_token"]')) {
+            return;
+        }
+        let input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = 'csrf_token';
+        input.value = Csrf.token();
+        form.appendChild(input);
+    },
+}
+
+const Jobs = {
+    key: 'hermesvault-job',
+
+    /**
+     * Remember the deposit or withdrawal job shown in `elem` while it runs, and
+     * forget it once it is done
+     */
+    track: function (elem) {
+        let panel = elem.closest ? elem.closest('[data-job]') : null;
+        if (elem.querySelector && !panel) {
+            panel = elem.querySelector('[data-job]');
+        }
+        if (!panel) {
+            return;
+        }
+        if (panel.querySelector('[data-job-done]')) {
+            sessionStorage.removeItem(Jobs.key);
+        } else {
+            sessionStorage.setItem(Jobs.key, panel.dataset.job);
+        }
+    },
+
+    /**
+     * Load the job still running, if any, in place of the ui on page load so
+     * that a refresh resumes watching it
+     */
+    resume: function (event) {
+        const id = sessionStorage.getItem(Jobs.key);
+        if (id && event.detail.elt.id === 'ui') {
+            sessionStorage.removeItem(Jobs.key);
+            event.detail.path = 'jobs/' + id;
+        }
+    },
+}
+
+document.addEventListener('htmx:load', (event) => Jobs.track(event.detail.elt));
+document.addEventListener('htmx:configRequest', Jobs.resume);
+
+// The Content-Security-Policy forbids inline event handlers, the elements declare
+// their behavior with data attributes handled here
+document.addEventListener('htmx:configRequest', Trim.restoreAll);
+document.addEventListener('htmx:configRequest', Csrf.header);
+document.addEventListener('submit', Csrf.field);
+document.addEventListener('htmx:load', (event) => Show.modals(event.detail.elt));
+document.addEventListener('htmx:load', (event) => QR.hideUnsupported(event.detail.elt));
+document.addEventListener('htmx:after-swap', (event) => {
+    if (event.target.id === 'errorBox') {
+        Show.errorBox(event.target);
+    }
+});
+document.addEventListener('focusin', (event) => {
+    if (event.target.matches('[data-trim]')) {
+        Trim.restore(event.target);
+    }
+});
+document.addEventListener('focusout', (event) => {
+    let elem = event.target;
+    if (elem.matches('[data-trim]')) {
+        Trim.trim(elem);
+    }
+    if (elem.matches('[data-expected-note]') && elem.value) {
+        Confirm.validateNote(elem);
+    }
+});
+document.addEventListener('paste', (event) => {
+    let elem = event.target;
+    if (elem.matches('[data-expected-note]')) {
+        setTimeout(() => { Confirm.validateNote(elem) }, 0);
+    }
+});
+document.addEventListener('change', (event) => {
+    let elem = event.target;
+    if (elem.matches('[data-qr-import]')) {
+        QR.importNote(elem, elem.dataset.qrImport);
+    }
+});
+document.addEventListener('click', (event) => {
+    let elem = event.target.closest('[data-submit], [data-click], [data-copy], ' +
+        '[data-fill], [data-confirm-checkbox], [data-close-modal]');
+    if (!elem) {
+        return;
+    }
+    if (elem.matches('[data-submit]')) {
+        Show.submitting();
+    } else if (elem.matches('[data-click]')) {
+        document.querySelector(elem.dataset.click).click();
+    } else if (elem.matches('[data-copy]')) {
+        Show.copy(elem, elem.dataset.copy);
+    } else if (elem.matches('[data-fill]')) {
+        document.querySelector(elem.dataset.fill).value = elem.dataset.value;
+    } else if (elem.matches('[data-confirm-checkbox]')) {
+        Confirm.check(elem);
+    } else if (elem.matches('[data-close-modal]')) {
+        elem.closest('dialog').close();
+    }
+});
+
 const behaviors = {
     Trim: Trim,
     Show: Show,
     Style: Style,
+    QR: QR,
+    Confirm: Confirm,
+    Csrf: Csrf,
+    Jobs: Jobs,
 };
 
 window.behaviors = behaviors;
\ No newline at end of file
@@ -1,3 +1,7 @@
 import htmx from "htmx.org";
 import "htmx-ext-response-targets";
-window.htmx = htmx;
\ No newline at end of file
+import "htmx-ext-sse";
+window.htmx = htmx;
+// the Content-Security-Policy allows no inline scripts nor eval
+htmx.config.allowEval = false;
+htmx.config.allowScriptTags = false;
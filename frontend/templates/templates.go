@@ -3,6 +3,9 @@ package templates
 import (
 	"fmt"
 	"html/template"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/models"
 )
 
 var (
@@ -11,8 +14,19 @@ var (
 	Withdraw          *template.Template
 	ConfirmDeposit    *template.Template
 	ConfirmWithdrawal *template.Template
+	NoteBackup        *template.Template
+	Stats             *template.Template
+	JobProgress       *template.Template
+	JobStatus         *template.Template
 )
 
+// Page is the data of the head of a full page: the CSP nonce of its scripts and
+// the CSRF token its requests send back, if it makes any
+type Page struct {
+	Nonce     string
+	CSRFToken string
+}
+
 func InitTemplates() {
 	// Helper function to create a map for passing multiple values to templates
 	funcMap := template.FuncMap{
@@ -33,15 +47,25 @@ func InitTemplates() {
 		"safeHTMLAttr": func(s string) template.HTMLAttr {
 			return template.HTMLAttr(s)
 		},
+		// The live protocol parameters and a helper to show microalgos as algos
+		"protocolParams": avm.GetProtocolParams,
+		"algos":          models.MicroAlgosToAlgoString,
 	}
 	tmpl := template.Must(template.New("main").Funcs(funcMap).ParseFiles(
 		"frontend/templates/main.html",
 		"frontend/templates/confirm_deposit.html",
 		"frontend/templates/confirm_withdrawal.html",
+		"frontend/templates/note_backup.svg",
+		"frontend/templates/stats.html",
+		"frontend/templates/jobs.html",
 	))
 	Main = tmpl.Lookup("main")
 	Deposit = tmpl.Lookup("depositForm")
 	Withdraw = tmpl.Lookup("withdrawForm")
 	ConfirmWithdrawal = tmpl.Lookup("confirmWithdrawal")
 	ConfirmDeposit = tmpl.Lookup("confirmDeposit")
+	NoteBackup = tmpl.Lookup("noteBackup")
+	Stats = tmpl.Lookup("stats")
+	JobProgress = tmpl.Lookup("jobProgress")
+	JobStatus = tmpl.Lookup("jobStatus")
 }
@@ -7,7 +7,9 @@ require (
 	github.com/consensys/gnark v0.12.0
 	github.com/consensys/gnark-crypto v0.15.0
 	github.com/giuliop/algoplonk v0.1.8
+	github.com/lib/pq v1.12.3
 	github.com/mattn/go-sqlite3 v1.14.24
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+)
+
+// AssociationSetsHandler publishes the current association set roots as JSON,
+// so that users and the contract manager can check which roots are in use
+func AssociationSetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sets, err := avm.PublishAssociationSets()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error building association sets", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sets); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding association sets", "error", err)
+	}
+}
@@ -1,16 +1,12 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
-	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
-	"github.com/giuliop/HermesVault-frontend/memstore"
-	"github.com/giuliop/HermesVault-frontend/models"
-
-	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/giuliop/HermesVault-frontend/service"
 )
 
 func DepositHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,67 +14,24 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		w.Header().Set("Cache-Control", config.CacheControl)
 		if err := templates.Deposit.Execute(w, nil); err != nil {
-			log.Printf("Error executing deposit template: %v", err)
+			slog.ErrorContext(r.Context(), "Error executing deposit template", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 	case http.MethodPost:
 		if err := r.ParseForm(); err != nil {
-			log.Printf("Error parsing form: %v", err)
+			slog.InfoContext(r.Context(), "Error parsing form", "error", err)
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
-		amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
-		address, errAddress := models.Input(r.FormValue("address")).ToAddress()
-		errorMsg := ""
-		if errAmount != nil {
-			log.Printf("Error parsing deposit amount: %v", errAmount)
-			errorMsg += "Invalid algo amount<br>"
-		}
-		if errAddress != nil {
-			log.Printf("Error parsing deposit address: %v", errAddress)
-			errorMsg += "Invalid Algorand address<br>"
-		}
-		if errorMsg != "" {
-			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
-			return
-		}
-
-		note, err := models.GenerateNote(amount.Microalgos)
-		if err != nil {
-			log.Printf("Error generating new note: %v", err)
-			http.Error(w, "Something went wrong. Please try again",
-				http.StatusInternalServerError)
-			return
-		}
-
-		txns, err := avm.CreateDepositTxns(amount, address, note)
+		depositData, err := service.PrepareDeposit(r.Context(), r.FormValue("amount"),
+			r.FormValue("address"), seedInput(r))
 		if err != nil {
-			log.Printf("Error creating deposit transactions: %v", err)
-			http.Error(w, "Something went wrong. Please try again",
-				http.StatusInternalServerError)
+			msg, status := errorHTML(err)
+			http.Error(w, msg, status)
 			return
 		}
-		note.TxnID = crypto.GetTxID(txns[0])
-
-		depositData := models.DepositData{
-			Amount:         amount,
-			Address:        address,
-			Note:           note,
-			Txns:           txns,
-			IndexTxnToSign: config.UserDepositTxnIndex,
-		}
-
-		ms := memstore.UserSessions
-		_, err = ms.StoreDeposit(&depositData)
-		if err != nil {
-			log.Printf("Error storing deposit: %v", err)
-			http.Error(w, "Something went wrong. Please try again",
-				http.StatusInternalServerError)
-			return
-		}
-
-		if err := templates.ConfirmDeposit.Execute(w, &depositData); err != nil {
-			log.Printf("Error executing success template: %v", err)
+		if err := templates.ConfirmDeposit.Execute(w, depositData); err != nil {
+			slog.ErrorContext(r.Context(), "Error executing success template", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 
@@ -86,3 +39,8 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// seedInput returns the optional seed fields of a deposit or withdrawal form
+func seedInput(r *http.Request) service.SeedInput {
+	return service.SeedInput{Mnemonic: r.FormValue("seed"), Counter: r.FormValue("counter")}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// health is the response of the health check
+type health struct {
+	Status    string               `json:"status"` // ok or degraded
+	Indexer   models.IndexerStatus `json:"indexer"`
+	LagRounds uint64               `json:"indexer_lag_rounds"`
+
+	Reconciliation db.ReconciliationMetrics `json:"reconciliation"`
+}
+
+// HealthHandler reports the server health as JSON, with status 503 when the
+// subscriber service is too far behind or cannot be checked
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	status := avm.GetIndexerStatus()
+	h := health{Status: "ok", Indexer: status, LagRounds: status.Lag(),
+		Reconciliation: db.GetReconciliationMetrics()}
+	code := http.StatusOK
+	if status.Stale(avm.IndexerLimits) {
+		h.Status = "degraded"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding health", "error", err)
+	}
+}
+
+// IndexerStatusHandler returns a warning banner when the subscriber service is
+// behind, or nothing
+func IndexerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	status := avm.GetIndexerStatus()
+	switch {
+	case status.Stale(avm.IndexerLimits) && status.Lag() <= avm.IndexerLimits.MaxRounds:
+		w.Write([]byte(`<div class="box bad">The indexer status cannot be checked:
+			withdrawals are paused until it can.</div>`))
+	case status.Stale(avm.IndexerLimits):
+		w.Write([]byte(`<div class="box bad">The indexer is catching up with the
+			blockchain: your deposit may not be visible yet and withdrawals are paused
+			until it does.</div>`))
+	case status.Behind(avm.IndexerLimits):
+		w.Write([]byte(`<div class="box warn">The indexer is catching up with the
+			blockchain: your deposit may not be visible yet.</div>`))
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/jobs"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// sseKeepAlive is the interval between the comments keeping an idle event stream
+// open through the proxies
+const sseKeepAlive = 15 * time.Second
+
+// jobStep is a step of a job as shown to the user
+type jobStep struct {
+	Label  string
+	Detail string
+	State  string // done, current or pending
+}
+
+// jobStatus is the data of the jobStatus template
+type jobStatus struct {
+	Steps []jobStep
+	Done  bool
+	Modal template.HTML // the final modal, once done
+}
+
+// jobView is the data of the jobProgress template
+type jobView struct {
+	Id     string
+	Kind   jobs.Kind
+	Status jobStatus
+}
+
+// the steps of each kind of job, in order
+var jobSteps = map[jobs.Kind][]struct {
+	stage models.JobStage
+	label string
+}{
+	jobs.Deposit: {
+		{models.JobQueued, "Deposit queued"},
+		{models.JobSubmitted, "Submitted to the network"},
+		{models.JobConfirmed, "Confirmed"},
+	},
+	jobs.Withdrawal: {
+		{models.JobQueued, "Withdrawal queued"},
+		{models.JobBuildingProof, "Building the merkle tree proof"},
+		{models.JobProving, "Generating the zero knowledge proof"},
+		{models.JobSubmitted, "Submitted to the network"},
+		{models.JobConfirmed, "Confirmed"},
+	},
+}
+
+// startJob runs f as a job of the given kind and renders its progress panel in
+// place of the ui, or the failure modal if the job cannot start. f gets the
+// context of the request, without its cancellation since the job outlives it
+func startJob(w http.ResponseWriter, r *http.Request, kind jobs.Kind,
+	f func(ctx context.Context, progress service.Progress) (models.JobEvent, error)) {
+	ctx := context.WithoutCancel(r.Context())
+	job, err := jobs.UserJobs.Start(kind, func(report func(models.JobEvent)) models.JobEvent {
+		final, err := f(ctx, report)
+		if err != nil {
+			e := service.AsError(err)
+			return models.JobEvent{Stage: models.JobFailed, Error: e.Message,
+				Status: e.Status}
+		}
+		return final
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting job", "kind", kind, "error", err)
+		msg := "Something went wrong, please try again"
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrStoreFull) {
+			msg = "Too many requests in progress. Please try again in a few minutes"
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, failedModal(kind, msg), status)
+		return
+	}
+	slog.InfoContext(ctx, "Started job", "kind", kind, "job", job.Id)
+	w.Header().Set("HX-Retarget", "#ui")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	renderJob(w, r, job)
+}
+
+// JobHandler renders the progress panel of a job, for a client resuming watching
+// it after a refresh. An expired job renders the deposit form
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	job, err := jobs.UserJobs.Get(r.PathValue("id"))
+	if err != nil {
+		if err := templates.Deposit.Execute(w, nil); err != nil {
+			slog.ErrorContext(r.Context(), "Error executing deposit template", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	renderJob(w, r, job)
+}
+
+// renderJob renders the progress panel of the job
+func renderJob(w http.ResponseWriter, r *http.Request, job *jobs.Job) {
+	past, _, stop := job.Watch()
+	stop()
+	view := jobView{Id: job.Id, Kind: job.Kind}
+	if len(past) > 0 {
+		view.Status = newJobStatus(job.Kind, past)
+	}
+	if err := templates.JobProgress.Execute(w, view); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing job progress template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// JobEventsHandler streams the progress of a job as Server-Sent Events. Each
+// progress event carries the job status HTML and the sequence number of the job
+// event as its id, so that a reconnecting client resumes after the last event it
+// got. A done event is sent once the job has finished
+func JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, err := jobs.UserJobs.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	after, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	// the stream lasts as long as the job, past the server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.ErrorContext(r.Context(), "Error clearing the job events write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // do not let nginx buffer the stream
+
+	// the status shows all the events up to the current one
+	events, next, stop := job.Watch()
+	defer stop()
+	for i := max(after, 0); i < len(events); i++ {
+		if err := writeJobEvent(w, r, job.Kind, events[:i+1]); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-next:
+			if !ok {
+				fmt.Fprint(w, "event: done\ndata: \n\n")
+				flusher.Flush()
+				return
+			}
+			events = append(events, e)
+			if err := writeJobEvent(w, r, job.Kind, events); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeJobEvent writes the status of the job after the last of the events as a
+// progress event
+func writeJobEvent(w http.ResponseWriter, r *http.Request, kind jobs.Kind,
+	events []models.JobEvent) error {
+	var buf bytes.Buffer
+	if err := templates.JobStatus.Execute(&buf, newJobStatus(kind, events)); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing job status template", "error", err)
+		return err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "id: %d\nevent: progress\n", events[len(events)-1].Seq)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+	_, err := fmt.Fprint(w, sb.String())
+	return err
+}
+
+// newJobStatus returns the status of a job with the given events, the last one
+// being the current stage
+func newJobStatus(kind jobs.Kind, events []models.JobEvent) jobStatus {
+	var status jobStatus
+	last := events[len(events)-1]
+	reached := map[models.JobStage]models.JobEvent{}
+	for _, e := range events {
+		reached[e.Stage] = e
+	}
+	for _, s := range jobSteps[kind] {
+		step := jobStep{Label: s.label, State: "pending"}
+		if e, ok := reached[s.stage]; ok {
+			step.State = "done"
+			switch s.stage {
+			case models.JobSubmitted:
+				step.Detail = "txn " + e.TxnID
+			case models.JobConfirmed:
+				step.Detail = fmt.Sprintf("round %d, leaf %d", e.Round, e.LeafIndex)
+			}
+		}
+		if s.stage == last.Stage && !last.Stage.Final() {
+			step.State = "current"
+		}
+		status.Steps = append(status.Steps, step)
+	}
+	switch last.Stage {
+	case models.JobConfirmed:
+		status.Done = true
+		status.Modal = template.HTML(successModal(kind))
+	case models.JobFailed:
+		status.Done = true
+		status.Modal = template.HTML(failedModal(kind, messageHTML(last.Error)))
+	}
+	return status
+}
+
+// successModal returns the modal of a successful job
+func successModal(kind jobs.Kind) string {
+	if kind == jobs.Withdrawal {
+		return modalWithdrawalSuccessful()
+	}
+	return modalDepositSuccessful()
+}
+
+// failedModal returns the modal of a failed job with the HTML message
+func failedModal(kind jobs.Kind, message string) string {
+	if kind == jobs.Withdrawal {
+		return modalWithdrawalFailed(message)
+	}
+	return modalDepositFailed(message)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/security"
+)
+
+// MainHandler renders the main page. It is not cached since it holds the CSP nonce
+// and the CSRF token of the client
+func MainHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := templates.Main.Execute(w, page(r)); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing main template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// page returns the data of the head of a full page
+func page(r *http.Request) templates.Page {
+	return templates.Page{Nonce: security.Nonce(r), CSRFToken: security.CSRFToken(r)}
+}
+
+// CSRFFailed writes the response to a request failing the CSRF check, as a modal
+// for the htmx requests
+func CSRFFailed(w http.ResponseWriter, r *http.Request) {
+	msg := "Your session has expired.\nPlease reload the page and try again."
+	if r.Header.Get("HX-Request") != "true" {
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, modal("&#10060; Request refused", messageHTML(msg), ""))
+}
@@ -0,0 +1,19 @@
+package handlers
+
+// modal returns a modal dialog with the HTML title and message, opened once loaded
+// by the page behaviors. Its close button loads closeGet in the ui, if not empty
+func modal(title, message, closeGet string) string {
+	get := ""
+	if closeGet != "" {
+		get = ` hx-get="` + closeGet + `"`
+	}
+	return `<dialog class="modal" data-modal>
+			    <h1>` + title + `</h1>
+				<p>
+				` + message + `
+				</p>
+				<button` + get + ` data-close-modal>
+				  Close
+				</button>
+			</dialog>`
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/service"
+
+	"rsc.io/qr"
+)
+
+// layout of the note backup, in mm
+const (
+	backupQRSize         = 100 // side of the QR code
+	backupNoteLineLength = 36  // note characters per line
+	backupNoteFirstLineY = 200 // y of the first note line
+	backupNoteLineHeight = 8
+	qrQuietZone          = 4 // white modules around the QR code
+)
+
+type noteBackup struct {
+	Amount    string
+	Network   string
+	AppId     uint64
+	Date      string
+	NoteLines []noteBackupLine
+	QRPath    string  // svg path of the black QR modules
+	QRSize    int     // QR modules per side, including the quiet zone
+	QRScale   float64 // scale from QR modules to mm
+}
+
+type noteBackupLine struct {
+	Y    int
+	Text string
+}
+
+// NoteBackupHandler renders a printable SVG backup of the posted note, with a QR
+// code that can be imported back in the withdraw form
+func NoteBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		slog.InfoContext(r.Context(), "Error parsing form", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	note, err := models.Input(r.FormValue("note")).ToNote()
+	if err != nil {
+		slog.InfoContext(r.Context(), "Error parsing backup note", "error", err)
+		http.Error(w, service.NoteErrorMessage(err), http.StatusUnprocessableEntity)
+		return
+	}
+	text := note.Text()
+	// QR codes encode upper case text more compactly
+	code, err := qr.Encode(strings.ToUpper(text), qr.M)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding note QR code", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	binding := models.GetNoteBinding()
+	backup := noteBackup{
+		Amount:  models.MicroAlgosToAlgoString(note.Amount),
+		Network: binding.Network.String(),
+		AppId:   binding.AppId,
+		Date:    time.Now().UTC().Format("2006-01-02"),
+		QRPath:  qrPath(code),
+		QRSize:  code.Size + 2*qrQuietZone,
+		QRScale: float64(backupQRSize) / float64(code.Size+2*qrQuietZone),
+	}
+	for i := 0; i < len(text); i += backupNoteLineLength {
+		end := min(i+backupNoteLineLength, len(text))
+		backup.NoteLines = append(backup.NoteLines, noteBackupLine{
+			Y:    backupNoteFirstLineY + len(backup.NoteLines)*backupNoteLineHeight,
+			Text: text[i:end],
+		})
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `inline; filename="hermesvault-note.svg"`)
+	if err := templates.NoteBackup.Execute(w, &backup); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing note backup template", "error", err)
+	}
+}
+
+// qrPath returns an svg path drawing the black modules of the QR code, offset by
+// the quiet zone
+func qrPath(code *qr.Code) string {
+	var sb strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"html"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// errorHTML returns the message of a service error as HTML to show the user, and
+// the HTTP status to return
+func errorHTML(err error) (string, int) {
+	e := service.AsError(err)
+	return messageHTML(e.Message), e.Status
+}
+
+// messageHTML returns a message to show the user as HTML
+func messageHTML(message string) string {
+	return strings.ReplaceAll(html.EscapeString(message), "\n", "<br>")
+}
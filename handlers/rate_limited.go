@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/service"
+)
+
+// RateLimited writes the response to a rate limited request, as a modal for the
+// htmx requests
+func RateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	err := service.RateLimitedError(retryAfter)
+	if r.Header.Get("HX-Request") != "true" {
+		http.Error(w, err.Message, err.Status)
+		return
+	}
+	msg, status := errorHTML(err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, modal("&#9203; Slow down", msg, ""))
+}
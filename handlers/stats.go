@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/security"
+)
+
+// statsCacheControl lets the stats be cached until their next refresh
+var statsCacheControl = fmt.Sprintf("public, max-age=%d",
+	int(config.StatsRefreshInterval.Seconds()))
+
+// statsPageCacheControl lets only the client cache the stats page, which holds the
+// CSP nonce of its scripts
+var statsPageCacheControl = fmt.Sprintf("private, max-age=%d",
+	int(config.StatsRefreshInterval.Seconds()))
+
+// statsPage is the data of the stats template
+type statsPage struct {
+	Page templates.Page
+	models.ProtocolStats
+}
+
+// StatsHandler renders the protocol statistics page
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", statsPageCacheControl)
+	data := statsPage{
+		Page:          templates.Page{Nonce: security.Nonce(r)},
+		ProtocolStats: models.GetProtocolStats(),
+	}
+	if err := templates.Stats.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing stats template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// StatsJSONHandler publishes the protocol statistics as JSON
+func StatsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", statsCacheControl)
+	if err := json.NewEncoder(w).Encode(models.GetProtocolStats()); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding stats", "error", err)
+	}
+}
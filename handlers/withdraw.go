@@ -1,14 +1,13 @@
 package handlers
 
 import (
-	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 
 	"github.com/giuliop/HermesVault-frontend/config"
-	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
-	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/service"
 )
 
 func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
@@ -16,65 +15,58 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		w.Header().Set("Cache-Control", config.CacheControl)
 		if err := templates.Withdraw.Execute(w, nil); err != nil {
-			log.Printf("Error executing withdraw template: %v", err)
+			slog.ErrorContext(r.Context(), "Error executing withdraw template", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 	case http.MethodPost:
 		if err := r.ParseForm(); err != nil {
-			log.Printf("Error parsing form: %v", err)
+			slog.InfoContext(r.Context(), "Error parsing form", "error", err)
 			http.Error(w, "Bad Request", http.StatusBadRequest)
-		}
-		amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
-		address, errAddress := models.Input(r.FormValue("address")).ToAddress()
-		note, errNote := models.Input(r.FormValue("note")).ToNote()
-		errorMsg := ""
-		if errAmount != nil {
-			log.Printf("Error parsing withdrawal amount: %v", errAmount)
-			errorMsg += "Invalid algo amount<br>"
-		}
-		if errAddress != nil {
-			log.Printf("Error parsing withdrawal address: %v", errAddress)
-			errorMsg += "Invalid Algorand address<br>"
-		}
-		if errNote != nil {
-			log.Printf("Error parsing withdrawal note: %v", errNote)
-			errorMsg += "The note you provided is not valid"
-		}
-		if errorMsg != "" {
-			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
 			return
 		}
-		withdrawData := &models.WithdrawalData{
-			Amount:     amount,
-			Fee:        amount.Fee(),
-			Address:    address,
-			FromNote:   note,
-			ChangeNote: nil,
+		withdrawData, err := service.PrepareWithdrawal(r.Context(), r.FormValue("amount"),
+			r.FormValue("address"), r.FormValue("note"), seedInput(r))
+		if err != nil {
+			msg, status := errorHTML(err)
+			http.Error(w, msg, status)
+			return
 		}
-		var err error
-		withdrawData.FromNote.LeafIndex, err = db.GetLeafIndexByCommitment(
-			withdrawData.FromNote.Commitment())
-		switch err {
-		case nil:
-			changeNote, err := models.GenerateChangeNote(amount, note)
-			if err != nil {
-				log.Printf("Error generating new note: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-			withdrawData.ChangeNote = changeNote
-			if err := templates.ConfirmWithdrawal.Execute(w, &withdrawData); err != nil {
-				log.Printf("Error executing success template: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			}
-		case sql.ErrNoRows:
-			errorMsg = "The note you provided is not valid<br>"
-			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
-		default:
-			errorMsg = "<b>Something went wrong.</b><br>Please try again."
-			http.Error(w, errorMsg, http.StatusInternalServerError)
+		if err := templates.ConfirmWithdrawal.Execute(w, withdrawData); err != nil {
+			slog.ErrorContext(r.Context(), "Error executing success template", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// WithdrawQuoteHandler returns how much can be withdrawn from the note in the
+// form, or nothing if the note is not valid
+func WithdrawQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		slog.InfoContext(r.Context(), "Error parsing form", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	quote, err := service.QuoteWithdrawal(r.FormValue("note"))
+	if err != nil {
+		return
+	}
+	if quote.MaxWithdrawable.Microalgos == 0 {
+		fmt.Fprintf(w, `<small>This note holds %s algo, not enough to pay the withdrawal fee</small>`,
+			quote.Balance.Algostring)
+		return
+	}
+	fmt.Fprintf(w, `<small>This note holds %s algo: you can withdraw up to %s algo
+		(fee %s algo)</small>
+		<button type="button" class="wide"
+				data-fill="#withdrawAmount" data-value="%s">
+			Withdraw the maximum
+		</button>`,
+		quote.Balance.Algostring, quote.MaxWithdrawable.Algostring,
+		quote.MaxFee.Algostring, quote.MaxWithdrawable.Algostring)
+}
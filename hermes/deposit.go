@@ -0,0 +1,64 @@
+package hermes
+
+import (
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/protocol"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// PrepareDeposit generates the note of the deposit, with random nonces or derived
+// from seed if not nil, and builds its txn group.
+// The txn at d.IndexTxnToSign must be signed by address, and the deposit sent
+// with SubmitDeposit. A random note is lost if not kept before submitting
+func (c *Client) PrepareDeposit(amount models.Amount, address models.Address,
+	seed *models.NoteSeed) (*models.DepositData, error) {
+	params, err := c.Params()
+	if err != nil {
+		return nil, err
+	}
+	if amount.Microalgos < params.DepositMinimumAmount {
+		return nil, fmt.Errorf("the minimum deposit is %s algo",
+			models.MicroAlgosToAlgoString(params.DepositMinimumAmount))
+	}
+	note, err := models.NewNote(amount.Microalgos, seed)
+	if err != nil {
+		return nil, fmt.Errorf("error generating new note: %v", err)
+	}
+	txns, err := protocol.DepositTxns(c.algod, c.app, amount, address, note)
+	if err != nil {
+		return nil, fmt.Errorf("error creating deposit transactions: %v", err)
+	}
+	note.TxnID = crypto.GetTxID(txns[0])
+	return &models.DepositData{
+		Amount:         amount,
+		Address:        address,
+		Note:           note,
+		Txns:           txns,
+		IndexTxnToSign: constants.UserDepositTxnIndex,
+	}, nil
+}
+
+// SubmitDeposit sends the prepared deposit with the txn signed by the user to the
+// network and waits for its confirmation. It returns the deposit note with its
+// leaf index set. The error is a *protocol.TxnConfirmationError if the deposit
+// was sent but not confirmed
+func (c *Client) SubmitDeposit(d *models.DepositData, signedTxn *types.SignedTxn,
+) (*models.Note, error) {
+	err := protocol.ValidateUserSignedTxn(c.algod, signedTxn, d.Txns, d.IndexTxnToSign)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed deposit transaction: %w", err)
+	}
+	confirmation, confirmationError := protocol.SendDeposit(c.algod, c.app, d.Txns,
+		msgpack.Encode(signedTxn), nil)
+	if confirmationError != nil {
+		return nil, confirmationError
+	}
+	d.Note.LeafIndex = int(confirmation.LeafIndex)
+	return d.Note, nil
+}
@@ -0,0 +1,76 @@
+// Package hermes lets Go programs make HermesVault deposits and withdrawals.
+// Unlike the packages of the frontend it has no global state and reads no
+// settings: a Client is built from an explicit Config and reads the protocol
+// parameters from the app, so that bots and backends can embed the protocol.
+// Importing it never reads the config package env file
+package hermes
+
+import (
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/protocol"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+)
+
+// Config is the configuration of a Client
+type Config struct {
+	Algod    *algod.Client // client of a node of the network the app is deployed on
+	SetupDir string        // directory of the app setup files, e.g. avm/testnet
+	Network  string        // mainnet, testnet or devnet, to bind the notes to
+	Tree     TreeSource    // leaves of the merkle tree, needed for withdrawals
+}
+
+// Client makes deposits and withdrawals with the app of its Config
+type Client struct {
+	algod   *algod.Client
+	app     *models.App
+	binding models.NoteBinding
+	tree    TreeSource
+}
+
+// New returns a client for the app in cfg.SetupDir
+func New(cfg Config) (*Client, error) {
+	if cfg.Algod == nil {
+		return nil, fmt.Errorf("no algod client")
+	}
+	if cfg.Tree == nil {
+		return nil, fmt.Errorf("no tree source")
+	}
+	network, err := models.ParseNetwork(cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+	app, err := protocol.LoadApp(cfg.SetupDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load app: %v", err)
+	}
+	return &Client{
+		algod:   cfg.Algod,
+		app:     app,
+		binding: models.NoteBinding{Network: network, AppId: app.Id},
+		tree:    cfg.Tree,
+	}, nil
+}
+
+// AppId returns the id of the app
+func (c *Client) AppId() uint64 {
+	return c.app.Id
+}
+
+// Params reads the protocol parameters from the app global state
+func (c *Client) Params() (models.ProtocolParams, error) {
+	return protocol.ReadProtocolParams(c.algod, c.app)
+}
+
+// EncodeNote encodes the note for the network and app of the client
+func (c *Client) EncodeNote(n *models.Note) string {
+	return models.EncodeNote(n, c.binding)
+}
+
+// DecodeNote decodes a note in either the current or the legacy format. It returns
+// an error if a current note is bound to another network or app
+func (c *Client) DecodeNote(s string) (*models.Note, error) {
+	return models.ParseNoteFor(s, c.binding)
+}
@@ -0,0 +1,21 @@
+package hermes
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestNoConfigDependency checks that importing hermes does not import the config
+// package, whose init reads the env file of the frontend and can exit
+func TestNoConfigDependency(t *testing.T) {
+	out, err := exec.Command("go", "list", "-deps", ".").Output()
+	if err != nil {
+		t.Skipf("go list unavailable: %v", err)
+	}
+	for _, dep := range strings.Fields(string(out)) {
+		if dep == "github.com/giuliop/HermesVault-frontend/config" {
+			t.Errorf("hermes depends on %s", dep)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/recovery"
+)
+
+// NoteState is the state of a note on chain
+type NoteState string
+
+const (
+	// NotePending is a note not in the merkle tree, its txn is not confirmed yet or
+	// was never sent
+	NotePending NoteState = "pending"
+	// NoteUnspent is a note in the merkle tree that can be withdrawn from
+	NoteUnspent NoteState = "unspent"
+	// NoteSpent is a note already withdrawn from
+	NoteSpent NoteState = "spent"
+)
+
+// NoteStatus is the state of a note
+type NoteStatus struct {
+	State     NoteState
+	Balance   models.Amount
+	LeafIndex int // EmptyLeafIndex if pending
+}
+
+// NoteStatus returns the state of the note. A note is in the tree when the tree
+// source has recorded its deposit or withdrawal, and spent when the app holds the
+// box of its nullifier
+func (c *Client) NoteStatus(note *models.Note) (*NoteStatus, error) {
+	status := &NoteStatus{
+		State:     NotePending,
+		Balance:   models.NewAmount(note.Amount),
+		LeafIndex: models.EmptyLeafIndex,
+	}
+	leaves, err := c.tree.Leaves()
+	if err != nil {
+		return nil, fmt.Errorf("error getting leaves: %v", err)
+	}
+	status.LeafIndex = leafIndexOf(leaves, note.Commitment())
+	if status.LeafIndex == models.EmptyLeafIndex {
+		return status, nil
+	}
+	status.State = NoteUnspent
+
+	_, err = c.algod.GetApplicationBoxByName(c.app.Id, note.Nullifier()).
+		Do(context.Background())
+	// common.NotFound is an interface alias of error, we can only match the message
+	if err != nil && strings.Contains(err.Error(), "HTTP 404") {
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting nullifier box: %v", err)
+	}
+	status.State = NoteSpent
+	return status, nil
+}
+
+// NextSeedCounter returns the first counter not used by the notes of the seed,
+// to derive the next note from. The tree source must provide the txns too, as
+// SQLiteTree does. The notes of the deposits and withdrawals not confirmed yet are
+// not seen, and the change notes are found only if their withdrawal paid the fee
+// of the compiled-in or current protocol parameters
+func (c *Client) NextSeedCounter(seed *models.Seed) (uint64, error) {
+	source, ok := c.tree.(recovery.TxnSource)
+	if !ok {
+		return 0, fmt.Errorf("the tree source does not provide the txns")
+	}
+	params, err := c.Params()
+	if err != nil {
+		return 0, err
+	}
+	result, err := recovery.Recover(seed, source,
+		[]models.ProtocolParams{models.DefaultProtocolParams, params},
+		recovery.DefaultGapLimit)
+	if err != nil {
+		return 0, err
+	}
+	return result.NextCounter, nil
+}
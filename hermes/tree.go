@@ -0,0 +1,107 @@
+package hermes
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TreeSource returns the merkle tree of the notes, as recorded by the subscriber
+// service
+type TreeSource interface {
+	// Leaves returns the leaf commitments ordered by leaf index
+	Leaves() ([][]byte, error)
+	// Root returns the current root and the number of leaves it was computed on
+	Root() (root []byte, leafCount int, err error)
+}
+
+// snapshot returns the current root of the tree and the leaves it was computed on.
+// Leaves added after the root was read are dropped
+func snapshot(t TreeSource) ([]byte, [][]byte, error) {
+	root, leafCount, err := t.Root()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting root: %v", err)
+	}
+	leaves, err := t.Leaves()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting leaves: %v", err)
+	}
+	if len(leaves) < leafCount {
+		return nil, nil, fmt.Errorf("the tree has %d leaves, the root %d", len(leaves),
+			leafCount)
+	}
+	return root, leaves[:leafCount], nil
+}
+
+// SQLiteTree is a TreeSource reading the SQLite txns database of the subscriber
+// service
+type SQLiteTree struct {
+	db *sql.DB
+}
+
+// OpenSQLiteTree opens the SQLite txns database at path in read-only mode
+func OpenSQLiteTree(path string) (*SQLiteTree, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transactions database: %w", err)
+	}
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set busy timeout on transactions database: %w",
+			err)
+	}
+	return &SQLiteTree{db: db}, nil
+}
+
+// Close closes the database
+func (t *SQLiteTree) Close() error {
+	return t.db.Close()
+}
+
+func (t *SQLiteTree) Leaves() ([][]byte, error) {
+	rows, err := t.db.Query(`SELECT commitment FROM txns ORDER BY leaf_index ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commitments [][]byte
+	for rows.Next() {
+		var commitment []byte
+		if err := rows.Scan(&commitment); err != nil {
+			return nil, err
+		}
+		commitments = append(commitments, commitment)
+	}
+	return commitments, rows.Err()
+}
+
+func (t *SQLiteTree) Root() (root []byte, leafCount int, err error) {
+	err = t.db.QueryRow(`SELECT value, leaf_count FROM roots`).Scan(&root, &leafCount)
+	return
+}
+
+// GetAllTxns returns the txns ordered by leaf index, for the recovery of the
+// notes of a seed
+func (t *SQLiteTree) GetAllTxns() ([]*models.Txn, error) {
+	rows, err := t.db.Query(`SELECT leaf_index, commitment, txn_id, txn_type, address,
+		amount, from_nullifier FROM txns ORDER BY leaf_index ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []*models.Txn
+	for rows.Next() {
+		t := &models.Txn{}
+		if err := rows.Scan(&t.LeafIndex, &t.Commitment, &t.TxnID, &t.Type, &t.Address,
+			&t.Amount, &t.FromNullifier); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
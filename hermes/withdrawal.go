@@ -0,0 +1,107 @@
+package hermes
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/protocol"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// Withdrawal is a withdrawal prepared by PrepareWithdrawal, with its txn group
+type Withdrawal struct {
+	*models.WithdrawalData
+	txns []types.Transaction
+}
+
+// Withdraw withdraws amount from the note to address, waiting for the
+// confirmation, and returns the change note with its leaf index set. The change
+// note has random nonces, or derived from seed if not nil.
+// If the withdrawal was sent but its confirmation timed out, the change note is
+// returned with a *protocol.TxnConfirmationError: the withdrawal may still be
+// confirmed, so the change note must be kept. Use PrepareWithdrawal and
+// SubmitWithdrawal to keep the change note before sending
+func (c *Client) Withdraw(amount models.Amount, address models.Address,
+	note *models.Note, seed *models.NoteSeed) (*models.Note, error) {
+	w, err := c.PrepareWithdrawal(amount, address, note, seed)
+	if err != nil {
+		return nil, err
+	}
+	return c.SubmitWithdrawal(w)
+}
+
+// PrepareWithdrawal generates the change note of the withdrawal of amount from the
+// note to address, with random nonces or derived from seed if not nil, and builds
+// its txn group with the zk proof. The withdrawal is sent with SubmitWithdrawal,
+// the change note must be kept before
+func (c *Client) PrepareWithdrawal(amount models.Amount, address models.Address,
+	note *models.Note, seed *models.NoteSeed) (*Withdrawal, error) {
+	params, err := c.Params()
+	if err != nil {
+		return nil, err
+	}
+	changeNote, err := models.GenerateChangeNote(params, amount, note, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	root, leaves, err := snapshot(c.tree)
+	if err != nil {
+		return nil, err
+	}
+	from := *note
+	from.LeafIndex = leafIndexOf(leaves, note.Commitment())
+	if from.LeafIndex == models.EmptyLeafIndex {
+		return nil, fmt.Errorf("note not in the tree")
+	}
+	merkleProof, err := protocol.MerkleProof(c.app.TreeConfig.ZeroHashes, leaves,
+		from.LeafValue(), from.LeafIndex, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merkle proof: %v", err)
+	}
+
+	w := &models.WithdrawalData{
+		Amount:     amount,
+		Fee:        models.NewAmount(params.Fee(amount.Microalgos)),
+		Address:    address,
+		FromNote:   &from,
+		ChangeNote: changeNote,
+	}
+	txns, err := protocol.WithdrawalTxns(c.algod, c.app, w, root, merkleProof, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating withdrawal transactions: %v", err)
+	}
+	changeNote.TxnID = crypto.GetTxID(txns[0])
+	return &Withdrawal{WithdrawalData: w, txns: txns}, nil
+}
+
+// SubmitWithdrawal sends the prepared withdrawal to the network and waits for its
+// confirmation. It returns the change note with its leaf index set.
+// If the withdrawal was sent but its confirmation timed out, the change note is
+// returned with a *protocol.TxnConfirmationError: the withdrawal may still be
+// confirmed
+func (c *Client) SubmitWithdrawal(w *Withdrawal) (*models.Note, error) {
+	confirmation, confirmationError := protocol.SendWithdrawal(c.algod, c.app, w.txns, nil)
+	if confirmationError != nil {
+		if confirmationError.Type == protocol.ErrWaitTimeout {
+			return w.ChangeNote, confirmationError
+		}
+		return nil, confirmationError
+	}
+	w.ChangeNote.LeafIndex = int(confirmation.LeafIndex)
+	return w.ChangeNote, nil
+}
+
+// leafIndexOf returns the index of the leaf with the given commitment, or
+// models.EmptyLeafIndex if there is none
+func leafIndexOf(leaves [][]byte, commitment []byte) int {
+	for i, leaf := range leaves {
+		if bytes.Equal(leaf, commitment) {
+			return i
+		}
+	}
+	return models.EmptyLeafIndex
+}
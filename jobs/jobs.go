@@ -0,0 +1,226 @@
+// Package jobs runs the deposits and withdrawals in the background and keeps their
+// progress, so that the clients can watch them and resume watching after a refresh
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+var (
+	// ErrStoreFull is returned when starting a job in a store at capacity
+	ErrStoreFull = errors.New("job store is full")
+	// ErrNotFound is returned for a job that does not exist or has expired
+	ErrNotFound = errors.New("job not found")
+)
+
+// Kind is what a job does
+type Kind string
+
+const (
+	Deposit    Kind = "deposit"
+	Withdrawal Kind = "withdrawal"
+)
+
+// Job is a deposit or withdrawal running in the background
+type Job struct {
+	Id   string
+	Kind Kind
+
+	mu         sync.Mutex
+	events     []models.JobEvent
+	watchers   map[chan models.JobEvent]struct{}
+	finishedAt time.Time // zero while running
+}
+
+// Store keeps the jobs, running and recently finished
+type Store struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	capacity  int
+	retention time.Duration
+	running   sync.WaitGroup // the goroutines of the running jobs
+}
+
+// UserJobs is the job store used by the handlers
+var UserJobs = NewStore(config.JobCapacity, config.JobRetention)
+
+// NewStore returns a store holding at most capacity jobs, which keeps the finished
+// jobs for the retention duration
+func NewStore(capacity int, retention time.Duration) *Store {
+	return &Store{
+		jobs:      make(map[string]*Job),
+		capacity:  capacity,
+		retention: retention,
+	}
+}
+
+// Start runs f in a new job and returns it. f reports the progress of the job and
+// returns its final event, confirmed or failed
+func (s *Store) Start(kind Kind, f func(report func(models.JobEvent)) models.JobEvent,
+) (*Job, error) {
+	id, err := newJobId()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		Id:       id,
+		Kind:     kind,
+		watchers: make(map[chan models.JobEvent]struct{}),
+	}
+	s.mu.Lock()
+	if len(s.jobs) >= s.capacity {
+		s.mu.Unlock()
+		return nil, ErrStoreFull
+	}
+	s.jobs[id] = job
+	s.mu.Unlock()
+
+	job.publish(models.JobEvent{Stage: models.JobQueued})
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		final := models.JobEvent{Stage: models.JobFailed,
+			Error: "Something went wrong, please try again"}
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Job %s panicked: %v", job.Id, r)
+			}
+			job.publish(final)
+		}()
+		final = f(job.publish)
+	}()
+	return job, nil
+}
+
+// Wait waits for the running jobs to finish, or for ctx to be done in which case
+// it returns the ctx error. No job must be started while waiting, e.g. the server
+// must be shut down first
+func (s *Store) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get returns the job with the given id
+func (s *Store) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// Watch returns all the events of the job so far, and a channel delivering the
+// next ones, closed after the final event. Both are taken under the same lock so
+// that no event is missed or repeated. stop must be called when done watching
+func (j *Job) Watch() (past []models.JobEvent, next <-chan models.JobEvent,
+	stop func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	past = append(past, j.events...)
+	// a job has a handful of events, the buffer holds all of them
+	ch := make(chan models.JobEvent, 8)
+	if !j.finishedAt.IsZero() {
+		close(ch)
+		return past, ch, func() {}
+	}
+	j.watchers[ch] = struct{}{}
+	return past, ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.watchers[ch]; ok {
+			delete(j.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish adds the event to the job and sends it to the watchers. A final event
+// finishes the job, any event after it is dropped
+func (j *Job) publish(e models.JobEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.finishedAt.IsZero() {
+		return
+	}
+	e.Seq = len(j.events) + 1
+	j.events = append(j.events, e)
+	for ch := range j.watchers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Job %s watcher too slow, dropping event %d", j.Id, e.Seq)
+		}
+		if e.Stage.Final() {
+			delete(j.watchers, ch)
+			close(ch)
+		}
+	}
+	if e.Stage.Final() {
+		j.finishedAt = time.Now()
+	}
+}
+
+// removeExpired removes the jobs finished more than the retention duration ago
+func (s *Store) removeExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && time.Since(job.finishedAt) > s.retention
+		job.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// StartCleanupRoutine starts a goroutine that removes the expired jobs at the
+// given interval. It returns a cancel function to stop it
+func (s *Store) StartCleanupRoutine(ctx context.Context, interval time.Duration,
+) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.removeExpired()
+			case <-ctx.Done():
+				log.Println("Jobs cleanup routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// newJobId returns a new random opaque job id
+func newJobId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+func TestWait(t *testing.T) {
+	s := NewStore(10, time.Minute)
+	release := make(chan struct{})
+	job, err := s.Start(Deposit, func(report func(models.JobEvent)) models.JobEvent {
+		<-release
+		return models.JobEvent{Stage: models.JobConfirmed}
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait with a running job = %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+
+	close(release)
+	if err := s.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	past, _, stop := job.Watch()
+	defer stop()
+	if last := past[len(past)-1]; last.Stage != models.JobConfirmed {
+		t.Errorf("last event after Wait = %v, want %v", last.Stage, models.JobConfirmed)
+	}
+}
@@ -0,0 +1,99 @@
+// Package logging sets up the structured logger of the server: text or JSON
+// output at the configured level, with the request id of the context and the
+// secrets redacted
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// Setup makes the default logger, which the log package also writes to, log to w
+// at the given level (debug, info, warn or error) in the given format (text or
+// json)
+func Setup(w io.Writer, level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: l, ReplaceAttr: redact}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+// redact replaces the signed txns, which are not ours to give a LogValue method,
+// with their redacted version. The notes and the deposit and withdrawal data
+// redact themselves
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	switch v := a.Value.Any().(type) {
+	case types.SignedTxn:
+		a.Value = models.RedactedSignedTxn(v).LogValue()
+	case *types.SignedTxn:
+		if v != nil {
+			a.Value = models.RedactedSignedTxn(*v).LogValue()
+		}
+	case []types.SignedTxn:
+		txns := make([]slog.Attr, len(v))
+		for i, t := range v {
+			txns[i] = slog.Any(fmt.Sprint(i), models.RedactedSignedTxn(t))
+		}
+		a.Value = slog.GroupValue(txns...)
+	}
+	return a
+}
+
+// contextKey is the type of the context keys of the package
+type contextKey int
+
+const requestIdKey contextKey = iota
+
+// WithRequestId returns a context carrying the request id, logged with the
+// records of the context
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+// RequestId returns the request id of the context, or ""
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// contextHandler adds the request id of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// secretForms returns the encodings of a secret that must not appear in the logs
+func secretForms(secret []byte) []string {
+	decimal := strings.Trim(fmt.Sprint(secret), "[]")
+	return []string{
+		hex.EncodeToString(secret),
+		strings.ToUpper(hex.EncodeToString(secret)),
+		base64.StdEncoding.EncodeToString(secret),
+		base64.RawURLEncoding.EncodeToString(secret),
+		decimal,
+		strings.ReplaceAll(decimal, " ", ","),
+	}
+}
+
+// useLogger sets up the default logger to write to a new buffer until the end of
+// the test
+func useLogger(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	savedLogger, savedFlags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(savedLogger)
+		log.SetFlags(savedFlags)
+	})
+	var buf bytes.Buffer
+	if err := Setup(&buf, "debug", format); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	return &buf
+}
+
+func TestRedaction(t *testing.T) {
+	note, err := models.GenerateNote(1_000_000)
+	if err != nil {
+		t.Fatalf("GenerateNote: %v", err)
+	}
+	change, err := models.GenerateNote(500_000)
+	if err != nil {
+		t.Fatalf("GenerateNote: %v", err)
+	}
+	var signedTxn types.SignedTxn
+	rand.Read(signedTxn.Sig[:])
+	signedTxn.Txn.Type = types.PaymentTx
+	deposit := &models.DepositData{Amount: models.NewAmount(1_000_000), Note: note,
+		Txns: []types.Transaction{signedTxn.Txn}}
+	withdrawal := &models.WithdrawalData{Amount: models.NewAmount(400_000),
+		FromNote: note, ChangeNote: change}
+	seed, _, err := models.NewSeed()
+	if err != nil {
+		t.Fatalf("NewSeed: %v", err)
+	}
+	noteSeed := &models.NoteSeed{Seed: seed, Counter: 7}
+	receipt := &models.Receipt{WithdrawalTxnID: "TXN", Nullifier: note.Nullifier(),
+		SpentCommitment: note.Commitment(), ChangeCommitment: change.Commitment()}
+
+	var secrets []string
+	for _, s := range [][]byte{note.K[:], note.R[:], change.K[:], change.R[:],
+		signedTxn.Sig[:], seed[:], receipt.Nullifier, receipt.SpentCommitment,
+		receipt.ChangeCommitment} {
+		secrets = append(secrets, secretForms(s)...)
+	}
+
+	logs := []struct {
+		name string
+		log  func()
+	}{
+		{"note", func() { slog.Info("msg", "note", note) }},
+		{"note value", func() { slog.Info("msg", "note", *note) }},
+		{"deposit", func() { slog.Info("msg", "deposit", deposit) }},
+		{"withdrawal", func() { slog.Info("msg", "withdrawal", withdrawal) }},
+		{"signed txn", func() { slog.Info("msg", "txn", signedTxn) }},
+		{"signed txn pointer", func() { slog.Info("msg", "txn", &signedTxn) }},
+		{"signed txns", func() {
+			slog.Info("msg", "txns", []types.SignedTxn{signedTxn, signedTxn})
+		}},
+		{"group", func() {
+			slog.Info("msg", slog.Group("outer", slog.Group("inner", "note", note,
+				"deposit", deposit, "withdrawal", withdrawal, "txn", signedTxn)))
+		}},
+		{"logger with group", func() {
+			slog.Default().WithGroup("job").With("note", note).Info("msg",
+				"withdrawal", withdrawal, "txn", &signedTxn)
+		}},
+		{"log %v", func() {
+			log.Printf("%v %v %v %v", note, *note, deposit, withdrawal)
+		}},
+		{"log %+v %#v", func() {
+			log.Printf("%+v %#v %+v %#v", deposit, deposit, withdrawal, withdrawal)
+		}},
+		{"log redacted signed txn", func() {
+			log.Printf("%v %+v", models.RedactedSignedTxn(signedTxn),
+				models.RedactedSignedTxn(signedTxn))
+		}},
+		{"sprintf attr", func() { slog.Info("msg", "note", fmt.Sprintf("%v", note)) }},
+		{"seed", func() { slog.Info("msg", "seed", seed, "seed value", *seed) }},
+		{"note seed", func() {
+			slog.Info("msg", "seed", noteSeed, "seed value", *noteSeed)
+		}},
+		{"receipt", func() { slog.Info("msg", "receipt", receipt, "value", *receipt) }},
+		{"log seed", func() {
+			log.Printf("%v %+v %#v %s %x %X %d %q", seed, *seed, seed, seed, *seed,
+				seed, *seed, seed)
+		}},
+		{"log note seed and receipt", func() {
+			log.Printf("%v %+v %#v %v %+v %#v", noteSeed, *noteSeed, noteSeed,
+				receipt, *receipt, receipt)
+		}},
+	}
+	for _, format := range []string{"text", "json"} {
+		for _, l := range logs {
+			buf := useLogger(t, format)
+			l.log()
+			out := buf.String()
+			if out == "" {
+				t.Fatalf("%s %s: nothing logged", format, l.name)
+			}
+			for _, s := range secrets {
+				if strings.Contains(out, s) {
+					t.Errorf("%s %s: secret %s leaked in %s", format, l.name, s, out)
+				}
+			}
+		}
+	}
+}
@@ -4,49 +4,156 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/giuliop/HermesVault-frontend/api"
+	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
 	"github.com/giuliop/HermesVault-frontend/handlers"
+	"github.com/giuliop/HermesVault-frontend/jobs"
+	"github.com/giuliop/HermesVault-frontend/logging"
+	"github.com/giuliop/HermesVault-frontend/memstore"
+	"github.com/giuliop/HermesVault-frontend/ratelimit"
+	"github.com/giuliop/HermesVault-frontend/security"
 )
 
 func main() {
 	// Parse the -dev flag
 	dev := flag.Bool("dev", false, "run in development mode")
+	migrateStatus := flag.Bool("migrate-status", false,
+		"print the internal database migrations status and exit")
+	migrateDryRun := flag.Bool("migrate-dry-run", false,
+		"check the pending internal database migrations without applying them and exit")
 	flag.Parse()
 
+	// Log structured records with the request ids and the secrets redacted
+	if err := logging.Setup(os.Stderr, config.LogLevel, config.LogFormat); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+
 	defer db.Close()
 
+	// Bring the internal database schema up to date, refusing to start if the
+	// database is newer than this binary
+	switch {
+	case *migrateStatus:
+		printMigrationsStatus()
+		return
+	case *migrateDryRun:
+		pending, err := db.Migrate(true)
+		if err != nil {
+			log.Fatalf("Migration dry run failed: %v", err)
+		}
+		for _, m := range pending {
+			log.Printf("Migration %d (%s) would be applied", m.Version, m.Name)
+		}
+		log.Printf("Migration dry run ok, %d pending migrations", len(pending))
+		return
+	}
+	if _, err := db.Migrate(false); err != nil {
+		log.Fatalf("Error migrating internal database: %v", err)
+	}
+	if !db.NoteKeysLoaded() {
+		log.Fatalf("Error starting server: %v, set NoteKeysPath or %s",
+			db.ErrNoNoteKeys, config.NoteKeysEnv)
+	}
+	defer memstore.UserSessions.Close()
+
 	// Start periodic cleanup of internal database
 	db.CleanupUnconfirmedNotes()
 	cleanupCancel := db.StartCleanupRoutine(context.Background(), config.CleanupInterval)
 	defer cleanupCancel()
+	watcherCancel := db.StartTxnsWatcher(context.Background(), config.TxnsWatchInterval,
+		avm.RoundTime)
+	defer watcherCancel()
+
+	// Start periodic backups of internal database
+	if config.BackupDirPath != "" {
+		backupCancel := db.StartBackupRoutine(context.Background(), config.BackupDirPath,
+			config.BackupInterval, config.BackupRetention)
+		defer backupCancel()
+	}
+
+	// Load the protocol parameters the app declares and keep them up to date, the
+	// others keep their compiled-in defaults
+	if err := avm.RefreshProtocolParams(); err != nil {
+		log.Printf("Error loading protocol params: %v", err)
+	}
+	paramsCancel := avm.StartParamsRefreshRoutine(context.Background(),
+		config.ParamsRefreshInterval)
+	defer paramsCancel()
+
+	// Check how far the subscriber service is behind the chain
+	if err := avm.RefreshIndexerStatus(); err != nil {
+		log.Printf("Error checking indexer status: %v", err)
+	}
+	indexerCancel := avm.StartIndexerStatusRoutine(context.Background(),
+		config.IndexerCheckInterval)
+	defer indexerCancel()
+
+	// Compute the protocol statistics and keep them up to date
+	if err := db.RefreshStats(); err != nil {
+		log.Printf("Error computing protocol stats: %v", err)
+	}
+	statsCancel := db.StartStatsRoutine(context.Background(), config.StatsRefreshInterval)
+	defer statsCancel()
+
+	// Remove the finished deposit and withdrawal jobs once expired
+	jobsCancel := jobs.UserJobs.StartCleanupRoutine(context.Background(),
+		config.JobCleanupInterval)
+	defer jobsCancel()
 
 	templates.InitTemplates()
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if err := templates.Main.Execute(w, nil); err != nil {
-			log.Printf("Error executing main template: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-	})
+	http.HandleFunc("/", handlers.MainHandler)
 
 	http.HandleFunc("/deposit", handlers.DepositHandler)
 	http.HandleFunc("/withdraw", handlers.WithdrawHandler)
+	http.HandleFunc("/withdraw-quote", handlers.WithdrawQuoteHandler)
 	http.HandleFunc("/confirm-deposit", handlers.ConfirmDepositHandler)
 	http.HandleFunc("/confirm-withdraw", handlers.ConfirmWithdrawHandler)
+	http.HandleFunc("/association-sets", handlers.AssociationSetsHandler)
+	http.HandleFunc("/note-backup", handlers.NoteBackupHandler)
+	http.HandleFunc("/indexer-status", handlers.IndexerStatusHandler)
+	http.HandleFunc("/health", handlers.HealthHandler)
+	http.HandleFunc("/stats", handlers.StatsHandler)
+	http.HandleFunc("/stats.json", handlers.StatsJSONHandler)
+	http.HandleFunc("/jobs/{id}", handlers.JobHandler)
+	http.HandleFunc("/jobs/{id}/events", handlers.JobEventsHandler)
+
+	// The JSON API, on the same service code as the HTML handlers
+	api.RegisterHandlers()
 
 	// Serve static files from the "static" directory
 	http.Handle("/static/", http.StripPrefix("/static/",
 		http.FileServer(http.Dir("./frontend/static/"))))
 
+	// Limit the requests of each client, the API ones get a JSON error
+	rateLimitCancel := ratelimit.StartCleanupRoutine(context.Background(),
+		config.RateLimitCleanupInterval)
+	defer rateLimitCancel()
+	handler := ratelimit.Middleware(http.DefaultServeMux,
+		func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+			if strings.HasPrefix(r.URL.Path, api.Prefix) {
+				api.RateLimited(w, r, retryAfter)
+				return
+			}
+			handlers.RateLimited(w, r, retryAfter)
+		})
+	// Set the security headers, limit the request bodies and check the CSRF tokens
+	handler = security.Middleware(handler, api.Prefix, handlers.CSRFFailed)
+	// Give each request an id, logged with its records and returned to the client
+	handler = logging.Middleware(handler)
+
 	var server *http.Server
 
 	// Determine the mode and configure the server accordingly
@@ -60,7 +167,13 @@ func main() {
 
 		// Create a custom HTTPS server
 		server = &http.Server{
-			Addr: ":" + config.DevelopmentPort,
+			Addr:              ":" + config.DevelopmentPort,
+			Handler:           handler,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxRequestHeaderSize,
 			TLSConfig: &tls.Config{
 				Certificates: []tls.Certificate{cert},
 			},
@@ -77,7 +190,13 @@ func main() {
 	} else {
 		// Production mode, we serve HTTP to a reverse proxy
 		server = &http.Server{
-			Addr: ":" + config.ProductionPort,
+			Addr:              ":" + config.ProductionPort,
+			Handler:           handler,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxRequestHeaderSize,
 		}
 
 		log.Printf("Server running in production mode on port %s\n",
@@ -96,9 +215,40 @@ func main() {
 	// Block the main goroutine until the server is shut down
 	<-quit
 	log.Print("\nShutting down server...\n\n")
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v\n", err)
+		log.Printf("Server forced to shutdown: %v\n", err)
+	}
+	// the jobs outlive their requests, let them record their notes
+	jobsCtx, jobsCancel := context.WithTimeout(context.Background(),
+		config.JobsShutdownTimeout)
+	defer jobsCancel()
+	if err := jobs.UserJobs.Wait(jobsCtx); err != nil {
+		log.Printf("Jobs still running at shutdown: %v\n", err)
+	}
+}
+
+// printMigrationsStatus prints the status of the internal database migrations
+func printMigrationsStatus() {
+	current, latest, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Error reading schema version: %v", err)
+	}
+	status, err := db.MigrationsStatus()
+	if err != nil {
+		log.Fatalf("Error reading migrations status: %v", err)
+	}
+	if current == 0 {
+		fmt.Printf("Internal database unversioned, binary version %d\n", latest)
+	} else {
+		fmt.Printf("Internal database version %d, binary version %d\n", current, latest)
+	}
+	for _, m := range status {
+		appliedAt := m.AppliedAt
+		if appliedAt == "" {
+			appliedAt = "pending"
+		}
+		fmt.Printf("%4d  %-20s  %s\n", m.Version, appliedAt, m.Name)
 	}
 }
@@ -1,36 +1,120 @@
 package memstore
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/models"
 
 	"github.com/algorand/go-algorand-sdk/v2/types"
 )
 
-type depositData struct {
-	depositData *models.DepositData
-	createdAt   time.Time
+// ErrStoreFull is returned when storing a session in a store at capacity
+var ErrStoreFull = errors.New("session store is full")
+
+// SessionStore keeps the user sessions between the requests of a deposit or a
+// withdrawal
+type SessionStore interface {
+	// StoreDeposit adds a deposit to the store and returns its group ID
+	StoreDeposit(d *models.DepositData) (types.Digest, error)
+	// RetrieveDeposit fetches the deposit with the given group ID
+	RetrieveDeposit(groupId types.Digest) (*models.DepositData, error)
+	// DeleteDeposit removes the deposit with the given group ID
+	DeleteDeposit(groupId types.Digest)
+	// StoreWithdrawal adds a withdrawal to the store under a new session id, which
+	// is set in the withdrawal SessionId and returned
+	StoreWithdrawal(w *models.WithdrawalData) (string, error)
+	// RetrieveWithdrawal fetches the withdrawal with the given session id
+	RetrieveWithdrawal(sessionId string) (*models.WithdrawalData, error)
+	// DeleteWithdrawal removes the withdrawal with the given session id
+	DeleteWithdrawal(sessionId string)
+	// ReserveSeedCounter reserves the first counter, not below from, of the seed
+	// with the given id that is not reserved already and returns it. A counter
+	// stays reserved for twice the TTL, covering the session deriving a note from
+	// it and the confirmation of its txns
+	ReserveSeedCounter(seedId []byte, from uint64) (uint64, error)
+	// Close stops the store cleanup and releases its resources
+	Close() error
 }
 
-// MemoryStoreWithCleanup encapsulates a sync.Map and cleanup configuration
-type MemoryStoreWithCleanup struct {
-	data            sync.Map
-	ttl             time.Duration
-	cleanupInterval time.Duration
+// Options configures a session store
+type Options struct {
+	TTL             time.Duration // how long to keep a session
+	CleanupInterval time.Duration // how often to remove expired sessions
+	Capacity        int           // maximum number of sessions, 0 for no limit
 }
 
-// Singleton instance and initialization
-var UserSessions *MemoryStoreWithCleanup
+// UserSessions is the session store used by the handlers
+var UserSessions SessionStore
 
 func init() {
-	UserSessions = &MemoryStoreWithCleanup{
-		ttl:             10 * time.Minute, // how long to keep data in memory
-		cleanupInterval: 5 * time.Minute,  // how often to run cleanup
+	opts := Options{
+		TTL:             config.SessionTTL,
+		CleanupInterval: config.SessionCleanupInterval,
+		Capacity:        config.SessionCapacity,
+	}
+	if config.SessionDbPath == "" {
+		UserSessions = NewMemoryStore(opts)
+		return
+	}
+	var err error
+	UserSessions, err = NewSQLiteStore(config.SessionDbPath, opts)
+	if err != nil {
+		log.Fatalf("failed to initialize session store: %v", err)
+	}
+}
+
+// entry is a session in the memory store, keyed by the deposit group ID or the
+// withdrawal session id
+type entry struct {
+	value     any
+	createdAt time.Time
+}
+
+// seedCounter is a counter of a seed, by seed id
+type seedCounter struct {
+	seedId  string
+	counter uint64
+}
+
+// newSessionId returns a new random opaque session id
+func newSessionId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryStoreWithCleanup is a process-local SessionStore backed by a sync.Map
+type MemoryStoreWithCleanup struct {
+	data  sync.Map
+	count atomic.Int64
+	opts  Options
+
+	reservedMu sync.Mutex
+	reserved   map[seedCounter]time.Time // reservation times of the seed counters
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryStore returns a new in-memory store and starts its cleanup
+func NewMemoryStore(opts Options) *MemoryStoreWithCleanup {
+	s := &MemoryStoreWithCleanup{
+		opts:     opts,
+		reserved: make(map[seedCounter]time.Time),
+		done:     make(chan struct{}),
 	}
-	go UserSessions.startCleanup()
+	go s.startCleanup()
+	return s
 }
 
 // StoreDeposit adds a new TxnGroup to the store and returns its group ID
@@ -39,48 +123,150 @@ func (s *MemoryStoreWithCleanup) StoreDeposit(d *models.DepositData) (types.Dige
 	if groupId == (types.Digest{}) {
 		return types.Digest{}, fmt.Errorf("missing group ID")
 	}
-	s.data.Store(groupId, depositData{
-		depositData: d,
-		createdAt:   time.Now(),
-	})
+	if err := s.put(groupId, d); err != nil {
+		return types.Digest{}, err
+	}
 	return groupId, nil
 }
 
 // RetrieveDeposit fetches the TxnGroup associated with the given group ID
 func (s *MemoryStoreWithCleanup) RetrieveDeposit(groupId types.Digest) (*models.DepositData, error) {
-	value, ok := s.data.Load(groupId)
+	value, ok := s.get(groupId)
 	if !ok {
 		return nil, fmt.Errorf("depositID not found: %v", groupId)
 	}
-	data, ok := value.(depositData)
+	data, ok := value.(*models.DepositData)
 	if !ok {
 		return nil, fmt.Errorf("invalid data type")
 	}
-	return data.depositData, nil
+	return data, nil
 }
 
 // DeleteDeposit removes the TransactionGroup associated with the given group ID
 func (s *MemoryStoreWithCleanup) DeleteDeposit(groupId types.Digest) {
-	s.data.Delete(groupId)
+	s.delete(groupId)
+}
+
+// StoreWithdrawal adds a withdrawal to the store and returns its session id
+func (s *MemoryStoreWithCleanup) StoreWithdrawal(w *models.WithdrawalData) (string, error) {
+	sessionId, err := newSessionId()
+	if err != nil {
+		return "", err
+	}
+	w.SessionId = sessionId
+	if err := s.put(sessionId, w); err != nil {
+		return "", err
+	}
+	return sessionId, nil
+}
+
+// RetrieveWithdrawal fetches the withdrawal associated with the given session id
+func (s *MemoryStoreWithCleanup) RetrieveWithdrawal(sessionId string) (*models.WithdrawalData, error) {
+	value, ok := s.get(sessionId)
+	if !ok {
+		return nil, fmt.Errorf("withdrawal session not found: %v", sessionId)
+	}
+	data, ok := value.(*models.WithdrawalData)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type")
+	}
+	return data, nil
+}
+
+// DeleteWithdrawal removes the withdrawal associated with the given session id
+func (s *MemoryStoreWithCleanup) DeleteWithdrawal(sessionId string) {
+	s.delete(sessionId)
+}
+
+// ReserveSeedCounter reserves the first free counter of the seed not below from
+func (s *MemoryStoreWithCleanup) ReserveSeedCounter(seedId []byte, from uint64,
+) (uint64, error) {
+	s.reservedMu.Lock()
+	defer s.reservedMu.Unlock()
+	now := time.Now()
+	key := seedCounter{seedId: string(seedId), counter: from}
+	for {
+		reservedAt, ok := s.reserved[key]
+		if !ok || now.Sub(reservedAt) > 2*s.opts.TTL {
+			s.reserved[key] = now
+			return key.counter, nil
+		}
+		key.counter++
+	}
+}
+
+// put stores value under key, checking the store capacity
+func (s *MemoryStoreWithCleanup) put(key, value any) error {
+	if s.opts.Capacity > 0 && s.count.Load() >= int64(s.opts.Capacity) {
+		s.cleanup()
+		if s.count.Load() >= int64(s.opts.Capacity) {
+			return ErrStoreFull
+		}
+	}
+	_, loaded := s.data.Swap(key, entry{value: value, createdAt: time.Now()})
+	if !loaded {
+		s.count.Add(1)
+	}
+	return nil
 }
 
-// startCleanup periodically removes expired entries from the store.
+// get returns the value stored under key if not expired
+func (s *MemoryStoreWithCleanup) get(key any) (any, bool) {
+	value, ok := s.data.Load(key)
+	if !ok {
+		return nil, false
+	}
+	e, ok := value.(entry)
+	if !ok || time.Since(e.createdAt) > s.opts.TTL {
+		s.delete(key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// delete removes the value stored under key
+func (s *MemoryStoreWithCleanup) delete(key any) {
+	if _, loaded := s.data.LoadAndDelete(key); loaded {
+		s.count.Add(-1)
+	}
+}
+
+// Close stops the cleanup goroutine
+func (s *MemoryStoreWithCleanup) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// startCleanup periodically removes expired entries from the store until the
+// store is closed
 func (s *MemoryStoreWithCleanup) startCleanup() {
-	ticker := time.NewTicker(s.cleanupInterval)
+	ticker := time.NewTicker(s.opts.CleanupInterval)
 	defer ticker.Stop()
 	for {
-		<-ticker.C
-		now := time.Now()
-		s.data.Range(func(key, value interface{}) bool {
-			tg, ok := value.(depositData)
-			if !ok {
-				s.data.Delete(key)
-				return true
-			}
-			if now.Sub(tg.createdAt) > s.ttl {
-				s.data.Delete(key)
-			}
-			return true
-		})
+		select {
+		case <-ticker.C:
+			s.cleanup()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// cleanup removes the expired entries and seed counter reservations from the store
+func (s *MemoryStoreWithCleanup) cleanup() {
+	now := time.Now()
+	s.data.Range(func(key, value interface{}) bool {
+		e, ok := value.(entry)
+		if !ok || now.Sub(e.createdAt) > s.opts.TTL {
+			s.delete(key)
+		}
+		return true
+	})
+	s.reservedMu.Lock()
+	defer s.reservedMu.Unlock()
+	for key, reservedAt := range s.reserved {
+		if now.Sub(reservedAt) > 2*s.opts.TTL {
+			delete(s.reserved, key)
+		}
 	}
 }
@@ -0,0 +1,251 @@
+package memstore
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// testStores returns a memory and a SQLite store with the given options, closed
+// at the end of the test
+func testStores(t *testing.T, opts Options) map[string]SessionStore {
+	t.Helper()
+	if err := db.SetNoteKeys("1:" + strings.Repeat("ab", 32)); err != nil {
+		t.Fatalf("SetNoteKeys: %v", err)
+	}
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"), opts)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	memory := NewMemoryStore(opts)
+	t.Cleanup(func() {
+		sqlite.Close()
+		memory.Close()
+	})
+	return map[string]SessionStore{"memory": memory, "sqlite": sqlite}
+}
+
+// testNote returns a new note of amount for the txn
+func testNote(t *testing.T, amount uint64, txnId string) *models.Note {
+	t.Helper()
+	n, err := models.GenerateNote(amount)
+	if err != nil {
+		t.Fatalf("GenerateNote: %v", err)
+	}
+	n.TxnID = txnId
+	return n
+}
+
+// testDeposit returns a deposit of two txns in the group with the given first byte
+func testDeposit(t *testing.T, group byte) *models.DepositData {
+	t.Helper()
+	txns := make([]types.Transaction, 2)
+	for i := range txns {
+		txns[i].Type = types.ApplicationCallTx
+		txns[i].FirstValid = types.Round(i + 1)
+		txns[i].Group = types.Digest{group}
+	}
+	return &models.DepositData{
+		Amount:         models.NewAmount(1_000_000),
+		Address:        "ADDRESS",
+		Note:           testNote(t, 1_000_000, "TXN"),
+		Txns:           txns,
+		IndexTxnToSign: 1,
+	}
+}
+
+// testWithdrawal returns a withdrawal of amount
+func testWithdrawal(t *testing.T, amount uint64) *models.WithdrawalData {
+	t.Helper()
+	return &models.WithdrawalData{
+		Amount:         models.NewAmount(amount),
+		Fee:            models.NewAmount(100_000),
+		Address:        "ADDRESS",
+		FromNote:       testNote(t, 10_000_000, "FROM"),
+		ChangeNote:     testNote(t, 10_000_000-amount-100_000, ""),
+		AssociationSet: "policy",
+	}
+}
+
+func TestDepositSession(t *testing.T) {
+	opts := Options{TTL: time.Hour, CleanupInterval: time.Hour, Capacity: 10}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			d := testDeposit(t, 1)
+			groupId, err := s.StoreDeposit(d)
+			if err != nil {
+				t.Fatalf("StoreDeposit: %v", err)
+			}
+			if groupId != d.Txns[0].Group {
+				t.Fatalf("StoreDeposit returned group %v, want %v", groupId,
+					d.Txns[0].Group)
+			}
+			got, err := s.RetrieveDeposit(groupId)
+			if err != nil {
+				t.Fatalf("RetrieveDeposit: %v", err)
+			}
+			if got.Amount != d.Amount || got.Address != d.Address ||
+				got.Note.Text() != d.Note.Text() || got.Note.TxnID != d.Note.TxnID ||
+				got.IndexTxnToSign != d.IndexTxnToSign || len(got.Txns) != len(d.Txns) {
+				t.Fatalf("RetrieveDeposit() = %+v, want %+v", got, d)
+			}
+			for i := range d.Txns {
+				if got.Txns[i].FirstValid != d.Txns[i].FirstValid ||
+					got.Txns[i].Group != d.Txns[i].Group {
+					t.Errorf("RetrieveDeposit() txn %d = %+v, want %+v", i, got.Txns[i],
+						d.Txns[i])
+				}
+			}
+
+			s.DeleteDeposit(groupId)
+			if _, err := s.RetrieveDeposit(groupId); err == nil {
+				t.Errorf("RetrieveDeposit after DeleteDeposit succeeded")
+			}
+			if _, err := s.StoreDeposit(testDeposit(t, 0)); err == nil {
+				t.Errorf("StoreDeposit without a group ID succeeded")
+			}
+		})
+	}
+}
+
+func TestWithdrawalSession(t *testing.T) {
+	opts := Options{TTL: time.Hour, CleanupInterval: time.Hour, Capacity: 10}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			w := testWithdrawal(t, 1_000_000)
+			sessionId, err := s.StoreWithdrawal(w)
+			if err != nil {
+				t.Fatalf("StoreWithdrawal: %v", err)
+			}
+			if sessionId == "" || w.SessionId != sessionId {
+				t.Fatalf("StoreWithdrawal returned session %q, set %q", sessionId,
+					w.SessionId)
+			}
+			other, err := s.StoreWithdrawal(testWithdrawal(t, 2_000_000))
+			if err != nil || other == sessionId {
+				t.Fatalf("second StoreWithdrawal = %q, %v", other, err)
+			}
+			got, err := s.RetrieveWithdrawal(sessionId)
+			if err != nil {
+				t.Fatalf("RetrieveWithdrawal: %v", err)
+			}
+			if got.Amount != w.Amount || got.Fee != w.Fee || got.Address != w.Address ||
+				got.FromNote.Text() != w.FromNote.Text() ||
+				got.ChangeNote.Text() != w.ChangeNote.Text() ||
+				got.AssociationSet != w.AssociationSet || got.SessionId != sessionId {
+				t.Fatalf("RetrieveWithdrawal() = %+v, want %+v", got, w)
+			}
+
+			s.DeleteWithdrawal(sessionId)
+			if _, err := s.RetrieveWithdrawal(sessionId); err == nil {
+				t.Errorf("RetrieveWithdrawal after DeleteWithdrawal succeeded")
+			}
+			if _, err := s.RetrieveWithdrawal(other); err != nil {
+				t.Errorf("RetrieveWithdrawal of the other session: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionCapacity(t *testing.T) {
+	opts := Options{TTL: time.Hour, CleanupInterval: time.Hour, Capacity: 2}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			first, err := s.StoreWithdrawal(testWithdrawal(t, 1_000_000))
+			if err != nil {
+				t.Fatalf("StoreWithdrawal: %v", err)
+			}
+			if _, err := s.StoreDeposit(testDeposit(t, 1)); err != nil {
+				t.Fatalf("StoreDeposit: %v", err)
+			}
+			if _, err := s.StoreWithdrawal(testWithdrawal(t, 1_000_000)); !errors.Is(err,
+				ErrStoreFull) {
+				t.Fatalf("StoreWithdrawal over capacity = %v, want %v", err, ErrStoreFull)
+			}
+			if _, err := s.StoreDeposit(testDeposit(t, 2)); !errors.Is(err, ErrStoreFull) {
+				t.Fatalf("StoreDeposit over capacity = %v, want %v", err, ErrStoreFull)
+			}
+			s.DeleteWithdrawal(first)
+			if _, err := s.StoreDeposit(testDeposit(t, 2)); err != nil {
+				t.Errorf("StoreDeposit after a deletion: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	// the SQLite store keeps the session times in seconds
+	opts := Options{TTL: 500 * time.Millisecond, CleanupInterval: time.Hour,
+		Capacity: 1}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sessionId, err := s.StoreWithdrawal(testWithdrawal(t, 1_000_000))
+			if err != nil {
+				t.Fatalf("StoreWithdrawal: %v", err)
+			}
+			time.Sleep(opts.TTL + time.Second)
+			if _, err := s.RetrieveWithdrawal(sessionId); err == nil {
+				t.Errorf("RetrieveWithdrawal of an expired session succeeded")
+			}
+			// the expired session is removed to make room
+			if _, err := s.StoreDeposit(testDeposit(t, 1)); err != nil {
+				t.Errorf("StoreDeposit after the expiry: %v", err)
+			}
+		})
+	}
+}
+
+func TestReserveSeedCounter(t *testing.T) {
+	opts := Options{TTL: time.Hour, CleanupInterval: time.Hour}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			reserve := func(seedId string, from, want uint64) {
+				t.Helper()
+				got, err := s.ReserveSeedCounter([]byte(seedId), from)
+				if err != nil {
+					t.Fatalf("ReserveSeedCounter: %v", err)
+				}
+				if got != want {
+					t.Errorf("ReserveSeedCounter(%s, %d) = %d, want %d", seedId, from,
+						got, want)
+				}
+			}
+			reserve("a", 0, 0)
+			reserve("a", 0, 1)
+			reserve("a", 0, 2)
+			reserve("a", 5, 5)
+			reserve("a", 4, 4)
+			reserve("a", 4, 6)
+			reserve("b", 0, 0)
+		})
+	}
+}
+
+func TestReserveSeedCounterExpiry(t *testing.T) {
+	// the SQLite store keeps the reservation times in seconds
+	opts := Options{TTL: 500 * time.Millisecond, CleanupInterval: time.Hour}
+	for name, s := range testStores(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := s.ReserveSeedCounter([]byte("a"), 0); err != nil {
+				t.Fatalf("ReserveSeedCounter: %v", err)
+			}
+			time.Sleep(2*opts.TTL + time.Second)
+			got, err := s.ReserveSeedCounter([]byte("a"), 0)
+			if err != nil {
+				t.Fatalf("ReserveSeedCounter: %v", err)
+			}
+			if got != 0 {
+				t.Errorf("ReserveSeedCounter after expiry = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package memstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore is a SessionStore backed by a SQLite database, so that sessions
+// survive restarts and can be shared by several instances of the frontend.
+// The sessions hold the secret notes, so they are encrypted with the note keys
+// of the internal database, which must be configured
+type SQLiteStore struct {
+	db   *sql.DB
+	opts Options
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// depositRecord is the serialized form of a deposit session
+type depositRecord struct {
+	Amount         uint64
+	Address        models.Address
+	Note           *models.Note
+	Txns           [][]byte // msgpack encoded transactions
+	IndexTxnToSign int
+}
+
+// withdrawalRecord is the serialized form of a withdrawal session
+type withdrawalRecord struct {
+	Amount         uint64
+	Fee            uint64
+	Address        models.Address
+	FromNote       *models.Note
+	ChangeNote     *models.Note
+	AssociationSet string
+}
+
+// NewSQLiteStore opens (creating it if needed) the session database at path and
+// starts its cleanup
+func NewSQLiteStore(path string, opts Options) (*SQLiteStore, error) {
+	if !db.NoteKeysLoaded() {
+		return nil, fmt.Errorf("the session database needs the note keys: %w",
+			db.ErrNoNoteKeys)
+	}
+	sqlDb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database: %w", err)
+	}
+	// Enable WAL and set busy timeout to 5000ms (5 seconds) since several
+	// instances can share the database
+	_, err = sqlDb.Exec(`
+		PRAGMA journal_mode = WAL;
+		PRAGMA busy_timeout = 5000;
+
+		CREATE TABLE IF NOT EXISTS deposit_sessions (
+			group_id BLOB PRIMARY KEY,			-- group id of the deposit txns
+			data BLOB NOT NULL,					-- encrypted json encoded depositRecord
+			created_at INTEGER NOT NULL			-- unix time
+		) STRICT;
+
+		CREATE INDEX IF NOT EXISTS deposit_sessions_created_at
+			ON deposit_sessions(created_at);
+
+		CREATE TABLE IF NOT EXISTS withdrawal_sessions (
+			id TEXT PRIMARY KEY,				-- opaque session id
+			data BLOB NOT NULL,					-- encrypted json encoded withdrawalRecord
+			created_at INTEGER NOT NULL			-- unix time
+		) STRICT;
+
+		CREATE INDEX IF NOT EXISTS withdrawal_sessions_created_at
+			ON withdrawal_sessions(created_at);
+
+		CREATE TABLE IF NOT EXISTS seed_counters (
+			seed_id BLOB NOT NULL,				-- id of the seed, see models.Seed.Id
+			counter INTEGER NOT NULL,			-- counter reserved
+			created_at INTEGER NOT NULL,		-- unix time
+			PRIMARY KEY (seed_id, counter)
+		) STRICT;
+
+		CREATE INDEX IF NOT EXISTS seed_counters_created_at
+			ON seed_counters(created_at);
+	`)
+	if err != nil {
+		sqlDb.Close()
+		return nil, fmt.Errorf("failed to initialize session database: %w", err)
+	}
+	s := &SQLiteStore{
+		db:   sqlDb,
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	go s.startCleanup()
+	log.Println("Session database initialized successfully")
+	return s, nil
+}
+
+// StoreDeposit adds a new deposit to the store and returns its group ID
+func (s *SQLiteStore) StoreDeposit(d *models.DepositData) (types.Digest, error) {
+	groupId := d.Txns[0].Group
+	if groupId == (types.Digest{}) {
+		return types.Digest{}, fmt.Errorf("missing group ID")
+	}
+	record := depositRecord{
+		Amount:         d.Amount.Microalgos,
+		Address:        d.Address,
+		Note:           d.Note,
+		IndexTxnToSign: d.IndexTxnToSign,
+	}
+	for _, txn := range d.Txns {
+		record.Txns = append(record.Txns, msgpack.Encode(txn))
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return types.Digest{}, fmt.Errorf("failed to encode deposit: %v", err)
+	}
+	if data, err = db.SealSession(groupId[:], data); err != nil {
+		return types.Digest{}, fmt.Errorf("failed to encrypt deposit: %v", err)
+	}
+	if err := s.put("deposit_sessions", groupId[:], data); err != nil {
+		return types.Digest{}, err
+	}
+	return groupId, nil
+}
+
+// RetrieveDeposit fetches the deposit associated with the given group ID
+func (s *SQLiteStore) RetrieveDeposit(groupId types.Digest) (*models.DepositData, error) {
+	var data []byte
+	err := s.db.QueryRow(`
+		SELECT data FROM deposit_sessions WHERE group_id = ? AND created_at >= ?`,
+		groupId[:], s.expiredBefore()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("depositID not found: %v", groupId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve deposit: %v", err)
+	}
+	if data, err = db.OpenSession(groupId[:], data); err != nil {
+		return nil, fmt.Errorf("failed to decrypt deposit: %v", err)
+	}
+	var record depositRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode deposit: %v", err)
+	}
+	d := &models.DepositData{
+		Amount:         models.NewAmount(record.Amount),
+		Address:        record.Address,
+		Note:           record.Note,
+		Txns:           make([]types.Transaction, len(record.Txns)),
+		IndexTxnToSign: record.IndexTxnToSign,
+	}
+	for i, txn := range record.Txns {
+		if err := msgpack.Decode(txn, &d.Txns[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode deposit txn: %v", err)
+		}
+	}
+	return d, nil
+}
+
+// DeleteDeposit removes the deposit associated with the given group ID
+func (s *SQLiteStore) DeleteDeposit(groupId types.Digest) {
+	_, err := s.db.Exec("DELETE FROM deposit_sessions WHERE group_id = ?", groupId[:])
+	if err != nil {
+		log.Printf("Error deleting deposit session: %v", err)
+	}
+}
+
+// StoreWithdrawal adds a withdrawal to the store and returns its session id
+func (s *SQLiteStore) StoreWithdrawal(w *models.WithdrawalData) (string, error) {
+	sessionId, err := newSessionId()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(withdrawalRecord{
+		Amount:         w.Amount.Microalgos,
+		Fee:            w.Fee.Microalgos,
+		Address:        w.Address,
+		FromNote:       w.FromNote,
+		ChangeNote:     w.ChangeNote,
+		AssociationSet: w.AssociationSet,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode withdrawal: %v", err)
+	}
+	if data, err = db.SealSession([]byte(sessionId), data); err != nil {
+		return "", fmt.Errorf("failed to encrypt withdrawal: %v", err)
+	}
+	if err := s.put("withdrawal_sessions", sessionId, data); err != nil {
+		return "", err
+	}
+	w.SessionId = sessionId
+	return sessionId, nil
+}
+
+// RetrieveWithdrawal fetches the withdrawal associated with the given session id
+func (s *SQLiteStore) RetrieveWithdrawal(sessionId string) (*models.WithdrawalData, error) {
+	var data []byte
+	err := s.db.QueryRow(`
+		SELECT data FROM withdrawal_sessions WHERE id = ? AND created_at >= ?`,
+		sessionId, s.expiredBefore()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("withdrawal session not found: %v", sessionId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve withdrawal: %v", err)
+	}
+	if data, err = db.OpenSession([]byte(sessionId), data); err != nil {
+		return nil, fmt.Errorf("failed to decrypt withdrawal: %v", err)
+	}
+	var record withdrawalRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode withdrawal: %v", err)
+	}
+	return &models.WithdrawalData{
+		Amount:         models.NewAmount(record.Amount),
+		Fee:            models.NewAmount(record.Fee),
+		Address:        record.Address,
+		FromNote:       record.FromNote,
+		ChangeNote:     record.ChangeNote,
+		AssociationSet: record.AssociationSet,
+		SessionId:      sessionId,
+	}, nil
+}
+
+// DeleteWithdrawal removes the withdrawal associated with the given session id
+func (s *SQLiteStore) DeleteWithdrawal(sessionId string) {
+	_, err := s.db.Exec("DELETE FROM withdrawal_sessions WHERE id = ?", sessionId)
+	if err != nil {
+		log.Printf("Error deleting withdrawal session: %v", err)
+	}
+}
+
+// ReserveSeedCounter reserves the first free counter of the seed not below from.
+// The primary key makes the reservation atomic across the instances sharing the
+// database
+func (s *SQLiteStore) ReserveSeedCounter(seedId []byte, from uint64) (uint64, error) {
+	_, err := s.db.Exec(`DELETE FROM seed_counters WHERE seed_id = ? AND created_at < ?`,
+		seedId, s.reservedBefore())
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove expired seed counters: %v", err)
+	}
+	for counter := from; ; counter++ {
+		res, err := s.db.Exec(`INSERT OR IGNORE INTO seed_counters VALUES (?, ?, ?)`,
+			seedId, counter, time.Now().Unix())
+		if err != nil {
+			return 0, fmt.Errorf("failed to reserve seed counter: %v", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return 0, fmt.Errorf("failed to reserve seed counter: %v", err)
+		} else if n == 1 {
+			return counter, nil
+		}
+	}
+}
+
+// put stores a session in the table, checking the store capacity
+func (s *SQLiteStore) put(table string, key any, data []byte) error {
+	if s.opts.Capacity > 0 {
+		full, err := s.full()
+		if err != nil {
+			return err
+		}
+		if full {
+			s.cleanup()
+			if full, err = s.full(); err != nil {
+				return err
+			} else if full {
+				return ErrStoreFull
+			}
+		}
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO `+table+` VALUES (?, ?, ?)`,
+		key, data, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to store session: %v", err)
+	}
+	return nil
+}
+
+// Close stops the cleanup goroutine and closes the database
+func (s *SQLiteStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.db.Close()
+	})
+	return err
+}
+
+// startCleanup periodically removes expired sessions until the store is closed
+func (s *SQLiteStore) startCleanup() {
+	ticker := time.NewTicker(s.opts.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.cleanup()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// cleanup removes the expired sessions and seed counter reservations
+func (s *SQLiteStore) cleanup() {
+	for _, table := range []string{"deposit_sessions", "withdrawal_sessions"} {
+		_, err := s.db.Exec("DELETE FROM "+table+" WHERE created_at < ?", s.expiredBefore())
+		if err != nil {
+			log.Printf("Error cleaning up %s: %v", table, err)
+		}
+	}
+	_, err := s.db.Exec("DELETE FROM seed_counters WHERE created_at < ?", s.reservedBefore())
+	if err != nil {
+		log.Printf("Error cleaning up seed_counters: %v", err)
+	}
+}
+
+// full returns true if the store has reached its capacity
+func (s *SQLiteStore) full() (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM deposit_sessions) +
+		(SELECT COUNT(*) FROM withdrawal_sessions)`).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to count sessions: %v", err)
+	}
+	return count >= s.opts.Capacity, nil
+}
+
+// expiredBefore returns the unix time before which sessions are expired
+func (s *SQLiteStore) expiredBefore() int64 {
+	return time.Now().Add(-s.opts.TTL).Unix()
+}
+
+// reservedBefore returns the unix time before which seed counter reservations are
+// expired
+func (s *SQLiteStore) reservedBefore() int64 {
+	return time.Now().Add(-2 * s.opts.TTL).Unix()
+}
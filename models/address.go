@@ -1,18 +1,20 @@
 package models
 
-import "github.com/giuliop/HermesVault-frontend/config"
+// numCharsToHighlight is the number of characters to highlight displaying long
+// strings, e.g. addresses
+const numCharsToHighlight = 5
 
 // Address represents a valid Algorand address
 type Address string
 
 func (a Address) Start() string {
-	return splitEnds(string(a), config.NumCharsToHighlight, Start)
+	return splitEnds(string(a), numCharsToHighlight, Start)
 }
 func (a Address) Middle() string {
-	return splitEnds(string(a), config.NumCharsToHighlight, Middle)
+	return splitEnds(string(a), numCharsToHighlight, Middle)
 }
 func (a Address) End() string {
-	return splitEnds(string(a), config.NumCharsToHighlight, End)
+	return splitEnds(string(a), numCharsToHighlight, End)
 }
 
 type part int
@@ -1,10 +1,10 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"math/bits"
 	"strings"
-
-	"github.com/giuliop/HermesVault-frontend/config"
 )
 
 // Amount represent an algo token amount
@@ -13,20 +13,12 @@ type Amount struct {
 	Microalgos uint64
 }
 
-// Fee calculates the fee for a withdrawal amount
-func (withdrawalAmount *Amount) Fee() Amount {
-	fee := CalculateFee(withdrawalAmount.Microalgos)
-	return Amount{
-		Algostring: MicroAlgosToAlgoString(fee),
-		Microalgos: fee,
-	}
-}
-
-// CalculateFee calculates the fee for a given amount; the fee is 0.1% of the amount with a minimum of 1000 microalgos
-func CalculateFee(amount uint64) uint64 {
-	fee := amount / config.WithDrawalFeeDivisor
-	if fee < config.WithdrawalMinimumFee {
-		fee = config.WithdrawalMinimumFee
+// Fee calculates the fee for a given amount, a fraction of the amount with a
+// minimum fee
+func (p ProtocolParams) Fee(amount uint64) uint64 {
+	fee := amount / p.WithdrawalFeeDivisor
+	if fee < p.WithdrawalMinimumFee {
+		fee = p.WithdrawalMinimumFee
 	}
 	return fee
 }
@@ -43,3 +35,104 @@ func MicroAlgosToAlgoString(microalgos uint64) string {
 	}
 	return s
 }
+
+// ErrAmountOverflow is returned when an amount does not fit in a uint64
+var ErrAmountOverflow = errors.New("amount too large")
+
+// ErrInsufficientBalance is returned when a withdrawal and its fee exceed the
+// amount in the note
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// NewAmount returns the Amount for the given microalgos
+func NewAmount(microalgos uint64) Amount {
+	return Amount{
+		Algostring: MicroAlgosToAlgoString(microalgos),
+		Microalgos: microalgos,
+	}
+}
+
+// Add returns a + b, or ErrAmountOverflow if the sum overflows
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum, carry := bits.Add64(a.Microalgos, b.Microalgos, 0)
+	if carry != 0 {
+		return Amount{}, ErrAmountOverflow
+	}
+	return NewAmount(sum), nil
+}
+
+// Sub returns a - b, or ErrInsufficientBalance if b is larger than a
+func (a Amount) Sub(b Amount) (Amount, error) {
+	diff, borrow := bits.Sub64(a.Microalgos, b.Microalgos, 0)
+	if borrow != 0 {
+		return Amount{}, fmt.Errorf("%w: %s algo is more than %s algo",
+			ErrInsufficientBalance, b.Algostring, a.Algostring)
+	}
+	return NewAmount(diff), nil
+}
+
+// WithdrawalQuote is what can be withdrawn from a note
+type WithdrawalQuote struct {
+	Balance         Amount // amount in the note
+	MaxWithdrawable Amount // largest withdrawal whose amount plus fee fits the balance
+	MaxFee          Amount // fee of the largest withdrawal
+}
+
+// QuoteWithdrawal returns the withdrawal quote for a note
+func (p ProtocolParams) QuoteWithdrawal(note *Note) WithdrawalQuote {
+	max := p.maxWithdrawable(note.Amount)
+	fee := p.Fee(max)
+	if max == 0 {
+		fee = 0
+	}
+	return WithdrawalQuote{
+		Balance:         NewAmount(note.Amount),
+		MaxWithdrawable: NewAmount(max),
+		MaxFee:          NewAmount(fee),
+	}
+}
+
+// ChangeAmount returns the amount left in the note after withdrawing the given
+// amount and paying its fee. It returns an error wrapping ErrInsufficientBalance
+// if the note does not hold enough
+func (p ProtocolParams) ChangeAmount(withdrawalAmount Amount, fromNote *Note,
+) (Amount, error) {
+	total, err := withdrawalAmount.Add(NewAmount(p.Fee(withdrawalAmount.Microalgos)))
+	if err != nil {
+		return Amount{}, err
+	}
+	change, err := NewAmount(fromNote.Amount).Sub(total)
+	if err != nil {
+		return Amount{}, fmt.Errorf("%w: the note holds %s algo, at most %s algo can be withdrawn",
+			ErrInsufficientBalance, MicroAlgosToAlgoString(fromNote.Amount),
+			MicroAlgosToAlgoString(p.maxWithdrawable(fromNote.Amount)))
+	}
+	return change, nil
+}
+
+// maxWithdrawable returns the largest amount w such that w plus its fee is not
+// more than balance
+func (p ProtocolParams) maxWithdrawable(balance uint64) uint64 {
+	if balance <= p.WithdrawalMinimumFee {
+		return 0
+	}
+	// with the minimum fee the whole balance minus the fee can be withdrawn
+	if w := balance - p.WithdrawalMinimumFee; p.Fee(w) == p.WithdrawalMinimumFee {
+		return w
+	}
+	// otherwise w + w/divisor <= balance, so w is about balance*divisor/(divisor+1)
+	hi, lo := bits.Mul64(balance, p.WithdrawalFeeDivisor)
+	w, _ := bits.Div64(hi, lo, p.WithdrawalFeeDivisor+1)
+	for w > 0 && !p.fitsBalance(w, balance) {
+		w--
+	}
+	for w < balance && p.fitsBalance(w+1, balance) {
+		w++
+	}
+	return w
+}
+
+// fitsBalance returns true if the withdrawal amount plus its fee is not more
+// than balance
+func (p ProtocolParams) fitsBalance(amount, balance uint64) bool {
+	return amount <= balance && p.Fee(amount) <= balance-amount
+}
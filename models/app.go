@@ -23,6 +23,10 @@ type App struct {
 	DepositVerifier    *Lsig
 	WithdrawalVerifier *Lsig
 	TreeConfig         TreeConfig
+
+	// optional, nil if the app setup does not include the association set circuit
+	AssociationWithdrawalCc       *algoplonk.CompiledCircuit
+	AssociationWithdrawalVerifier *Lsig
 }
 
 type Lsig struct {
@@ -46,5 +50,16 @@ type Arc32Schema struct {
 			NumUints      uint64 `json:"num_uints"`
 		} `json:"local"`
 	} `json:"state"`
+	Schema struct {
+		Global struct {
+			Declared map[string]Arc32StateKey `json:"declared"`
+		} `json:"global"`
+	} `json:"schema"`
 	Contract abi.Contract `json:"contract"`
 }
+
+// Arc32StateKey defines a declared state key of an ARC32 schema
+type Arc32StateKey struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
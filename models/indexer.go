@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// IndexerStatus is how far the subscriber service indexing the app txns is behind
+// the chain, as last checked
+type IndexerStatus struct {
+	Watermark uint64    `json:"watermark"`  // last round processed by the subscriber
+	LastRound uint64    `json:"last_round"` // last round seen by algod
+	CheckedAt time.Time `json:"checked_at"` // zero if never checked
+	Error     string    `json:"error,omitempty"`
+}
+
+// IndexerLimits are the thresholds of the subscriber lag, in rounds, and how
+// often it is checked
+type IndexerLimits struct {
+	WarnRounds    uint64        // lag above which recent txns may not be visible
+	MaxRounds     uint64        // lag above which withdrawals are blocked
+	CheckInterval time.Duration // interval between the checks
+}
+
+// Lag returns the number of rounds the subscriber is behind algod
+func (s IndexerStatus) Lag() uint64 {
+	if s.LastRound <= s.Watermark {
+		return 0
+	}
+	return s.LastRound - s.Watermark
+}
+
+// Behind returns true if the subscriber is far enough behind that recent txns may
+// not be visible yet
+func (s IndexerStatus) Behind(l IndexerLimits) bool {
+	return s.Lag() > l.WarnRounds
+}
+
+// indexerStatusMaxChecks is the number of check intervals after which a status
+// not refreshed is stale
+const indexerStatusMaxChecks = 3
+
+// Stale returns true if the subscriber is too far behind to build withdrawals
+// against its data, or if its lag is unknown: never checked, the last check
+// failed or the checks stopped
+func (s IndexerStatus) Stale(l IndexerLimits) bool {
+	return s.Error != "" || s.CheckedAt.IsZero() ||
+		time.Since(s.CheckedAt) > indexerStatusMaxChecks*l.CheckInterval ||
+		s.Lag() > l.MaxRounds
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIndexerStatusStale(t *testing.T) {
+	now := time.Now()
+	limits := IndexerLimits{WarnRounds: 10, MaxRounds: 100, CheckInterval: time.Minute}
+	tests := []struct {
+		name   string
+		status IndexerStatus
+		stale  bool
+	}{
+		{"fresh", IndexerStatus{Watermark: 100, LastRound: 101, CheckedAt: now}, false},
+		{"never checked", IndexerStatus{Watermark: 100, LastRound: 101}, true},
+		{"check failed", IndexerStatus{Watermark: 100, LastRound: 101, CheckedAt: now,
+			Error: "algod unreachable"}, true},
+		{"checks stopped", IndexerStatus{Watermark: 100, LastRound: 101,
+			CheckedAt: now.Add(-(indexerStatusMaxChecks + 1) * limits.CheckInterval)},
+			true},
+		{"too far behind", IndexerStatus{Watermark: 100,
+			LastRound: 101 + limits.MaxRounds, CheckedAt: now}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.status.Stale(limits); got != tt.stale {
+			t.Errorf("%s: Stale() = %v, want %v", tt.name, got, tt.stale)
+		}
+	}
+}
@@ -5,10 +5,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/bits"
 	"strconv"
 	"strings"
 
-	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/constants"
 
 	"github.com/algorand/go-algorand-sdk/v2/types"
 )
@@ -36,11 +37,12 @@ func (input Input) ToAmount() (Amount, error) {
 			return Amount{}, fmt.Errorf("invalid decimal part: %w", err)
 		}
 	}
-	microalgos := integer*1_000_000 + decimal
-	return Amount{
-		Algostring: MicroAlgosToAlgoString(microalgos),
-		Microalgos: microalgos,
-	}, nil
+	hi, whole := bits.Mul64(integer, 1_000_000)
+	microalgos, carry := bits.Add64(whole, decimal, 0)
+	if hi != 0 || carry != 0 {
+		return Amount{}, ErrAmountOverflow
+	}
+	return NewAmount(microalgos), nil
 }
 
 // toAddress converts an input to an Address
@@ -53,15 +55,48 @@ func (input Input) ToAddress() (Address, error) {
 }
 
 // toNote converts an input to a Note
+// Input is expected to be a note encoded by EncodeNote for the network and app of
+// this frontend, or a legacy note
+func (input Input) ToNote() (*Note, error) {
+	return ParseNoteFor(string(input), noteBinding)
+}
+
+// ParseNoteFor parses a note in either the current or the legacy format, checking
+// that a current note is bound to the given network and app
+func ParseNoteFor(s string, b NoteBinding) (*Note, error) {
+	note, binding, err := ParseNote(s)
+	if err != nil {
+		return nil, err
+	}
+	if binding != (NoteBinding{}) {
+		if err := b.check(binding); err != nil {
+			return nil, err
+		}
+	}
+	return note, nil
+}
+
+// ParseNote parses a note in either the current or the legacy format without
+// checking its binding. Legacy notes are returned with an empty binding
+func ParseNote(s string) (*Note, NoteBinding, error) {
+	s = strings.TrimSpace(s)
+	if isLegacyNote(s) {
+		note, err := Input(s).legacyToNote()
+		return note, NoteBinding{}, err
+	}
+	return DecodeNote(s)
+}
+
+// legacyToNote converts a legacy input to a Note
 // Input is expected to be a hex-encoded string of 70 bytes (140 hex characters),
 // 8 bytes for the amount, 31 bytes for K, 31 bytes for R
 // 31 bytes is given from the RandomNonceByteSize constant in package config
-func (input Input) ToNote() (*Note, error) {
+func (input Input) legacyToNote() (*Note, error) {
 	amountByteSize := 8
-	nonceByteSize := config.RandomNonceByteSize
+	nonceByteSize := constants.RandomNonceByteSize
 	amountAndNonceSize := amountByteSize + nonceByteSize
 
-	if len(input) != 140 {
+	if len(input) != legacyNoteLength {
 		return nil, errors.New("invalid secret note length")
 	}
 	decoded, err := hex.DecodeString(string(input))
@@ -69,7 +104,7 @@ func (input Input) ToNote() (*Note, error) {
 		return nil, fmt.Errorf("error decoding hex string: %v", err)
 	}
 	amount := decoded[:amountByteSize]
-	var k, r [config.RandomNonceByteSize]byte
+	var k, r [constants.RandomNonceByteSize]byte
 	copy(k[:], decoded[amountByteSize:amountAndNonceSize])
 	copy(r[:], decoded[amountAndNonceSize:])
 	return &Note{
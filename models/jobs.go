@@ -0,0 +1,29 @@
+package models
+
+// JobStage is a step of a deposit or withdrawal running as a job
+type JobStage string
+
+const (
+	JobQueued        JobStage = "queued"
+	JobBuildingProof JobStage = "building_tree_proof" // withdrawals only
+	JobProving       JobStage = "proving"             // withdrawals only
+	JobSubmitted     JobStage = "submitted"
+	JobConfirmed     JobStage = "confirmed"
+	JobFailed        JobStage = "failed"
+)
+
+// Final returns true if no stage follows s
+func (s JobStage) Final() bool {
+	return s == JobConfirmed || s == JobFailed
+}
+
+// JobEvent is the progress of a job
+type JobEvent struct {
+	Seq       int // position of the event in the job, from 1
+	Stage     JobStage
+	TxnID     string // set from the submitted stage
+	Round     uint64 // set in the confirmed stage
+	LeafIndex int    // leaf index of the new note, set in the confirmed stage
+	Error     string // user facing message, set in the failed stage
+	Status    int    // HTTP status of the error
+}
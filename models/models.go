@@ -21,6 +21,14 @@ type WithdrawalData struct {
 	Address    Address
 	FromNote   *Note
 	ChangeNote *Note
+
+	// AssociationSet is the name of the association set policy to prove the FromNote
+	// belongs to, empty for a withdrawal without an association set proof
+	AssociationSet string
+
+	// SessionId is the opaque id of the server-side session holding the withdrawal
+	// between its quote and its confirmation
+	SessionId string
 }
 
 type DepositData struct {
@@ -5,15 +5,19 @@ import (
 	"encoding/binary"
 	"fmt"
 
-	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/constants"
 )
 
 type Note struct {
 	Amount    uint64
-	K         [config.RandomNonceByteSize]byte
-	R         [config.RandomNonceByteSize]byte
+	K         [constants.RandomNonceByteSize]byte
+	R         [constants.RandomNonceByteSize]byte
 	LeafIndex int
 	TxnID     string
+	// Seeded notes have their nonces derived from a user seed with Counter, see
+	// NoteSeed
+	Seeded  bool
+	Counter uint64
 }
 
 // GenerateNote generates a new note for a given amount
@@ -31,32 +35,44 @@ func GenerateNote(amount uint64) (*Note, error) {
 	}, nil
 }
 
+// Text returns the note encoded for the network and app of this frontend
 func (n *Note) Text() string {
+	return EncodeNote(n, noteBinding)
+}
+
+// LegacyText returns the legacy hex encoding of the note, with no network and
+// app binding and no checksum
+func (n *Note) LegacyText() string {
 	return fmt.Sprintf("%016x%x%x", n.Amount, n.K, n.R)
 }
 
 func (n *Note) Nullifier() []byte {
 	k32Byte := append([]byte{0}, n.K[:]...)
-	return config.Hash(uint64ToBytes32(n.Amount), k32Byte)
+	return constants.Hash(uint64ToBytes32(n.Amount), k32Byte)
 }
 
 func (n *Note) Commitment() []byte {
-	return config.Hash(n.LeafValue())
+	return constants.Hash(n.LeafValue())
 }
 
 func (n *Note) LeafValue() []byte {
 	ab := uint64ToBytes32(n.Amount)
 	k32Byte := append([]byte{0}, n.K[:]...)
 	r32Byte := append([]byte{0}, n.R[:]...)
-	h := config.Hash(ab, k32Byte, r32Byte)
+	h := constants.Hash(ab, k32Byte, r32Byte)
 	return h
 }
 
-// generateDepositNote generates a new deposit note for the change amount after a withdrawal
-func GenerateChangeNote(withdrawalAmount Amount, fromNote *Note) (*Note, error) {
-	change := (fromNote.Amount - withdrawalAmount.Microalgos -
-		CalculateFee(withdrawalAmount.Microalgos))
-	note, err := GenerateNote(change)
+// GenerateChangeNote generates the change note after a withdrawal with the given
+// protocol parameters, with random nonces or with the nonces derived from seed if
+// not nil
+func GenerateChangeNote(params ProtocolParams, withdrawalAmount Amount, fromNote *Note,
+	seed *NoteSeed) (*Note, error) {
+	change, err := params.ChangeAmount(withdrawalAmount, fromNote)
+	if err != nil {
+		return nil, err
+	}
+	note, err := NewNote(change.Microalgos, seed)
 	if err != nil {
 		return nil, fmt.Errorf("error generating note: %v", err)
 	}
@@ -64,12 +80,12 @@ func GenerateChangeNote(withdrawalAmount Amount, fromNote *Note) (*Note, error)
 }
 
 // generateRandomNonce generates a cryptographically secure byte array of size
-// config.RandomNonceByteSize
-func generateRandomNonce() ([config.RandomNonceByteSize]byte, error) {
-	var arr [config.RandomNonceByteSize]byte
+// constants.RandomNonceByteSize
+func generateRandomNonce() ([constants.RandomNonceByteSize]byte, error) {
+	var arr [constants.RandomNonceByteSize]byte
 	_, err := rand.Read(arr[:])
 	if err != nil {
-		return [config.RandomNonceByteSize]byte{},
+		return [constants.RandomNonceByteSize]byte{},
 			fmt.Errorf("failed to generate random bytes: %w", err)
 	}
 	return arr, nil
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+)
+
+// Notes are encoded as bech32m strings: a human readable prefix, the separator "1",
+// the payload in base32 and a 6 character checksum. The checksum catches typos
+// before a note is used. The payload is:
+//
+//	version (1 byte) | network (1 byte) | app id (8 bytes) |
+//	amount (8 bytes) | K (31 bytes) | R (31 bytes)
+//
+// A note is 141 characters long, past the 90 up to which bech32m guarantees to
+// catch any 4 typos: a single typo or swap of two adjacent characters is still
+// always caught, as the tests check, but several typos go unnoticed with a
+// probability of about 1 in 10^9.
+//
+// Legacy notes are the hex encoding of amount | K | R and are still accepted
+const (
+	NoteHRP     = "hermes"
+	NoteVersion = 2
+
+	noteSeparator   = '1'
+	noteCharset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	noteChecksumLen = 6
+	bech32mConst    = 0x2bc830a3
+
+	notePayloadSize  = 1 + 1 + 8 + 8 + 2*constants.RandomNonceByteSize
+	legacyNoteLength = 2 * (8 + 2*constants.RandomNonceByteSize)
+)
+
+var (
+	ErrNoteChecksum     = errors.New("the note has a typo (checksum mismatch)")
+	ErrNoteVersion      = errors.New("unsupported note version")
+	ErrNoteWrongNetwork = errors.New("the note belongs to a different network")
+	ErrNoteWrongApp     = errors.New("the note belongs to a different app")
+)
+
+// Network identifies the Algorand network a note belongs to
+type Network byte
+
+const (
+	UnknownNetwork Network = iota
+	MainNet
+	TestNet
+	LocalNet
+)
+
+func (n Network) String() string {
+	switch n {
+	case MainNet:
+		return "mainnet"
+	case TestNet:
+		return "testnet"
+	case LocalNet:
+		return "localnet"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseNetwork returns the Network with the given name
+func ParseNetwork(name string) (Network, error) {
+	switch strings.ToLower(name) {
+	case "mainnet":
+		return MainNet, nil
+	case "testnet":
+		return TestNet, nil
+	case "localnet", "devnet":
+		return LocalNet, nil
+	default:
+		return UnknownNetwork, fmt.Errorf("unknown network: %s", name)
+	}
+}
+
+// NoteBinding is the network and app a note belongs to
+type NoteBinding struct {
+	Network Network
+	AppId   uint64
+}
+
+// noteBinding is the binding of the notes created and accepted by this frontend
+var noteBinding NoteBinding
+
+// SetNoteBinding sets the network and app of the notes created and accepted by
+// this frontend. It must be called before any note is encoded or decoded
+func SetNoteBinding(b NoteBinding) {
+	noteBinding = b
+}
+
+// GetNoteBinding returns the network and app of the notes created and accepted by
+// this frontend
+func GetNoteBinding() NoteBinding {
+	return noteBinding
+}
+
+// EncodeNote encodes the note for the given network and app
+func EncodeNote(n *Note, b NoteBinding) string {
+	payload := make([]byte, 0, notePayloadSize)
+	payload = append(payload, NoteVersion, byte(b.Network))
+	payload = binary.BigEndian.AppendUint64(payload, b.AppId)
+	payload = binary.BigEndian.AppendUint64(payload, n.Amount)
+	payload = append(payload, n.K[:]...)
+	payload = append(payload, n.R[:]...)
+
+	data := convertBits(payload, 8, 5, true)
+	data = append(data, bech32mChecksum(NoteHRP, data)...)
+
+	var sb strings.Builder
+	sb.Grow(len(NoteHRP) + 1 + len(data))
+	sb.WriteString(NoteHRP)
+	sb.WriteByte(noteSeparator)
+	for _, d := range data {
+		sb.WriteByte(noteCharset[d])
+	}
+	return sb.String()
+}
+
+// DecodeNote decodes an encoded note returning the note and its binding.
+// It returns ErrNoteChecksum if the note has a typo
+func DecodeNote(s string) (*Note, NoteBinding, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return nil, NoteBinding{}, errors.New("the note mixes upper and lower case")
+	}
+	s = strings.ToLower(s)
+	prefix := NoteHRP + string(noteSeparator)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, NoteBinding{}, fmt.Errorf("the note does not start with %s", prefix)
+	}
+	encoded := s[len(prefix):]
+	if len(encoded) < noteChecksumLen {
+		return nil, NoteBinding{}, errors.New("invalid secret note length")
+	}
+	data := make([]byte, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		d := strings.IndexByte(noteCharset, encoded[i])
+		if d == -1 {
+			return nil, NoteBinding{}, fmt.Errorf(
+				"invalid character '%c' at position %d", encoded[i], len(prefix)+i+1)
+		}
+		data[i] = byte(d)
+	}
+	if bech32Polymod(append(hrpExpand(NoteHRP), data...)) != bech32mConst {
+		return nil, NoteBinding{}, ErrNoteChecksum
+	}
+	payload, err := convertBitsStrict(data[:len(data)-noteChecksumLen])
+	if err != nil {
+		return nil, NoteBinding{}, err
+	}
+	if len(payload) < 1 || payload[0] != NoteVersion {
+		return nil, NoteBinding{}, ErrNoteVersion
+	}
+	if len(payload) != notePayloadSize {
+		return nil, NoteBinding{}, errors.New("invalid secret note length")
+	}
+	b := NoteBinding{
+		Network: Network(payload[1]),
+		AppId:   binary.BigEndian.Uint64(payload[2:10]),
+	}
+	n := &Note{Amount: binary.BigEndian.Uint64(payload[10:18])}
+	copy(n.K[:], payload[18:18+constants.RandomNonceByteSize])
+	copy(n.R[:], payload[18+constants.RandomNonceByteSize:])
+	return n, b, nil
+}
+
+// isLegacyNote returns true if s looks like a legacy hex encoded note
+func isLegacyNote(s string) bool {
+	return !strings.HasPrefix(strings.ToLower(s), NoteHRP+string(noteSeparator))
+}
+
+// check returns an error if the binding of a note does not match b
+func (b NoteBinding) check(got NoteBinding) error {
+	if got.Network != b.Network {
+		return fmt.Errorf("%w (%s)", ErrNoteWrongNetwork, got.Network)
+	}
+	if got.AppId != b.AppId {
+		return fmt.Errorf("%w (%d)", ErrNoteWrongApp, got.AppId)
+	}
+	return nil
+}
+
+// hrpExpand expands the human readable part for the checksum computation
+func hrpExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// bech32Polymod computes the bech32 checksum polynomial modulus
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32mChecksum returns the 6 checksum values for the hrp and data
+func bech32mChecksum(hrp string, data []byte) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, make([]byte, noteChecksumLen)...)
+	polymod := bech32Polymod(values) ^ bech32mConst
+	checksum := make([]byte, noteChecksumLen)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// convertBits regroups the bits of data from groups of fromBits to groups of toBits
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, b := range data {
+		acc = acc<<fromBits | uint(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad && bits > 0 {
+		out = append(out, byte(acc<<(toBits-bits)&maxv))
+	}
+	return out
+}
+
+// convertBitsStrict regroups 5 bit groups into bytes, checking the padding
+func convertBitsStrict(data []byte) ([]byte, error) {
+	out := convertBits(data, 5, 8, false)
+	if padBits := len(data) * 5 % 8; padBits >= 5 ||
+		(padBits > 0 && data[len(data)-1]&(1<<padBits-1) != 0) {
+		return nil, errors.New("invalid note padding")
+	}
+	return out, nil
+}
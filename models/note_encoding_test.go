@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testBinding = NoteBinding{Network: TestNet, AppId: 123456789}
+
+func testNote(t *testing.T) *Note {
+	t.Helper()
+	n, err := GenerateNote(1_234_567)
+	if err != nil {
+		t.Fatalf("GenerateNote: %v", err)
+	}
+	return n
+}
+
+func sameSecrets(a, b *Note) bool {
+	return a.Amount == b.Amount && a.K == b.K && a.R == b.R
+}
+
+func TestEncodeNoteRoundTrip(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		n := testNote(t)
+		s := EncodeNote(n, testBinding)
+		if !strings.HasPrefix(s, NoteHRP+"1") {
+			t.Fatalf("note %q does not start with %s1", s, NoteHRP)
+		}
+		for _, text := range []string{s, strings.ToUpper(s), "  " + s + "\n"} {
+			got, b, err := ParseNote(text)
+			if err != nil {
+				t.Fatalf("ParseNote(%q): %v", text, err)
+			}
+			if !sameSecrets(got, n) || b != testBinding {
+				t.Fatalf("ParseNote(%q) = %v %v, want %v %v", text, got, b, n, testBinding)
+			}
+		}
+	}
+}
+
+func TestDecodeNoteDetectsSubstitutions(t *testing.T) {
+	s := EncodeNote(testNote(t), testBinding)
+	start := len(NoteHRP) + 1
+	for i := start; i < len(s); i++ {
+		for _, c := range noteCharset {
+			if byte(c) == s[i] {
+				continue
+			}
+			typo := s[:i] + string(c) + s[i+1:]
+			if _, _, err := DecodeNote(typo); err == nil {
+				t.Fatalf("substitution at %d not detected: %q", i, typo)
+			}
+		}
+	}
+}
+
+func TestDecodeNoteDetectsTranspositions(t *testing.T) {
+	s := EncodeNote(testNote(t), testBinding)
+	for i := len(NoteHRP) + 1; i < len(s)-1; i++ {
+		if s[i] == s[i+1] {
+			continue
+		}
+		typo := s[:i] + string(s[i+1]) + string(s[i]) + s[i+2:]
+		if _, _, err := DecodeNote(typo); !errors.Is(err, ErrNoteChecksum) {
+			t.Fatalf("transposition at %d: got %v, want %v", i, err, ErrNoteChecksum)
+		}
+	}
+}
+
+func TestDecodeNoteRejects(t *testing.T) {
+	s := EncodeNote(testNote(t), testBinding)
+	tests := []struct {
+		name string
+		note string
+	}{
+		{"mixed case", strings.ToUpper(s[:10]) + s[10:]},
+		{"truncated", s[:len(s)-1]},
+		{"extended", s + "q"},
+		{"invalid character", s[:20] + "b" + s[21:]},
+		{"wrong prefix", "hermez" + s[len(NoteHRP):]},
+	}
+	for _, tt := range tests {
+		if _, _, err := DecodeNote(tt.note); err == nil {
+			t.Errorf("%s: DecodeNote(%q) succeeded", tt.name, tt.note)
+		}
+	}
+}
+
+func TestParseNoteForBinding(t *testing.T) {
+	n := testNote(t)
+	s := EncodeNote(n, testBinding)
+	if _, err := ParseNoteFor(s, testBinding); err != nil {
+		t.Fatalf("ParseNoteFor: %v", err)
+	}
+	otherNet := NoteBinding{Network: MainNet, AppId: testBinding.AppId}
+	if _, err := ParseNoteFor(s, otherNet); !errors.Is(err, ErrNoteWrongNetwork) {
+		t.Errorf("got %v, want %v", err, ErrNoteWrongNetwork)
+	}
+	otherApp := NoteBinding{Network: testBinding.Network, AppId: 1}
+	if _, err := ParseNoteFor(s, otherApp); !errors.Is(err, ErrNoteWrongApp) {
+		t.Errorf("got %v, want %v", err, ErrNoteWrongApp)
+	}
+}
+
+func TestLegacyNote(t *testing.T) {
+	n := testNote(t)
+	legacy := n.LegacyText()
+	if len(legacy) != legacyNoteLength {
+		t.Fatalf("legacy note length %d, want %d", len(legacy), legacyNoteLength)
+	}
+	got, b, err := ParseNote(legacy)
+	if err != nil {
+		t.Fatalf("ParseNote(legacy): %v", err)
+	}
+	if !sameSecrets(got, n) || b != (NoteBinding{}) {
+		t.Fatalf("ParseNote(legacy) = %v %v, want %v and no binding", got, b, n)
+	}
+	// legacy notes are accepted whatever the binding, they carry none
+	if _, err := ParseNoteFor(legacy, testBinding); err != nil {
+		t.Fatalf("ParseNoteFor(legacy): %v", err)
+	}
+	for _, bad := range []string{legacy[:len(legacy)-2], legacy + "00",
+		"zz" + legacy[2:]} {
+		if _, _, err := ParseNote(bad); err == nil {
+			t.Errorf("ParseNote(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+)
+
+// ProtocolParams are the amounts and fees enforced by the app contract
+type ProtocolParams struct {
+	DepositMinimumAmount uint64 `json:"deposit_minimum_amount"`
+	WithdrawalFeeDivisor uint64 `json:"withdrawal_fee_divisor"`
+	WithdrawalMinimumFee uint64 `json:"withdrawal_minimum_fee"`
+}
+
+// DefaultProtocolParams are the compiled-in protocol parameters, used until the
+// on-chain ones are read and for any parameter the contract does not expose
+var DefaultProtocolParams = ProtocolParams{
+	DepositMinimumAmount: constants.DepositMinimumAmount,
+	WithdrawalFeeDivisor: constants.WithDrawalFeeDivisor,
+	WithdrawalMinimumFee: constants.WithdrawalMinimumFee,
+}
+
+// Validate returns an error if the parameters cannot be used to compute fees
+func (p ProtocolParams) Validate() error {
+	if p.WithdrawalFeeDivisor == 0 {
+		return fmt.Errorf("withdrawal fee divisor cannot be zero")
+	}
+	return nil
+}
+
+// FeePercent returns the withdrawal fee as a percentage string, e.g. "0.1"
+func (p ProtocolParams) FeePercent() string {
+	// 100 / divisor percent, expressed with up to 6 decimals
+	return MicroAlgosToAlgoString(100_000_000 / p.WithdrawalFeeDivisor)
+}
@@ -0,0 +1,10 @@
+package models
+
+// Receipt links a withdrawal to the note it spent, without any note secret, so that
+// a withdrawal can be traced back to its deposit if so compelled by law
+type Receipt struct {
+	WithdrawalTxnID  string // id of the first txn of the withdrawal group
+	Nullifier        []byte // nullifier of the spent note
+	SpentCommitment  []byte // commitment of the spent note
+	ChangeCommitment []byte // commitment of the change note inserted by the withdrawal
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// The secret nonces of the notes, the seeds they are derived from, the links of
+// the receipts and the signatures of the signed txns must never reach the logs:
+// the types holding them log and print themselves redacted
+
+// redacted replaces a secret in the logs
+const redacted = "[REDACTED]"
+
+// LogValue logs the note without its secret nonces
+func (n Note) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Uint64("amount", n.Amount),
+		slog.Int("leaf_index", n.LeafIndex),
+		slog.String("txn_id", n.TxnID),
+		slog.String("secret", redacted),
+	)
+}
+
+// String prints the note without its secret nonces, so that the fmt verbs do not
+// leak them
+func (n Note) String() string {
+	return fmt.Sprintf("Note{Amount:%d LeafIndex:%d TxnID:%s K:%s R:%s}",
+		n.Amount, n.LeafIndex, n.TxnID, redacted, redacted)
+}
+
+// GoString prints the note for the %#v verb without its secret nonces
+func (n Note) GoString() string {
+	return n.String()
+}
+
+// LogValue logs the deposit with its note redacted
+func (d DepositData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("amount", d.Amount.Algostring),
+		slog.String("address", string(d.Address)),
+		slog.Any("note", d.Note),
+		slog.Int("txns", len(d.Txns)),
+	)
+}
+
+// LogValue logs the withdrawal with its notes redacted
+func (w WithdrawalData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("amount", w.Amount.Algostring),
+		slog.String("fee", w.Fee.Algostring),
+		slog.String("address", string(w.Address)),
+		slog.Any("from_note", w.FromNote),
+		slog.Any("change_note", w.ChangeNote),
+		slog.String("association_set", w.AssociationSet),
+	)
+}
+
+// LogValue logs the seed redacted
+func (s Seed) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// String prints the seed redacted
+func (s Seed) String() string {
+	return redacted
+}
+
+// GoString prints the seed redacted for the %#v verb
+func (s Seed) GoString() string {
+	return redacted
+}
+
+// Format prints the seed redacted with any verb, also the %x and %d ones that
+// would print the bytes of an array
+func (s Seed) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+// LogValue logs the note seed with its seed redacted
+func (s NoteSeed) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("seed", redacted),
+		slog.Uint64("counter", s.Counter),
+	)
+}
+
+// String prints the note seed with its seed redacted
+func (s NoteSeed) String() string {
+	return fmt.Sprintf("NoteSeed{Seed:%s Counter:%d}", redacted, s.Counter)
+}
+
+// GoString prints the note seed for the %#v verb with its seed redacted
+func (s NoteSeed) GoString() string {
+	return s.String()
+}
+
+// LogValue logs the receipt as its withdrawal, without the commitments and the
+// nullifier linking it to the spent note
+func (r Receipt) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("withdrawal_txn_id", r.WithdrawalTxnID),
+		slog.String("link", redacted),
+	)
+}
+
+// String prints the receipt as its withdrawal, without the commitments and the
+// nullifier linking it to the spent note
+func (r Receipt) String() string {
+	return fmt.Sprintf("Receipt{WithdrawalTxnID:%s Link:%s}", r.WithdrawalTxnID, redacted)
+}
+
+// GoString prints the receipt for the %#v verb without its link to the spent note
+func (r Receipt) GoString() string {
+	return r.String()
+}
+
+// RedactedSignedTxn is a signed txn that logs and prints itself as its id, type,
+// sender and group, without its signatures
+type RedactedSignedTxn types.SignedTxn
+
+// LogValue logs the signed txn without its signatures
+func (t RedactedSignedTxn) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("txn_id", crypto.GetTxID(t.Txn)),
+		slog.String("type", string(t.Txn.Type)),
+		slog.String("sender", t.Txn.Sender.String()),
+		slog.String("group", base64.StdEncoding.EncodeToString(t.Txn.Group[:])),
+		slog.String("signature", redacted),
+	)
+}
+
+// String prints the signed txn without its signatures
+func (t RedactedSignedTxn) String() string {
+	return fmt.Sprintf("SignedTxn{TxnID:%s Type:%s Sender:%s Sig:%s}",
+		crypto.GetTxID(t.Txn), t.Txn.Type, t.Txn.Sender, redacted)
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+
+	"github.com/algorand/go-algorand-sdk/v2/mnemonic"
+)
+
+// seedDomain separates the note nonces derivation from any other use of the seed
+const seedDomain = "hermesvault-note-nonces"
+
+// seedIdDomain separates the seed id derivation from any other use of the seed
+const seedIdDomain = "hermesvault-seed-id"
+
+// Seed is a user held secret from which notes can be derived deterministically,
+// so that they can be recovered if lost. The note with counter i uses the nonces
+// derived from the seed and i
+type Seed [32]byte
+
+// NewSeed generates a random seed and returns it with its 25 word mnemonic
+func NewSeed() (*Seed, string, error) {
+	var seed Seed
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	phrase, err := mnemonic.FromKey(seed[:])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode seed mnemonic: %w", err)
+	}
+	return &seed, phrase, nil
+}
+
+// SeedFromMnemonic returns the seed encoded by a 25 word mnemonic
+func SeedFromMnemonic(phrase string) (*Seed, error) {
+	key, err := mnemonic.ToKey(phrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed mnemonic: %w", err)
+	}
+	var seed Seed
+	copy(seed[:], key)
+	return &seed, nil
+}
+
+// Nonces derives the K and R nonces of the note with the given counter
+func (s *Seed) Nonces(counter uint64) (k, r [constants.RandomNonceByteSize]byte) {
+	mac := hmac.New(sha512.New, s[:])
+	mac.Write([]byte(seedDomain))
+	mac.Write(binary.BigEndian.AppendUint64(nil, counter))
+	sum := mac.Sum(nil)
+	// 31 bytes nonces are always smaller than the field modulus
+	copy(k[:], sum[:constants.RandomNonceByteSize])
+	copy(r[:], sum[32:32+constants.RandomNonceByteSize])
+	return k, r
+}
+
+// Id returns an identifier of the seed that reveals nothing about it, to keep
+// track of the counters in use without storing the seed
+func (s *Seed) Id() []byte {
+	mac := hmac.New(sha512.New512_256, s[:])
+	mac.Write([]byte(seedIdDomain))
+	return mac.Sum(nil)
+}
+
+// GenerateNoteFromSeed generates the note for a given amount with the nonces
+// derived from the seed and counter
+func GenerateNoteFromSeed(amount uint64, seed *Seed, counter uint64) *Note {
+	k, r := seed.Nonces(counter)
+	return &Note{
+		Amount:  amount,
+		K:       k,
+		R:       r,
+		Seeded:  true,
+		Counter: counter,
+	}
+}
+
+// NoteSeed is the seed and counter to derive the note of a deposit, or the change
+// note of a withdrawal, from. Each counter must be used for one note only: the
+// recovery finds the notes of a seed by trying its counters in order, see
+// recovery.Recover for the next counter to use
+type NoteSeed struct {
+	Seed    *Seed
+	Counter uint64
+}
+
+// NewNote generates a note for a given amount with random nonces, or with the
+// nonces derived from seed if not nil
+func NewNote(amount uint64, seed *NoteSeed) (*Note, error) {
+	if seed == nil {
+		return GenerateNote(amount)
+	}
+	return GenerateNoteFromSeed(amount, seed.Seed, seed.Counter), nil
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ProtocolStats are the public statistics of the protocol. Amounts are in
+// microalgos
+type ProtocolStats struct {
+	// TVL is the value held for the depositors: deposits less withdrawals and fees
+	TVL              uint64 `json:"tvl"`
+	DepositCount     uint64 `json:"deposit_count"`
+	DepositVolume    uint64 `json:"deposit_volume"`
+	WithdrawalCount  uint64 `json:"withdrawal_count"`
+	WithdrawalVolume uint64 `json:"withdrawal_volume"`
+	// Fees are the withdrawal fees accrued by the protocol
+	Fees uint64 `json:"fees"`
+	// LeafCount is the number of notes inserted in the merkle tree
+	LeafCount uint64 `json:"leaf_count"`
+	// AnonymitySetSize is the number of unspent notes, each withdrawal spending one
+	AnonymitySetSize uint64 `json:"anonymity_set_size"`
+	// HistoryByLeaf has the activity in buckets of consecutive leaves, from the
+	// first. The txns carry no round or timestamp, the leaf index is the protocol
+	// clock
+	HistoryByLeaf []StatsBucket `json:"history_by_leaf"`
+	UpdatedAt     time.Time     `json:"updated_at"` // zero if never computed
+}
+
+// StatsBucket is the activity of the txns inserting the leaves FromLeaf to ToLeaf
+type StatsBucket struct {
+	FromLeaf         int    `json:"from_leaf"`
+	ToLeaf           int    `json:"to_leaf"`
+	DepositCount     uint64 `json:"deposit_count"`
+	DepositVolume    uint64 `json:"deposit_volume"`
+	WithdrawalCount  uint64 `json:"withdrawal_count"`
+	WithdrawalVolume uint64 `json:"withdrawal_volume"`
+}
+
+// protocolStats are the last computed protocol statistics
+var protocolStats atomic.Pointer[ProtocolStats]
+
+func init() {
+	SetProtocolStats(ProtocolStats{})
+}
+
+// SetProtocolStats sets the last computed protocol statistics
+func SetProtocolStats(s ProtocolStats) {
+	protocolStats.Store(&s)
+}
+
+// GetProtocolStats returns the last computed protocol statistics. The HistoryByLeaf
+// slice is shared and must not be modified
+func GetProtocolStats() ProtocolStats {
+	return *protocolStats.Load()
+}
@@ -0,0 +1,21 @@
+package models
+
+// TxnType is the type of a protocol transaction recorded by the subscriber service
+type TxnType int
+
+const (
+	DepositTxnType    TxnType = 0
+	WithdrawalTxnType TxnType = 1
+)
+
+// Txn is a deposit or withdrawal transaction as recorded in the txns table,
+// each txn inserts a new leaf in the onchain merkle tree
+type Txn struct {
+	LeafIndex     int
+	Commitment    []byte  // inserted note commitment
+	TxnID         string  // id of txn that inserted the note (1st in group)
+	Type          TxnType // deposit or withdrawal
+	Address       string  // address making the deposit or receiving the withdrawal
+	Amount        uint64  // amount deposited or withdrawn
+	FromNullifier []byte  // spent note nullifier (nil for deposits)
+}
@@ -0,0 +1,140 @@
+// Package protocol builds and sends the HermesVault transactions. It has no
+// global state: the algod client and the app are passed explicitly, so that it can
+// be used by the server through package avm and embedded through package hermes
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SendTxnErrorType represents the type of error sending a transaction
+type SendTxnErrorType int
+
+const (
+	ErrWaitTimeout SendTxnErrorType = iota
+	ErrRejected
+	ErrOverSpend
+	ErrExpired
+	ErrInternal
+	ErrMinimumBalanceRequirement
+)
+
+func (e SendTxnErrorType) String() string {
+	switch e {
+	case ErrWaitTimeout:
+		return "TxnConfirmationTimeoutError"
+	case ErrRejected:
+		return "TxnConfirmationRejectionError"
+	case ErrOverSpend:
+		return "TxnConfirmationOverSpendError"
+	case ErrExpired:
+		return "TxnConfirmationExpiredError"
+	case ErrInternal:
+		return "TxnConfirmationInternalError"
+	case ErrMinimumBalanceRequirement:
+		return "TxnConfirmationMinimumBalanceRequirementError"
+	default:
+		return "TxnConfirmationUnknownError"
+	}
+}
+
+// Code returns the stable error code of the error type, used by the API
+func (e SendTxnErrorType) Code() string {
+	switch e {
+	case ErrWaitTimeout:
+		return "txn_wait_timeout"
+	case ErrRejected:
+		return "txn_rejected"
+	case ErrOverSpend:
+		return "txn_overspend"
+	case ErrExpired:
+		return "txn_expired"
+	case ErrInternal:
+		return "txn_internal"
+	case ErrMinimumBalanceRequirement:
+		return "txn_minimum_balance"
+	default:
+		return "txn_unknown"
+	}
+}
+
+// SendTxnErrorTypes are all the SendTxnErrorType values
+var SendTxnErrorTypes = []SendTxnErrorType{ErrWaitTimeout, ErrRejected, ErrOverSpend,
+	ErrExpired, ErrInternal, ErrMinimumBalanceRequirement}
+
+// TxnConfirmationError represents an error waiting for a txn confirmation
+type TxnConfirmationError struct {
+	Type    SendTxnErrorType // The type of the error
+	Message string           // The original error message
+}
+
+// Implement the Error() method to satisfy the error interface
+func (e *TxnConfirmationError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Type.String(), e.Message)
+}
+
+// parseWaitForConfirmationError parses the error returned by WaitForConfirmation
+func parseWaitForConfirmationError(err error) *TxnConfirmationError {
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "timed out") {
+		return &TxnConfirmationError{
+			Type:    ErrWaitTimeout,
+			Message: err.Error(),
+		}
+	}
+	if strings.Contains(err.Error(), "Transaction rejected") {
+		return &TxnConfirmationError{
+			Type:    ErrRejected,
+			Message: err.Error(),
+		}
+	}
+	return &TxnConfirmationError{
+		Type:    ErrInternal,
+		Message: err.Error(),
+	}
+}
+
+func parseSendTransactionError(err error) *TxnConfirmationError {
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "logic eval error") {
+		return &TxnConfirmationError{
+			Type:    ErrRejected,
+			Message: err.Error(),
+		}
+	}
+	if strings.Contains(err.Error(), "overspend") {
+		return &TxnConfirmationError{
+			Type:    ErrOverSpend,
+			Message: err.Error(),
+		}
+	}
+	if strings.Contains(err.Error(), "txn dead") {
+		return &TxnConfirmationError{
+			Type:    ErrExpired,
+			Message: err.Error(),
+		}
+	}
+	if strings.Contains(err.Error(), "balance") &&
+		strings.Contains(err.Error(), "below min") {
+		return &TxnConfirmationError{
+			Type:    ErrMinimumBalanceRequirement,
+			Message: err.Error(),
+		}
+	}
+	return &TxnConfirmationError{
+		Type:    ErrRejected,
+		Message: err.Error(),
+	}
+}
+
+func InternalError(s string) *TxnConfirmationError {
+	return &TxnConfirmationError{
+		Type:    ErrInternal,
+		Message: s,
+	}
+}
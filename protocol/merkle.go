@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+)
+
+// MerkleProof returns the Merkle proof for the leaf at the given index of the tree
+// made of the given leaves (i.e. the leaf commitments), padded with the zeroHashes
+// of the empty subtrees.
+// The proof is a path that starts with the leaf value (not hashed)
+// and includes the sibling hashes up to but excluding the root.
+// It checks the validity of the proof against the provided root
+func MerkleProof(zeroHashes [][]byte, leaves [][]byte, leafValue []byte, leafIndex int,
+	root []byte) ([][]byte, error) {
+	depth := constants.MerkleTreeLevels
+	proof := make([][]byte, 1, depth+1)
+	proof[0] = leafValue
+
+	// We need to decide whether we are left and add the right sibling to
+	// the proof, or we are right and add the left sibling to the proof.
+	// We can do this by checking the last bit of leaf index:
+	// if it's 0, we are left, if it's 1, we are right.
+	// We rigth shift the index to check the next bit in the next iteration.
+	if leafIndex < 0 || leafIndex >= len(leaves) {
+		return nil, fmt.Errorf("leaf index not in tree")
+	}
+	currentLevel := append([][]byte{}, leaves...)
+	if !bytes.Equal(constants.Hash(leafValue), currentLevel[leafIndex]) {
+		return nil, fmt.Errorf("leaf commitment mismatch")
+	}
+	if len(currentLevel)%2 == 1 {
+		currentLevel = append(currentLevel, zeroHashes[0])
+	}
+	nextLevel := make([][]byte, (len(currentLevel)+1)/2)
+	for i := 0; i < depth; i++ {
+		if leafIndex&1 == 0 {
+			proof = append(proof, currentLevel[leafIndex+1])
+		} else {
+			proof = append(proof, currentLevel[leafIndex-1])
+		}
+
+		for j := 0; j < len(currentLevel); j += 2 {
+			nextLevel[j/2] = constants.Hash(currentLevel[j], currentLevel[j+1])
+		}
+		if len(nextLevel)%2 == 1 {
+			nextLevel = append(nextLevel, zeroHashes[i+1])
+		}
+
+		currentLevel = nextLevel
+		nextLevel = nextLevel[:len(nextLevel)/2]
+		leafIndex >>= 1
+	}
+	// check if the root for the proof is the same as the supplied root
+	if len(nextLevel) != 1 || !bytes.Equal(nextLevel[0], root) {
+		return nil, fmt.Errorf("root mismatch")
+	}
+
+	return proof, nil
+}
+
+// MerkleRoot returns the root of the tree made of the given leaves (i.e. the leaf
+// commitments), padded with the zeroHashes of the empty subtrees
+func MerkleRoot(zeroHashes [][]byte, leaves [][]byte) []byte {
+	depth := constants.MerkleTreeLevels
+	if len(leaves) == 0 {
+		return zeroHashes[depth]
+	}
+	currentLevel := append([][]byte{}, leaves...)
+	for i := 0; i < depth; i++ {
+		if len(currentLevel)%2 == 1 {
+			currentLevel = append(currentLevel, zeroHashes[i])
+		}
+		nextLevel := make([][]byte, len(currentLevel)/2)
+		for j := 0; j < len(currentLevel); j += 2 {
+			nextLevel[j/2] = constants.Hash(currentLevel[j], currentLevel[j+1])
+		}
+		currentLevel = nextLevel
+	}
+	return currentLevel[0]
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+)
+
+// global state keys of the protocol parameters
+const (
+	depositMinimumAmountKey = "deposit_minimum_amount"
+	withdrawalFeeDivisorKey = "withdrawal_fee_divisor"
+	withdrawalMinimumFeeKey = "withdrawal_minimum_fee"
+)
+
+// UndeclaredProtocolParams returns the global state keys of the protocol
+// parameters missing from the app schema, which are never read from the app
+func UndeclaredProtocolParams(app *models.App) []string {
+	var missing []string
+	for _, name := range []string{depositMinimumAmountKey, withdrawalFeeDivisorKey,
+		withdrawalMinimumFeeKey} {
+		if key, ok := app.Schema.Schema.Global.Declared[name]; !ok || key.Type != "uint64" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// ReadProtocolParams reads the protocol parameters from the app global state.
+// The parameters not declared in the app schema keep their default value, see
+// UndeclaredProtocolParams
+func ReadProtocolParams(client *algod.Client, app *models.App,
+) (models.ProtocolParams, error) {
+	params := models.DefaultProtocolParams
+	fields := map[string]*uint64{
+		depositMinimumAmountKey: &params.DepositMinimumAmount,
+		withdrawalFeeDivisorKey: &params.WithdrawalFeeDivisor,
+		withdrawalMinimumFeeKey: &params.WithdrawalMinimumFee,
+	}
+	for _, name := range UndeclaredProtocolParams(app) {
+		delete(fields, name)
+	}
+	declared := map[string]*uint64{}
+	for name, field := range fields {
+		declared[app.Schema.Schema.Global.Declared[name].Key] = field
+	}
+	if len(declared) == 0 {
+		return params, nil
+	}
+
+	info, err := client.GetApplicationByID(app.Id).Do(context.Background())
+	if err != nil {
+		return params, fmt.Errorf("failed to get app %d: %v", app.Id, err)
+	}
+	for _, kv := range info.Params.GlobalState {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return params, fmt.Errorf("failed to decode global state key: %v", err)
+		}
+		if field, ok := declared[string(key)]; ok && kv.Value.Type == 2 {
+			*field = kv.Value.Uint
+		}
+	}
+	if err := params.Validate(); err != nil {
+		return models.DefaultProtocolParams, fmt.Errorf("invalid on-chain params: %v", err)
+	}
+	return params, nil
+}
@@ -0,0 +1,30 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+func TestUndeclaredProtocolParams(t *testing.T) {
+	app := &models.App{Schema: &models.Arc32Schema{}}
+	if err := decodeJSONFile("../avm/testnet/"+appArc32File, app.Schema); err != nil {
+		t.Fatalf("failed to read the testnet schema: %v", err)
+	}
+	all := []string{depositMinimumAmountKey, withdrawalFeeDivisorKey,
+		withdrawalMinimumFeeKey}
+	if got := UndeclaredProtocolParams(app); !reflect.DeepEqual(got, all) {
+		t.Errorf("testnet UndeclaredProtocolParams = %v, want %v", got, all)
+	}
+
+	declared := app.Schema.Schema.Global.Declared
+	declared[depositMinimumAmountKey] = models.Arc32StateKey{Type: "uint64",
+		Key: depositMinimumAmountKey}
+	declared[withdrawalFeeDivisorKey] = models.Arc32StateKey{Type: "bytes",
+		Key: withdrawalFeeDivisorKey}
+	want := []string{withdrawalFeeDivisorKey, withdrawalMinimumFeeKey}
+	if got := UndeclaredProtocolParams(app); !reflect.DeepEqual(got, want) {
+		t.Errorf("UndeclaredProtocolParams = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/giuliop/algoplonk/utils"
+)
+
+// the setup filenames
+const (
+	appFile                       = "App.json"
+	appArc32File                  = "APP.arc32.json"
+	tssTealFile                   = "TSS.tok"
+	depositVerifierTealFile       = "DepositVerifier.tok"
+	withdrawalVerifierTealFile    = "WithdrawalVerifier.tok"
+	treeConfigFile                = "TreeConfig.json"
+	compiledDepositCircuitFile    = "CompiledDepositCircuit.bin"
+	compiledWithdrawalCircuitFile = "CompiledWithdrawalCircuit.bin"
+
+	// optional setup files for withdrawals with an association set proof
+	associationWithdrawalVerifierTealFile    = "AssociationWithdrawalVerifier.tok"
+	compiledAssociationWithdrawalCircuitFile = "CompiledAssociationWithdrawalCircuit.bin"
+)
+
+type AppJson struct {
+	Id            uint64 `json:"id"`
+	CreationBlock uint64 `json:"creationBlock"`
+}
+
+// LoadApp loads the app instance from the app setup files in dir
+func LoadApp(dir string) (*models.App, error) {
+	app := models.App{}
+	appJson := AppJson{}
+	pathTo := func(file string) string {
+		return filepath.Join(dir, file)
+	}
+
+	if err := decodeJSONFile(pathTo(appFile), &appJson); err != nil {
+		return nil, err
+	}
+	app.Id = appJson.Id
+	if err := decodeJSONFile(pathTo(appArc32File), &app.Schema); err != nil {
+		return nil, err
+	}
+	var err error
+	if app.TSS, err = readlogicsig(pathTo(tssTealFile)); err != nil {
+		return nil, err
+	}
+	if app.DepositVerifier, err = readlogicsig(pathTo(depositVerifierTealFile)); err != nil {
+		return nil, err
+	}
+	app.WithdrawalVerifier, err = readlogicsig(pathTo(withdrawalVerifierTealFile))
+	if err != nil {
+		return nil, err
+	}
+	if app.TreeConfig, err = readTreeConfiguration(pathTo(treeConfigFile)); err != nil {
+		return nil, err
+	}
+
+	app.DepositCc, err = utils.DeserializeCompiledCircuit(pathTo(compiledDepositCircuitFile))
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing compiled deposit circuit: %v", err)
+	}
+	app.WithdrawalCc, err = utils.DeserializeCompiledCircuit(pathTo(
+		compiledWithdrawalCircuitFile))
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing compiled withdrawal circuit: %v", err)
+	}
+
+	if fileExists(pathTo(compiledAssociationWithdrawalCircuitFile)) {
+		app.AssociationWithdrawalCc, err = utils.DeserializeCompiledCircuit(pathTo(
+			compiledAssociationWithdrawalCircuitFile))
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error deserializing compiled association withdrawal circuit: %v", err)
+		}
+		app.AssociationWithdrawalVerifier, err = readlogicsig(pathTo(
+			associationWithdrawalVerifierTealFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &app, nil
+}
+
+func readlogicsig(compiledPath string) (*models.Lsig, error) {
+	bytecode, err := os.ReadFile(compiledPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	lsigAccount, err := crypto.MakeLogicSigAccountEscrowChecked(bytecode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating logic sig account: %v", err)
+	}
+	address, err := lsigAccount.Address()
+	if err != nil {
+		return nil, fmt.Errorf("error getting lsig address: %v", err)
+	}
+	return &models.Lsig{
+		Account: lsigAccount,
+		Address: address,
+	}, nil
+}
+
+// readTreeConfiguration reads the tree configuration from the given file
+func readTreeConfiguration(treeConfigPath string) (models.TreeConfig, error) {
+	treeConfig := models.TreeConfig{}
+	if err := decodeJSONFile(treeConfigPath, &treeConfig); err != nil {
+		return treeConfig, err
+	}
+	treeConfig.HashFunc = constants.Hash
+	return treeConfig, nil
+}
+
+// fileExists returns true if the file exists
+func fileExists(filepath string) bool {
+	_, err := os.Stat(filepath)
+	return err == nil
+}
+
+// decodeJSONFile decodes the JSON filepath into the given interface
+func decodeJSONFile(filepath string, v interface{}) error {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", filepath, err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("error decoding file %s: %v", filepath, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// errors validating a transaction signed by the user
+var (
+	ErrSignedTxnMismatch    = errors.New("the signed transaction differs from the one prepared")
+	ErrSignedTxnGroup       = errors.New("the signed transaction is not part of the deposit group")
+	ErrSignedTxnSigner      = errors.New("the transaction is not signed by the account or its authorized signer")
+	ErrSignedTxnSignature   = errors.New("the transaction signature is not valid")
+	ErrSignedTxnUnsupported = errors.New("the transaction signature type is not supported")
+)
+
+// txidPrefix is prepended to a transaction when signing it
+var txidPrefix = []byte("TX")
+
+// ValidateUserSignedTxn checks that signedTxn is the transaction at index of the
+// prepared group, correctly signed by its sender or by the account the sender is
+// rekeyed to, as read from algod. Single and multisig signatures are accepted
+func ValidateUserSignedTxn(client *algod.Client, signedTxn *types.SignedTxn,
+	group []types.Transaction, index int) error {
+	if index < 0 || index >= len(group) {
+		return fmt.Errorf("invalid index %d for a group of %d txns", index, len(group))
+	}
+	expected := group[index]
+	if crypto.GetTxID(signedTxn.Txn) != crypto.GetTxID(expected) {
+		return ErrSignedTxnMismatch
+	}
+	// the prepared txns carry their group id, which is computed without it
+	ungrouped := make([]types.Transaction, len(group))
+	for i, txn := range group {
+		txn.Group = types.Digest{}
+		ungrouped[i] = txn
+	}
+	groupId, err := crypto.ComputeGroupID(ungrouped)
+	if err != nil {
+		return fmt.Errorf("failed to compute group id: %v", err)
+	}
+	if signedTxn.Txn.Group != groupId {
+		return ErrSignedTxnGroup
+	}
+
+	signer, err := authorizedSigner(client, signedTxn.Txn.Sender)
+	if err != nil {
+		return err
+	}
+	claimedSigner := signedTxn.Txn.Sender
+	if signedTxn.AuthAddr != (types.Address{}) {
+		claimedSigner = signedTxn.AuthAddr
+	}
+	if claimedSigner != signer {
+		return fmt.Errorf("%w: signed by %s, expected %s", ErrSignedTxnSigner,
+			claimedSigner, signer)
+	}
+
+	message := append(append([]byte{}, txidPrefix...), msgpack.Encode(signedTxn.Txn)...)
+	hasSig := signedTxn.Sig != (types.Signature{})
+	hasMsig := !signedTxn.Msig.Blank()
+	switch {
+	case !signedTxn.Lsig.Blank() || hasSig && hasMsig:
+		return ErrSignedTxnUnsupported
+	case hasSig:
+		if !ed25519.Verify(signer[:], message, signedTxn.Sig[:]) {
+			return ErrSignedTxnSignature
+		}
+	case hasMsig:
+		return verifyMultisig(signedTxn.Msig, signer, message)
+	default:
+		return fmt.Errorf("%w: the transaction is not signed", ErrSignedTxnSignature)
+	}
+	return nil
+}
+
+// authorizedSigner returns the address authorized to sign for the account, which
+// is the account itself unless it has been rekeyed
+func authorizedSigner(client *algod.Client, account types.Address) (types.Address, error) {
+	info, err := client.AccountInformation(account.String()).
+		Exclude("all").Do(context.Background())
+	if err != nil {
+		return types.Address{}, fmt.Errorf("failed to get account %s: %v", account, err)
+	}
+	if info.AuthAddr == "" {
+		return account, nil
+	}
+	signer, err := types.DecodeAddress(info.AuthAddr)
+	if err != nil {
+		return types.Address{}, fmt.Errorf("failed to decode auth address: %v", err)
+	}
+	return signer, nil
+}
+
+// verifyMultisig checks that the multisig is for the signer address and that at
+// least threshold subsignatures are valid
+func verifyMultisig(msig types.MultisigSig, signer types.Address, message []byte) error {
+	account, err := crypto.MultisigAccountFromSig(msig)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignedTxnSignature, err)
+	}
+	address, err := account.Address()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignedTxnSignature, err)
+	}
+	if address != signer {
+		return fmt.Errorf("%w: multisig address %s, expected %s", ErrSignedTxnSigner,
+			address, signer)
+	}
+	valid := 0
+	for _, subsig := range msig.Subsigs {
+		if subsig.Sig == (types.Signature{}) {
+			continue
+		}
+		if !ed25519.Verify(subsig.Key, message, subsig.Sig[:]) {
+			return ErrSignedTxnSignature
+		}
+		valid++
+	}
+	if valid < int(msig.Threshold) {
+		return fmt.Errorf("%w: %d of %d required signatures", ErrSignedTxnSignature,
+			valid, msig.Threshold)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// fakeAlgod returns an algod client serving the account information of the
+// accounts, by address, with the address they are rekeyed to or empty. Any other
+// account gets a server error
+func fakeAlgod(t *testing.T, accounts map[types.Address]types.Address) *algod.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		address, err := types.DecodeAddress(strings.TrimPrefix(r.URL.Path, "/v2/accounts/"))
+		authAddr, ok := accounts[address]
+		if err != nil || !ok {
+			http.Error(w, `{"message":"node error"}`, http.StatusInternalServerError)
+			return
+		}
+		account := map[string]any{"address": address.String(), "amount": 0}
+		if authAddr != (types.Address{}) {
+			account["auth-addr"] = authAddr.String()
+		}
+		json.NewEncoder(w).Encode(account)
+	}))
+	t.Cleanup(server.Close)
+	client, err := algod.MakeClient(server.URL, "")
+	if err != nil {
+		t.Fatalf("failed to make algod client: %v", err)
+	}
+	return client
+}
+
+// testAccount is a new random account
+type testAccount struct {
+	address types.Address
+	key     ed25519.PrivateKey
+}
+
+func newTestAccount(t *testing.T) testAccount {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	address, err := crypto.GenerateAddressFromSK(key)
+	if err != nil {
+		t.Fatalf("failed to get address: %v", err)
+	}
+	return testAccount{address, key}
+}
+
+// testGroup returns a group of two payments from sender, with their group id
+func testGroup(t *testing.T, sender types.Address) []types.Transaction {
+	t.Helper()
+	group := make([]types.Transaction, 2)
+	for i := range group {
+		group[i] = types.Transaction{
+			Type: types.PaymentTx,
+			Header: types.Header{Sender: sender, Fee: 1000, FirstValid: 1,
+				LastValid: 1000, GenesisHash: types.Digest{1}},
+			PaymentTxnFields: types.PaymentTxnFields{Receiver: sender,
+				Amount: types.MicroAlgos(i)},
+		}
+	}
+	groupId, err := crypto.ComputeGroupID(group)
+	if err != nil {
+		t.Fatalf("failed to compute group id: %v", err)
+	}
+	for i := range group {
+		group[i].Group = groupId
+	}
+	return group
+}
+
+// signWith signs txn with the key, setting the auth address if the key is not the
+// sender's
+func signWith(t *testing.T, a testAccount, txn types.Transaction) types.SignedTxn {
+	t.Helper()
+	message := append(append([]byte{}, txidPrefix...), msgpack.Encode(txn)...)
+	stxn := types.SignedTxn{Txn: txn}
+	copy(stxn.Sig[:], ed25519.Sign(a.key, message))
+	if a.address != txn.Sender {
+		stxn.AuthAddr = a.address
+	}
+	return stxn
+}
+
+// signMultisig signs txn with the multisig account and the keys, decoding the
+// resulting signed txn
+func signMultisig(t *testing.T, ma crypto.MultisigAccount, txn types.Transaction,
+	keys ...ed25519.PrivateKey) types.SignedTxn {
+	t.Helper()
+	_, stxnBytes, err := crypto.SignMultisigTransaction(keys[0], ma, txn)
+	for _, key := range keys[1:] {
+		if err != nil {
+			break
+		}
+		_, stxnBytes, err = crypto.AppendMultisigTransaction(key, ma, stxnBytes)
+	}
+	if err != nil {
+		t.Fatalf("failed to sign multisig: %v", err)
+	}
+	var stxn types.SignedTxn
+	if err := msgpack.Decode(stxnBytes, &stxn); err != nil {
+		t.Fatalf("failed to decode multisig txn: %v", err)
+	}
+	return stxn
+}
+
+func TestValidateUserSignedTxn(t *testing.T) {
+	user, rekeyed, authorized, other := newTestAccount(t), newTestAccount(t),
+		newTestAccount(t), newTestAccount(t)
+	m1, m2, m3 := newTestAccount(t), newTestAccount(t), newTestAccount(t)
+	ma, err := crypto.MultisigAccountWithParams(1, 2,
+		[]types.Address{m1.address, m2.address, m3.address})
+	if err != nil {
+		t.Fatalf("failed to make multisig account: %v", err)
+	}
+	msigAddress, _ := ma.Address()
+	otherMa, _ := crypto.MultisigAccountWithParams(1, 1,
+		[]types.Address{m1.address, m2.address})
+	unknown := newTestAccount(t)
+	client := fakeAlgod(t, map[types.Address]types.Address{
+		user.address:    {},
+		rekeyed.address: authorized.address,
+		msigAddress:     {},
+	})
+
+	userGroup := testGroup(t, user.address)
+	rekeyedGroup := testGroup(t, rekeyed.address)
+	msigGroup := testGroup(t, msigAddress)
+	unknownGroup := testGroup(t, unknown.address)
+
+	tests := []struct {
+		name  string
+		stxn  func() types.SignedTxn
+		group []types.Transaction
+		index int
+		want  error // nil for a valid txn, errUnwrapped for an error not in the list
+	}{
+		{"signed by the sender", func() types.SignedTxn {
+			return signWith(t, user, userGroup[1])
+		}, userGroup, 1, nil},
+		{"index out of range", func() types.SignedTxn {
+			return signWith(t, user, userGroup[1])
+		}, userGroup, 2, errUnwrapped},
+		{"different txn", func() types.SignedTxn {
+			return signWith(t, user, userGroup[0])
+		}, userGroup, 1, ErrSignedTxnMismatch},
+		{"txn out of the group", func() types.SignedTxn {
+			txn := userGroup[1]
+			txn.Group = types.Digest{}
+			return signWith(t, user, txn)
+		}, []types.Transaction{userGroup[0], func() types.Transaction {
+			txn := userGroup[1]
+			txn.Group = types.Digest{}
+			return txn
+		}()}, 1, ErrSignedTxnGroup},
+		{"account lookup fails", func() types.SignedTxn {
+			return signWith(t, unknown, unknownGroup[1])
+		}, unknownGroup, 1, errUnwrapped},
+		{"signed by another account", func() types.SignedTxn {
+			return signWith(t, other, userGroup[1])
+		}, userGroup, 1, ErrSignedTxnSigner},
+		{"wrong signature", func() types.SignedTxn {
+			stxn := signWith(t, other, userGroup[1])
+			stxn.AuthAddr = types.Address{}
+			return stxn
+		}, userGroup, 1, ErrSignedTxnSignature},
+		{"not signed", func() types.SignedTxn {
+			return types.SignedTxn{Txn: userGroup[1]}
+		}, userGroup, 1, ErrSignedTxnSignature},
+		{"logic sig", func() types.SignedTxn {
+			stxn := types.SignedTxn{Txn: userGroup[1]}
+			stxn.Lsig.Logic = []byte{1}
+			return stxn
+		}, userGroup, 1, ErrSignedTxnUnsupported},
+		{"single and multi sig", func() types.SignedTxn {
+			stxn := signMultisig(t, ma, msigGroup[1], m1.key, m2.key)
+			stxn.Sig = types.Signature{1}
+			return stxn
+		}, msigGroup, 1, ErrSignedTxnUnsupported},
+		{"rekeyed, signed by the authorized account", func() types.SignedTxn {
+			return signWith(t, authorized, rekeyedGroup[1])
+		}, rekeyedGroup, 1, nil},
+		{"rekeyed, signed by the sender", func() types.SignedTxn {
+			return signWith(t, rekeyed, rekeyedGroup[1])
+		}, rekeyedGroup, 1, ErrSignedTxnSigner},
+		{"multisig", func() types.SignedTxn {
+			return signMultisig(t, ma, msigGroup[1], m1.key, m3.key)
+		}, msigGroup, 1, nil},
+		{"multisig below threshold", func() types.SignedTxn {
+			return signMultisig(t, ma, msigGroup[1], m1.key)
+		}, msigGroup, 1, ErrSignedTxnSignature},
+		{"multisig with a bad subsignature", func() types.SignedTxn {
+			stxn := signMultisig(t, ma, msigGroup[1], m1.key, m2.key)
+			stxn.Msig.Subsigs[0].Sig[0] ^= 1
+			return stxn
+		}, msigGroup, 1, ErrSignedTxnSignature},
+		{"multisig of another account", func() types.SignedTxn {
+			stxn := signMultisig(t, otherMa, msigGroup[1], m1.key)
+			stxn.AuthAddr = types.Address{}
+			return stxn
+		}, msigGroup, 1, ErrSignedTxnSigner},
+		{"malformed multisig", func() types.SignedTxn {
+			stxn := signMultisig(t, ma, msigGroup[1], m1.key, m2.key)
+			stxn.Msig.Version = 0
+			return stxn
+		}, msigGroup, 1, ErrSignedTxnSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stxn := tt.stxn()
+			err := ValidateUserSignedTxn(client, &stxn, tt.group, tt.index)
+			switch {
+			case tt.want == nil && err != nil:
+				t.Errorf("ValidateUserSignedTxn = %v, want nil", err)
+			case tt.want == errUnwrapped && (err == nil || isSignedTxnError(err)):
+				t.Errorf("ValidateUserSignedTxn = %v, want another error", err)
+			case tt.want != nil && tt.want != errUnwrapped && !errors.Is(err, tt.want):
+				t.Errorf("ValidateUserSignedTxn = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// errUnwrapped marks the test cases expecting an error that is none of the
+// ErrSignedTxn errors
+var errUnwrapped = errors.New("other error")
+
+// isSignedTxnError returns true if err is one of the ErrSignedTxn errors
+func isSignedTxnError(err error) bool {
+	for _, e := range []error{ErrSignedTxnMismatch, ErrSignedTxnGroup, ErrSignedTxnSigner,
+		ErrSignedTxnSignature, ErrSignedTxnUnsupported} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,442 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/giuliop/HermesVault-frontend/constants"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
+	"github.com/giuliop/HermesVault-frontend/zkp/circuits"
+
+	"github.com/algorand/go-algorand-sdk/v2/abi"
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/transaction"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+	"github.com/consensys/gnark/frontend"
+)
+
+// DepositTxns create the txn group to make a deposit on chain:
+// 1. the app call signed by the deposit verifier with the zk proof
+// 2. the deposit transaction to the contract address signed by the user
+// 3. the additional app call transactions needed to meet the opcode budget
+func DepositTxns(client *algod.Client, app *models.App, amount models.Amount,
+	address models.Address, note *models.Note) ([]types.Transaction, error) {
+
+	assignment := &circuits.DepositCircuit{
+		Amount:     amount.Microalgos,
+		Commitment: note.Commitment(),
+		K:          note.K[:],
+		R:          note.R[:],
+	}
+	zkArgs, err := zkp.ZkArgs(assignment, app.DepositCc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zk args for deposit: %v", err)
+	}
+
+	depositMethod, err := app.Schema.Contract.GetMethodByName(constants.DepositMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method %s: %v", constants.DepositMethodName, err)
+	}
+	appArgs := [][]byte{depositMethod.GetSelector()}
+	appArgs = append(appArgs, zkArgs...)
+
+	addressBytes, err := types.DecodeAddress(string(address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode address: %v", err)
+	}
+	appArgs = append(appArgs, addressBytes[:])
+
+	sp, err := client.SuggestedParams().Do(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suggested params: %v", err)
+	}
+	sp.Fee = 0
+	sp.FlatFee = true
+	sp.LastRoundValid = sp.FirstRoundValid + constants.WaitRounds
+
+	// txn1 is the app call signed by the deposit verifier with the zk proof
+	txn1, err := transaction.MakeApplicationNoOpTxWithBoxes(
+		app.Id,
+		appArgs,
+		nil, nil, nil, // foreignAccounts, foreignApps, foreignAssets
+		[]types.AppBoxReference{
+			{AppID: app.Id, Name: []byte("subtree")},
+			{AppID: app.Id, Name: []byte("subtree")},
+			{AppID: app.Id, Name: []byte("roots")},
+			{AppID: app.Id, Name: []byte("roots")},
+		},
+		sp,
+		app.DepositVerifier.Address, // sender
+		nil,                         // note
+		types.Digest{},              // group
+		[32]byte{},                  // lease
+		types.ZeroAddress,           // RekeyTo
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make application call txn: %v", err)
+	}
+
+	// txn2 is the deposit transaction to the contract address signed by the user
+	txn2, err := transaction.MakePaymentTxn(
+		string(address), // from
+		crypto.GetApplicationAddress(app.Id).String(), // to
+		amount.Microalgos,
+		nil,                        // note
+		types.ZeroAddress.String(), // closeRemainderTo
+		sp,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make payment txn: %v", err)
+	}
+	txn2.Fee = transaction.MinTxnFee * constants.DepositMinFeeMultiplier
+
+	// additional transactions needed to meet the opcode budget
+	// we make them app calls to count also for smart contract opcode pooling.
+	txnNeeded := constants.VerifierTopLevelTxnNeeded - 2 // 2 transactions already added
+	noopMethod, err := app.Schema.Contract.GetMethodByName(constants.NoOpMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method %s: %v", constants.NoOpMethodName, err)
+	}
+	args := [][]byte{noopMethod.GetSelector()}
+
+	txns := []types.Transaction{txn1, txn2}
+	for i := 0; i < txnNeeded; i++ {
+		txn, err := transaction.MakeApplicationNoOpTx(
+			app.Id,
+			append(args, []byte{byte(i)}), // args
+			nil, nil, nil,                 // foreignAccounts, foreignApps, foreignAssets
+			sp,
+			app.TSS.Address,   // sender
+			nil,               // note
+			types.Digest{},    // group
+			[32]byte{},        // lease
+			types.ZeroAddress, // rekeyTo
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make application call txn: %v", err)
+		}
+		txns = append(txns, txn)
+	}
+
+	groupID, err := crypto.ComputeGroupID(txns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute group id: %v", err)
+	}
+	for i := range txns {
+		txns[i].Group = groupID
+	}
+
+	return txns, nil
+}
+
+// Confirmation is a deposit or withdrawal confirmed by the network
+type Confirmation struct {
+	TxnID     string // ID of the first group txn, the app call
+	LeafIndex uint64 // leaf index of the inserted note
+	Round     uint64 // round the group was confirmed in
+}
+
+// Submitted is called with the ID of the first group txn once the node accepted
+// the group, before waiting for its confirmation
+type Submitted func(txnId string)
+
+// SendDeposit sends the deposit transactions to the network and waits for their
+// confirmation. submitted can be nil
+func SendDeposit(client *algod.Client, app *models.App, txns []types.Transaction,
+	userSignedTxn []byte, submitted Submitted,
+) (*Confirmation, *TxnConfirmationError) {
+	signedGroup := []byte{}
+	// sign the deposit app call transaction with the deposit verifier
+	_, signed1, err := crypto.SignLogicSigAccountTransaction(app.DepositVerifier.Account,
+		txns[0])
+	if err != nil {
+		return nil, InternalError("failed to sign app call txn: " + err.Error())
+	}
+	signedGroup = append(signedGroup, signed1...)
+	// the second transaction is the one signed by the user
+	signedGroup = append(signedGroup, userSignedTxn...)
+	// then sign the noop transactions for the opcode budget with the TSS account
+	for i := 2; i < len(txns); i++ {
+		_, signed, err := crypto.SignLogicSigAccountTransaction(app.TSS.Account, txns[i])
+		if err != nil {
+			return nil, InternalError("failed to sign app call txn: " + err.Error())
+		}
+		signedGroup = append(signedGroup, signed...)
+	}
+
+	return sendGroup(client, signedGroup, crypto.GetTxID(txns[0]), submitted)
+}
+
+// AssociationProof proves that a note is in an association set
+type AssociationProof struct {
+	Root []byte   // root of the association set tree
+	Path [][]byte // merkle proof of the note in the association set tree
+}
+
+// WithdrawalTxns creates the txn group to make a withdrawal on chain, proving
+// that the note being spent is in the tree with the given root with merkleProof.
+// If association is not nil, the zk proof also proves that the note is in the
+// association set
+func WithdrawalTxns(client *algod.Client, app *models.App, w *models.WithdrawalData,
+	root []byte, merkleProof [][]byte, association *AssociationProof,
+) ([]types.Transaction, error) {
+	if w.FromNote.LeafIndex == models.EmptyLeafIndex {
+		return nil, fmt.Errorf("empty leaf index")
+	}
+
+	var path [constants.MerkleTreeLevels + 1]frontend.Variable
+	for i, v := range merkleProof {
+		path[i] = v
+	}
+
+	recipient, err := types.DecodeAddress(string(w.Address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recipient address: %v", err)
+	}
+
+	var assignment frontend.Circuit = &circuits.WithdrawalCircuit{
+		Recipient:  recipient[:],
+		Withdrawal: w.Amount.Microalgos,
+		Fee:        w.Fee.Microalgos,
+		Commitment: w.ChangeNote.Commitment(),
+		Nullifier:  w.FromNote.Nullifier(),
+		Root:       root,
+		K:          w.FromNote.K[:],
+		R:          w.FromNote.R[:],
+		Amount:     w.FromNote.Amount,
+		Change:     w.ChangeNote.Amount,
+		K2:         w.ChangeNote.K[:],
+		R2:         w.ChangeNote.R[:],
+		Index:      w.FromNote.LeafIndex,
+		Path:       path,
+	}
+	cc := app.WithdrawalCc
+	verifier := app.WithdrawalVerifier
+
+	// if requested, we also prove the note is in the association set
+	if association != nil {
+		if app.AssociationWithdrawalCc == nil {
+			return nil, fmt.Errorf("association set withdrawals not supported")
+		}
+		var associationPath [constants.MerkleTreeLevels + 1]frontend.Variable
+		for i, v := range association.Path {
+			associationPath[i] = v
+		}
+		assignment = &circuits.AssociationWithdrawalCircuit{
+			Recipient:       recipient[:],
+			Withdrawal:      w.Amount.Microalgos,
+			Fee:             w.Fee.Microalgos,
+			Commitment:      w.ChangeNote.Commitment(),
+			Nullifier:       w.FromNote.Nullifier(),
+			Root:            root,
+			AssociationRoot: association.Root,
+			K:               w.FromNote.K[:],
+			R:               w.FromNote.R[:],
+			Amount:          w.FromNote.Amount,
+			Change:          w.ChangeNote.Amount,
+			K2:              w.ChangeNote.K[:],
+			R2:              w.ChangeNote.R[:],
+			Index:           w.FromNote.LeafIndex,
+			Path:            path,
+			AssociationPath: associationPath,
+		}
+		cc = app.AssociationWithdrawalCc
+		verifier = app.AssociationWithdrawalVerifier
+	}
+
+	zkArgs, err := zkp.ZkArgs(assignment, cc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zk args for withdrawal: %v", err)
+	}
+
+	withdrawalMethod, err := app.Schema.Contract.GetMethodByName(constants.WithDrawalMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method %s: %v",
+			constants.WithDrawalMethodName, err)
+	}
+	withdrawalArgs := [][]byte{withdrawalMethod.GetSelector()}
+	withdrawalArgs = append(withdrawalArgs, zkArgs...)
+
+	recipientPositionInForeignAccounts := 2
+	withdrawalArgs = append(withdrawalArgs, []byte{byte(recipientPositionInForeignAccounts)})
+
+	// TODO: let the user set noChange and extraTxnFee
+	noChange := false
+	extraTxnFee := 0
+	noChangeAbi, err := abiEncode(noChange, "bool")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode noChange: %v", err)
+	}
+	extraTxnFeeAbi, err := abiEncode(extraTxnFee, "uint64")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode extraTxnFee: %v", err)
+	}
+	withdrawalArgs = append(withdrawalArgs, noChangeAbi)
+	withdrawalArgs = append(withdrawalArgs, extraTxnFeeAbi)
+
+	sp, err := client.SuggestedParams().Do(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suggested params: %v", err)
+	}
+	sp.Fee = 0
+	sp.FlatFee = true
+	sp.LastRoundValid = sp.FirstRoundValid + constants.WaitRounds
+
+	// txn1 is the app call signed by the withdrawal verifier with the zk proof
+	txn1, err := transaction.MakeApplicationNoOpTxWithBoxes(
+		app.Id,
+		withdrawalArgs,
+		[]string{app.TSS.Address.String(), recipient.String()}, // foreignAccounts
+		nil, nil, // foreignApps, foreignAssets
+		[]types.AppBoxReference{
+			{AppID: app.Id, Name: w.FromNote.Nullifier()},
+			{AppID: app.Id, Name: []byte("subtree")},
+			{AppID: app.Id, Name: []byte("roots")},
+			{AppID: app.Id, Name: []byte("roots")},
+		},
+		sp,
+		verifier.Address,  // sender
+		nil,               // note
+		types.Digest{},    // group
+		[32]byte{},        // lease
+		types.ZeroAddress, // RekeyTo
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make application call txn: %v", err)
+	}
+
+	// now we add noop transactions signed by the TSS account, the first to pay the fees
+	// and the others to meet the opcode budget
+	noopMethod, err := app.Schema.Contract.GetMethodByName(constants.NoOpMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method %s: %v", constants.NoOpMethodName, err)
+	}
+
+	txns := []types.Transaction{txn1}
+	txnNeeded := constants.VerifierTopLevelTxnNeeded - 1 // 1 transaction already added
+
+	for i := 0; i < txnNeeded; i++ {
+		args := [][]byte{noopMethod.GetSelector()}
+		txn, err := transaction.MakeApplicationNoOpTx(
+			app.Id,
+			append(args, []byte{byte(i)}),
+			nil, nil, nil, // foreign accounts, foreignApps, foreignAssets
+			sp,
+			app.TSS.Address,   // sender
+			nil,               // note
+			types.Digest{},    // group
+			[32]byte{},        // lease
+			types.ZeroAddress, // RekeyTo
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make application call txn: %v", err)
+		}
+		txns = append(txns, txn)
+	}
+	// set the fee for the first noop transaction
+	txns[1].Fee = transaction.MinTxnFee * constants.WithdrawalMinFeeMultiplier
+
+	groupID, err := crypto.ComputeGroupID(txns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute group id: %v ", err)
+	}
+	for i := range txns {
+		txns[i].Group = groupID
+	}
+
+	return txns, nil
+}
+
+// SendWithdrawal sends the withdrawal transactions to the network and waits for
+// their confirmation. submitted can be nil
+func SendWithdrawal(client *algod.Client, app *models.App, txns []types.Transaction,
+	submitted Submitted,
+) (*Confirmation, *TxnConfirmationError) {
+	// sign the withdrawal app call transaction with the withdrawal verifier that
+	// is the sender, the association one if the txn carries an association proof
+	verifier := app.WithdrawalVerifier
+	if app.AssociationWithdrawalVerifier != nil &&
+		txns[0].Sender == app.AssociationWithdrawalVerifier.Address {
+		verifier = app.AssociationWithdrawalVerifier
+	}
+	signedGroup := []byte{}
+	_, signed1, err := crypto.SignLogicSigAccountTransaction(verifier.Account, txns[0])
+	if err != nil {
+		return nil, InternalError("failed to sign app call txn: " + err.Error())
+	}
+	signedGroup = append(signedGroup, signed1...)
+
+	// sign the rest with the TSS
+	for i := 1; i < len(txns); i++ {
+		_, signed, err := crypto.SignLogicSigAccountTransaction(app.TSS.Account, txns[i])
+		if err != nil {
+			return nil, InternalError("failed to sign app call txn: " + err.Error())
+		}
+		signedGroup = append(signedGroup, signed...)
+	}
+
+	return sendGroup(client, signedGroup, crypto.GetTxID(txns[0]), submitted)
+}
+
+// sendGroup sends the signed txn group to the network and waits on its first txn,
+// the app call, to get the leaf index
+func sendGroup(client *algod.Client, signedGroup []byte, appCallTxnId string,
+	submitted Submitted) (*Confirmation, *TxnConfirmationError) {
+	_, err := client.SendRawTransaction(signedGroup).Do(context.Background())
+	if err != nil {
+		return nil, parseSendTransactionError(err)
+	}
+	if submitted != nil {
+		submitted(appCallTxnId)
+	}
+	confirmedTxn, err := transaction.WaitForConfirmation(client, appCallTxnId,
+		constants.WaitRounds, context.Background())
+	if err != nil {
+		return nil, parseWaitForConfirmationError(err)
+	}
+	leafIndex, _, err := getLeafIndexAndRoot(confirmedTxn)
+	if err != nil {
+		return nil, InternalError("failed to get leaf index: " + err.Error())
+	}
+	return &Confirmation{
+		TxnID:     appCallTxnId,
+		LeafIndex: leafIndex,
+		Round:     confirmedTxn.ConfirmedRound,
+	}, nil
+}
+
+// getLeafIndexAndRoot extracts the leaf index from the deposit transaction result
+func getLeafIndexAndRoot(txn sdk_models.PendingTransactionInfoResponse,
+) (leafIndex uint64, root [32]byte, err error) {
+	if len(txn.Logs) == 0 {
+		return 0, root, fmt.Errorf("no logs in transaction")
+	}
+	abiBytes := txn.Logs[len(txn.Logs)-1]
+	if len(abiBytes) != 4+8+32 {
+		return 0, root, fmt.Errorf("invalid log length: expected 12 bytes, got %d", len(abiBytes))
+	}
+	leafIndex = binary.BigEndian.Uint64(abiBytes[4:12])
+	rootBytes := abiBytes[12:]
+	copy(root[:], rootBytes)
+	log.Printf("leaf index: %d, root: %x\n", leafIndex, root)
+
+	return leafIndex, root, nil
+}
+
+// abiEncode encodes arg into its abi []byte representation
+func abiEncode(arg any, abiTypeName string) ([]byte, error) {
+	abiType, err := abi.TypeOf(abiTypeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get abi type: %v", err)
+	}
+	abiArg, err := abiType.Encode(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode noChange: %v", err)
+	}
+	return abiArg, nil
+}
@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// sessionCookie is the cookie identifying the session of a client
+const sessionCookie = "hv_client"
+
+// Clients are the limiters of the GET requests and of the other, expensive ones
+var Clients = struct {
+	Get  *Limiter
+	Post *Limiter
+}{
+	Get: NewLimiter(Budget{config.RateLimitGetPerMinute, config.RateLimitGetBurst},
+		config.RateLimitCapacity),
+	Post: NewLimiter(Budget{config.RateLimitPostPerMinute, config.RateLimitPostBurst},
+		config.RateLimitCapacity),
+}
+
+// trustedProxies are the networks of config.TrustedProxies
+var trustedProxies []*net.IPNet
+
+func init() {
+	for _, cidr := range strings.Split(config.TrustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("invalid TrustedProxies CIDR %q: %v", cidr, err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+}
+
+// StartCleanupRoutine starts a goroutine that forgets the idle clients at the
+// given interval. It returns a cancel function to stop it
+func StartCleanupRoutine(ctx context.Context, interval time.Duration,
+) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Clients.Get.removeIdle()
+				Clients.Post.removeIdle()
+			case <-ctx.Done():
+				log.Println("Rate limiter cleanup routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// Reject writes the response to a rate limited request
+type Reject func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration)
+
+// Middleware limits the requests to next of each client, by IP and by session.
+// The static files are not limited
+func Middleware(next http.Handler, reject Reject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limiter, class := Clients.Post, "POST"
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			limiter, class = Clients.Get, "GET"
+		}
+		keys := []string{"ip:" + ipKey(ClientIP(r))}
+		if session := clientSession(w, r); session != "" {
+			keys = append(keys, "session:"+session)
+		}
+		for _, key := range keys {
+			ok, retryAfter, first := limiter.Allow(key)
+			if ok {
+				continue
+			}
+			if first {
+				slog.WarnContext(r.Context(), "Rate limiting client", "class", class,
+					"client", key, "method", r.Method, "path", r.URL.Path)
+			}
+			w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+			reject(w, r, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the IP of the client making the request. Behind a trusted
+// reverse proxy it is the last address not of a trusted proxy in X-Forwarded-For,
+// or X-Real-IP
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host) {
+		return host
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		addrs := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if net.ParseIP(addr) == nil {
+				break
+			}
+			host = addr
+			if !trusted(addr) {
+				break
+			}
+		}
+		return host
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return host
+}
+
+// ipKey returns the address limited for the client IP: the IP itself for IPv4,
+// its /64 network for IPv6, since a client is usually given a whole /64
+func ipKey(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return addr
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// trusted returns true if addr is the IP of a trusted proxy
+func trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientSession returns the session of the client from its cookie, setting a new
+// one if missing or malformed. It returns "" if no session could be made
+func clientSession(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && validSession(c.Value) {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		slog.ErrorContext(r.Context(), "Error generating client session", "error", err)
+		return ""
+	}
+	session := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// a new session has no history, limiting it by IP is enough
+	return ""
+}
+
+// validSession returns true if s has the format of a session
+func validSession(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// retryAfterSeconds returns d as the value of a Retry-After header, in seconds
+// rounded up
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	saved := trustedProxies
+	defer func() { trustedProxies = saved }()
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies = []*net.IPNet{network}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct", "1.2.3.4:1000", nil, "", "1.2.3.4"},
+		{"untrusted remote forwarding", "1.2.3.4:1000", []string{"5.6.7.8"}, "5.6.7.8",
+			"1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:1000", []string{"5.6.7.8"}, "", "5.6.7.8"},
+		{"proxy chain", "10.0.0.1:1000", []string{"5.6.7.8, 10.0.0.2"}, "", "5.6.7.8"},
+		{"spoofed first address", "10.0.0.1:1000", []string{"9.9.9.9, 5.6.7.8"}, "",
+			"5.6.7.8"},
+		{"several headers", "10.0.0.1:1000", []string{"9.9.9.9", "5.6.7.8, 10.0.0.2"},
+			"", "5.6.7.8"},
+		{"malformed address", "10.0.0.1:1000", []string{"junk, 10.0.0.2"}, "",
+			"10.0.0.2"},
+		{"only proxies", "10.0.0.1:1000", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"real ip", "10.0.0.1:1000", nil, "5.6.7.8", "5.6.7.8"},
+		{"malformed real ip", "10.0.0.1:1000", nil, "junk", "10.0.0.1"},
+		{"ipv6", "[2001:db8::1]:1000", nil, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, f := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := ClientIP(r); got != tt.want {
+			t.Errorf("%s: ClientIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPKey(t *testing.T) {
+	tests := []struct{ addr, want string }{
+		{"1.2.3.4", "1.2.3.4"},
+		{"::ffff:1.2.3.4", "::ffff:1.2.3.4"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::ffff", "2001:db8:1:2::/64"},
+		{"junk", "junk"},
+	}
+	for _, tt := range tests {
+		if got := ipKey(tt.addr); got != tt.want {
+			t.Errorf("ipKey(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
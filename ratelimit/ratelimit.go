@@ -0,0 +1,119 @@
+// Package ratelimit limits the requests each client can make, per IP and per
+// session, so that nobody can make the server build merkle trees and zk proofs
+// in a loop
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Budget is how many requests a client can make: Burst at once, then PerMinute
+// per minute. A zero PerMinute means no limit
+type Budget struct {
+	PerMinute int
+	Burst     int
+}
+
+// bucket is the token bucket of a client
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limited bool // set while rejecting requests, to log the offender once
+}
+
+// Limiter keeps a token bucket per key
+type Limiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	budget   Budget
+	capacity int
+	full     bool      // set while at capacity, to log it once
+	swept    time.Time // last removal of the idle keys
+}
+
+// NewLimiter returns a limiter with the given budget per key, tracking at most
+// capacity keys at once
+func NewLimiter(budget Budget, capacity int) *Limiter {
+	if budget.Burst < 1 {
+		budget.Burst = 1
+	}
+	return &Limiter{
+		buckets:  make(map[string]*bucket),
+		budget:   budget,
+		capacity: capacity,
+	}
+}
+
+// rate returns the tokens added per second
+func (l *Limiter) rate() float64 {
+	return float64(l.budget.PerMinute) / 60
+}
+
+// Allow takes a token from the bucket of key. If the bucket is empty it returns
+// false and how long until the next token, and first is true if the previous
+// request of key was allowed.
+// A new key when the limiter is at capacity, even after forgetting the idle keys,
+// is refused: letting it through would leave it unlimited
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration, first bool) {
+	if l.budget.PerMinute == 0 {
+		return true, 0, false
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, exists := l.buckets[key]
+	if !exists {
+		// the idle keys are forgotten at most once a second, each pass scans them all
+		if len(l.buckets) >= l.capacity && now.Sub(l.swept) >= time.Second {
+			l.removeIdleLocked(now)
+		}
+		if len(l.buckets) >= l.capacity {
+			if !l.full {
+				log.Printf("Rate limiter at capacity (%d clients), refusing new ones",
+					l.capacity)
+				l.full = true
+			}
+			return false, time.Duration(float64(time.Second) / l.rate()), false
+		}
+		b = &bucket{tokens: float64(l.budget.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.budget.Burst),
+		b.tokens+now.Sub(b.updated).Seconds()*l.rate())
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+	first = !b.limited
+	b.limited = true
+	retryAfter = time.Duration((1 - b.tokens) / l.rate() * float64(time.Second))
+	return false, retryAfter, first
+}
+
+// removeIdle forgets the keys whose bucket has refilled, which are the same as
+// new ones
+func (l *Limiter) removeIdle() {
+	if l.budget.PerMinute == 0 {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeIdleLocked(now)
+}
+
+// removeIdleLocked forgets the idle keys, with l.mu held
+func (l *Limiter) removeIdleLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate() >= float64(l.budget.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.full = len(l.buckets) >= l.capacity
+	l.swept = now
+}
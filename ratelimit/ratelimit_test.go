@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := NewLimiter(Budget{PerMinute: 60, Burst: 2}, 10)
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d within the burst refused", i+1)
+		}
+	}
+	ok, retryAfter, first := l.Allow("a")
+	if ok || !first {
+		t.Fatalf("request over the burst: ok %v first %v, want false true", ok, first)
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retryAfter %v, want within one second", retryAfter)
+	}
+	if ok, _, first := l.Allow("a"); ok || first {
+		t.Errorf("second request over the burst: ok %v first %v, want false false",
+			ok, first)
+	}
+	if ok, _, _ := l.Allow("b"); !ok {
+		t.Errorf("another key refused")
+	}
+
+	// a second later a token is back
+	l.buckets["a"].updated = l.buckets["a"].updated.Add(-time.Second)
+	if ok, _, _ := l.Allow("a"); !ok {
+		t.Errorf("request after the refill refused")
+	}
+}
+
+func TestAllowNoLimit(t *testing.T) {
+	l := NewLimiter(Budget{PerMinute: 0}, 1)
+	for i := 0; i < 10; i++ {
+		if ok, _, _ := l.Allow(string(rune('a' + i))); !ok {
+			t.Fatalf("request %d refused without a limit", i+1)
+		}
+	}
+}
+
+func TestAllowAtCapacity(t *testing.T) {
+	l := NewLimiter(Budget{PerMinute: 60, Burst: 2}, 1)
+	if ok, _, _ := l.Allow("a"); !ok {
+		t.Fatalf("first key refused")
+	}
+	ok, retryAfter, _ := l.Allow("b")
+	if ok {
+		t.Fatalf("new key allowed at capacity")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("retryAfter %v, want positive", retryAfter)
+	}
+	if ok, _, _ := l.Allow("a"); !ok {
+		t.Errorf("known key refused at capacity")
+	}
+
+	// once a refills it is forgotten to make room, after the sweep interval
+	l.buckets["a"].updated = l.buckets["a"].updated.Add(-time.Minute)
+	l.swept = l.swept.Add(-time.Minute)
+	if ok, _, _ := l.Allow("b"); !ok {
+		t.Errorf("new key refused after the idle ones were forgotten")
+	}
+	if _, exists := l.buckets["a"]; exists {
+		t.Errorf("idle key not forgotten")
+	}
+}
@@ -0,0 +1,207 @@
+// Package receipts builds the signed compliance reports that link a withdrawal
+// to its original deposit, walking back the receipts kept by the frontend
+package receipts
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// ReportVersion is the version of the report format
+const ReportVersion = 1
+
+// ErrNoReceipt is returned when a withdrawal in the chain has no receipt, as for
+// withdrawals made before receipts were recorded
+var ErrNoReceipt = errors.New("no receipt for withdrawal")
+
+// Source provides the receipts and the public txn data to build a report from
+type Source interface {
+	GetReceiptByWithdrawal(txnID string) (*models.Receipt, error)
+	GetTxnByID(txnID string) (*models.Txn, error)
+	GetTxnByCommitment(commitment []byte) (*models.Txn, error)
+}
+
+// Step is a withdrawal of the chain with its receipt
+type Step struct {
+	Txn     *models.Txn     `json:"txn"`
+	Receipt *models.Receipt `json:"receipt"`
+}
+
+// Report links the withdrawal with WithdrawalTxnID to its original deposit.
+// Each withdrawal spends the change note of the previous one, the first spends
+// the deposit note
+type Report struct {
+	Version         int         `json:"version"`
+	WithdrawalTxnID string      `json:"withdrawalTxnId"`
+	Reference       string      `json:"reference"` // the legal request answered
+	CreatedAt       time.Time   `json:"createdAt"`
+	Deposit         *models.Txn `json:"deposit"`
+	Withdrawals     []Step      `json:"withdrawals"` // from the first to the reported one
+}
+
+// SignedReport is a report signed by the frontend operator
+type SignedReport struct {
+	Report    json.RawMessage `json:"report"`
+	PublicKey []byte          `json:"publicKey"`
+	Signature []byte          `json:"signature"`
+}
+
+// Build walks back from the withdrawal with the given txn id to its deposit,
+// checking each receipt against the public txn data. The reference identifies
+// the legal request the report answers
+func Build(source Source, withdrawalTxnID, reference string) (*Report, error) {
+	report := &Report{
+		Version:         ReportVersion,
+		WithdrawalTxnID: withdrawalTxnID,
+		Reference:       reference,
+		CreatedAt:       time.Now().UTC(),
+	}
+	txnID := withdrawalTxnID
+	for {
+		txn, err := source.GetTxnByID(txnID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get withdrawal txn %s: %v", txnID, err)
+		}
+		if txn.Type != models.WithdrawalTxnType {
+			return nil, fmt.Errorf("txn %s is not a withdrawal", txnID)
+		}
+		receipt, err := source.GetReceiptByWithdrawal(txnID)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrNoReceipt, txnID, err)
+		}
+		if !bytes.Equal(receipt.Nullifier, txn.FromNullifier) ||
+			!bytes.Equal(receipt.ChangeCommitment, txn.Commitment) {
+			return nil, fmt.Errorf("receipt of withdrawal %s does not match its txn", txnID)
+		}
+		report.Withdrawals = append([]Step{{Txn: txn, Receipt: receipt}},
+			report.Withdrawals...)
+
+		spent, err := source.GetTxnByCommitment(receipt.SpentCommitment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get txn inserting the note spent by %s: %v",
+				txnID, err)
+		}
+		// notes can only spend notes inserted before them, so the walk terminates
+		if spent.LeafIndex >= txn.LeafIndex {
+			return nil, fmt.Errorf("withdrawal %s spends a later note", txnID)
+		}
+		if spent.Type == models.DepositTxnType {
+			report.Deposit = spent
+			return report, nil
+		}
+		txnID = spent.TxnID
+	}
+}
+
+// Sign signs the report with the private key
+func Sign(report *Report, key ed25519.PrivateKey) (*SignedReport, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding report: %v", err)
+	}
+	return &SignedReport{
+		Report:    data,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, data),
+	}, nil
+}
+
+// Verify checks that the report is signed by the public key and returns it
+func Verify(signed *SignedReport, key ed25519.PublicKey) (*Report, error) {
+	if !bytes.Equal(signed.PublicKey, key) {
+		return nil, fmt.Errorf("report signed by a different key")
+	}
+	// the report is signed in compact form, but may have been indented when written
+	var data bytes.Buffer
+	if err := json.Compact(&data, signed.Report); err != nil {
+		return nil, fmt.Errorf("error decoding report: %v", err)
+	}
+	if !ed25519.Verify(key, data.Bytes(), signed.Signature) {
+		return nil, fmt.Errorf("invalid report signature")
+	}
+	report := &Report{}
+	if err := json.Unmarshal(signed.Report, report); err != nil {
+		return nil, fmt.Errorf("error decoding report: %v", err)
+	}
+	if report.Version != ReportVersion {
+		return nil, fmt.Errorf("unsupported report version %d", report.Version)
+	}
+	return report, nil
+}
+
+// GenerateKey creates a new signing key pair and writes the private key to
+// privPath and the public key to pubPath, hex encoded
+func GenerateKey(privPath, pubPath string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating key: %v", err)
+	}
+	if err := os.WriteFile(privPath, []byte(hex.EncodeToString(priv.Seed())+"\n"),
+		0600); err != nil {
+		return fmt.Errorf("error writing private key: %v", err)
+	}
+	if err := os.WriteFile(pubPath, []byte(hex.EncodeToString(pub)+"\n"),
+		0644); err != nil {
+		return fmt.Errorf("error writing public key: %v", err)
+	}
+	return nil
+}
+
+// ReadPrivateKey reads a private key written by GenerateKey
+func ReadPrivateKey(filepath string) (ed25519.PrivateKey, error) {
+	seed, err := readHexFile(filepath, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ReadPublicKey reads a public key written by GenerateKey
+func ReadPublicKey(filepath string) (ed25519.PublicKey, error) {
+	return readHexFile(filepath, ed25519.PublicKeySize)
+}
+
+// WriteSignedReport writes the signed report to file as JSON
+func WriteSignedReport(signed *SignedReport, filepath string) error {
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding signed report: %v", err)
+	}
+	return os.WriteFile(filepath, data, 0600)
+}
+
+// ReadSignedReport reads a signed report written by WriteSignedReport
+func ReadSignedReport(filepath string) (*SignedReport, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signed report: %v", err)
+	}
+	signed := &SignedReport{}
+	if err := json.Unmarshal(data, signed); err != nil {
+		return nil, fmt.Errorf("error decoding signed report: %v", err)
+	}
+	return signed, nil
+}
+
+// readHexFile reads a hex encoded value of the given size from file
+func readHexFile(filepath string, size int) ([]byte, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key: %v", err)
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(b) != size {
+		return nil, fmt.Errorf("key in %s must be %d hex encoded bytes", filepath, size)
+	}
+	return b, nil
+}
@@ -0,0 +1,154 @@
+// Package recovery rebuilds the notes derived from a user seed from the public txns
+package recovery
+
+import (
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// DefaultGapLimit is the number of consecutive unused counters after which the
+// recovery stops looking for more notes
+const DefaultGapLimit = 20
+
+// TxnSource provides the public txn data to recover notes from
+type TxnSource interface {
+	GetAllTxns() ([]*models.Txn, error)
+}
+
+// RecoveredNote is a note derived from the seed found in the txns
+type RecoveredNote struct {
+	Note    *models.Note
+	Counter uint64
+	// SpentBy is the withdrawal spending the note, nil if the note is unspent
+	SpentBy *models.Txn
+}
+
+// Result is the outcome of a recovery
+type Result struct {
+	Notes       []*RecoveredNote // all notes found, in counter order
+	NextCounter uint64           // first counter not used by any note found
+}
+
+// Unspent returns the notes that have not been spent yet
+func (r *Result) Unspent() []*RecoveredNote {
+	var unspent []*RecoveredNote
+	for _, n := range r.Notes {
+		if n.SpentBy == nil {
+			unspent = append(unspent, n)
+		}
+	}
+	return unspent
+}
+
+// Balance returns the total amount in the unspent notes
+func (r *Result) Balance() uint64 {
+	var balance uint64
+	for _, n := range r.Unspent() {
+		balance += n.Note.Amount
+	}
+	return balance
+}
+
+// expectedChange is the change note of a withdrawal spending a recovered note,
+// whose counter is still to be found. The amounts are the change with each of
+// the fees the withdrawal may have paid
+type expectedChange struct {
+	amounts []uint64
+	txn     *models.Txn
+}
+
+// Recover regenerates the candidate notes derived from the seed, looks up their
+// commitments in the deposits and their nullifiers in the withdrawals, and follows
+// each note through its change notes to rebuild all the notes of the seed.
+// The change of a withdrawal depends on the fee it paid, which the txns do not
+// record: it is worked out with each of params, the protocol parameters in force
+// over time, so a change note is found only if its withdrawal was made with one
+// of them. It stops after gapLimit consecutive counters with no note
+func Recover(seed *models.Seed, source TxnSource, params []models.ProtocolParams,
+	gapLimit int) (*Result, error) {
+	txns, err := source.GetAllTxns()
+	if err != nil {
+		return nil, fmt.Errorf("error getting txns: %v", err)
+	}
+	depositsByCommitment := map[string]*models.Txn{}
+	depositAmounts := map[uint64]bool{}
+	withdrawalsByNullifier := map[string]*models.Txn{}
+	for _, txn := range txns {
+		switch txn.Type {
+		case models.DepositTxnType:
+			depositsByCommitment[string(txn.Commitment)] = txn
+			depositAmounts[txn.Amount] = true
+		case models.WithdrawalTxnType:
+			withdrawalsByNullifier[string(txn.FromNullifier)] = txn
+		}
+	}
+
+	result := &Result{}
+	var pending []expectedChange
+	limit := uint64(gapLimit)
+	for counter := uint64(0); counter < limit; counter++ {
+		var found *RecoveredNote
+
+		// is this counter a deposit note ?
+		for amount := range depositAmounts {
+			note := models.GenerateNoteFromSeed(amount, seed, counter)
+			if txn, ok := depositsByCommitment[string(note.Commitment())]; ok {
+				found = recoveredNote(note, counter, txn)
+				break
+			}
+		}
+		// or the change note of a withdrawal spending a note already found ?
+		for i := 0; found == nil && i < len(pending); i++ {
+			for _, amount := range pending[i].amounts {
+				note := models.GenerateNoteFromSeed(amount, seed, counter)
+				if string(note.Commitment()) == string(pending[i].txn.Commitment) {
+					found = recoveredNote(note, counter, pending[i].txn)
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+		}
+		if found == nil {
+			continue
+		}
+
+		found.SpentBy = withdrawalsByNullifier[string(found.Note.Nullifier())]
+		if w := found.SpentBy; w != nil {
+			if amounts := changeAmounts(w, found.Note, params); len(amounts) > 0 {
+				pending = append(pending, expectedChange{amounts: amounts, txn: w})
+			}
+		}
+		result.Notes = append(result.Notes, found)
+		result.NextCounter = counter + 1
+		limit = counter + 1 + uint64(gapLimit)
+	}
+	return result, nil
+}
+
+// changeAmounts returns the distinct change amounts of the withdrawal w spending
+// note with each of params
+func changeAmounts(w *models.Txn, note *models.Note, params []models.ProtocolParams,
+) []uint64 {
+	var amounts []uint64
+	seen := map[uint64]bool{}
+	for _, p := range params {
+		if p.Validate() != nil {
+			continue
+		}
+		change, err := p.ChangeAmount(models.NewAmount(w.Amount), note)
+		if err != nil || seen[change.Microalgos] {
+			continue
+		}
+		seen[change.Microalgos] = true
+		amounts = append(amounts, change.Microalgos)
+	}
+	return amounts
+}
+
+// recoveredNote returns the recovered note inserted by the txn
+func recoveredNote(note *models.Note, counter uint64, txn *models.Txn) *RecoveredNote {
+	note.LeafIndex = txn.LeafIndex
+	note.TxnID = txn.TxnID
+	return &RecoveredNote{Note: note, Counter: counter}
+}
@@ -0,0 +1,165 @@
+// Package security hardens the HTTP responses and checks the state changing
+// requests: it sets the Content-Security-Policy with a nonce per request and the
+// other security headers, limits the request bodies and checks the CSRF tokens
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+const (
+	// csrfCookie holds the CSRF token of the client. The __Host- prefix binds it to
+	// this host over HTTPS
+	csrfCookie = "__Host-hv_csrf"
+	// CSRFHeader is the header carrying the CSRF token in the htmx requests
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field carrying the CSRF token in the plain form posts
+	CSRFField = "csrf_token"
+)
+
+// contextKey is the type of the request context keys of the package
+type contextKey int
+
+const (
+	nonceKey contextKey = iota
+	csrfTokenKey
+)
+
+// cspFormat is the Content-Security-Policy, formatted with the nonce. Only the
+// scripts with the nonce, and the ones they load, can run; the styles can be
+// inline since the wallet connect modal injects them
+const cspFormat = "default-src 'self'; " +
+	"script-src 'nonce-%s' 'strict-dynamic'; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; " +
+	"font-src 'self' data: https:; " +
+	"connect-src 'self' https: wss:; " +
+	"frame-src https:; " +
+	"object-src 'none'; " +
+	"base-uri 'none'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// Reject writes the response to a request failing the CSRF check
+type Reject func(w http.ResponseWriter, r *http.Request)
+
+// Middleware sets the security headers of the responses of next, limits the size
+// of the request bodies and rejects the state changing requests without a valid
+// CSRF token. The API is exempt from the CSRF check since it does not use cookies
+func Middleware(next http.Handler, apiPrefix string, reject Reject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := randomToken()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating CSP nonce", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Security-Policy", fmt.Sprintf(cspFormat, nonce))
+		h.Set("Strict-Transport-Security",
+			fmt.Sprintf("max-age=%d; includeSubDomains", config.HSTSMaxAge))
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=()")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin-allow-popups")
+		h.Set("Cross-Origin-Resource-Policy", "same-origin")
+
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxRequestBodySize)
+		}
+
+		token := csrfToken(w, r)
+		if token == "" {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !safeMethod(r.Method) && !strings.HasPrefix(r.URL.Path, apiPrefix) &&
+			!validCSRFToken(r, token) {
+			slog.WarnContext(r.Context(), "CSRF check failed", "method", r.Method,
+				"path", r.URL.Path)
+			reject(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), nonceKey, nonce)
+		ctx = context.WithValue(ctx, csrfTokenKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Nonce returns the CSP nonce of the request, for the script tags of the page
+func Nonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey).(string)
+	return nonce
+}
+
+// CSRFToken returns the CSRF token of the request, for the page to send it back
+// with its requests
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey).(string)
+	return token
+}
+
+// csrfToken returns the CSRF token of the client from its cookie, setting a new
+// one if missing. It returns "" if no token could be made
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	token, err := randomToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating CSRF token", "error", err)
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validCSRFToken returns true if the request carries the token of the cookie in
+// the CSRF header or form field. A request without the cookie has a new token
+// that no page has seen, so it fails the check
+func validCSRFToken(r *http.Request, token string) bool {
+	if _, err := r.Cookie(csrfCookie); err != nil {
+		return false
+	}
+	sent := r.Header.Get(CSRFHeader)
+	if sent == "" {
+		sent = r.PostFormValue(CSRFField)
+	}
+	return sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// safeMethod returns true for the methods that do not change state
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// randomToken returns a new random token, base64 encoded
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
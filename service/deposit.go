@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/constants"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/memstore"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// PrepareDeposit validates the deposit request, generates its note, from the seed
+// if given, and builds the txn group, kept in the user sessions until the user
+// signs it
+func PrepareDeposit(ctx context.Context, amountInput, addressInput string,
+	seedInput SeedInput) (*models.DepositData, error) {
+	var v validation
+	amount, errAmount := models.Input(amountInput).ToAmount()
+	address, errAddress := models.Input(addressInput).ToAddress()
+	if errAmount != nil {
+		slog.InfoContext(ctx, "Error parsing deposit amount", "error", errAmount)
+		v.add(CodeInvalidAmount, "Invalid algo amount")
+	}
+	if minimum := avm.GetProtocolParams().DepositMinimumAmount; errAmount == nil &&
+		amount.Microalgos < minimum {
+		v.add(CodeAmountBelowMinimum, "The minimum deposit is "+
+			models.MicroAlgosToAlgoString(minimum)+" algo")
+	}
+	if errAddress != nil {
+		slog.InfoContext(ctx, "Error parsing deposit address", "error", errAddress)
+		v.add(CodeInvalidAddress, "Invalid Algorand address")
+	}
+	seed, counter := seedInput.parse(ctx, &v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	fromSeed, err := noteSeed(ctx, seed, counter)
+	if err != nil {
+		return nil, err
+	}
+	note, err := models.NewNote(amount.Microalgos, fromSeed)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating new note", "error", err)
+		return nil, internalError
+	}
+	txns, err := avm.CreateDepositTxns(amount, address, note)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating deposit transactions", "error", err)
+		return nil, internalError
+	}
+	note.TxnID = crypto.GetTxID(txns[0])
+
+	depositData := &models.DepositData{
+		Amount:         amount,
+		Address:        address,
+		Note:           note,
+		Txns:           txns,
+		IndexTxnToSign: constants.UserDepositTxnIndex,
+	}
+	_, err = memstore.UserSessions.StoreDeposit(depositData)
+	if errors.Is(err, memstore.ErrStoreFull) {
+		slog.WarnContext(ctx, "Error storing deposit", "error", err)
+		return nil, newError(CodeBusy, http.StatusServiceUnavailable,
+			"Too many deposits in progress. Please try again in a few minutes")
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Error storing deposit", "error", err)
+		return nil, internalError
+	}
+	return depositData, nil
+}
+
+// DepositSubmission is a prepared deposit with the txn signed by the user.
+// Amount, Address and Note are optional, if set they must match the prepared ones
+type DepositSubmission struct {
+	SignedTxn string // base64 encoded msgpack of the signed txn
+	Amount    string
+	Address   string
+	Note      string
+}
+
+// DepositResult is a deposit confirmed by the network
+type DepositResult struct {
+	TxnID     string
+	LeafIndex int
+	Round     uint64
+	Note      *models.Note
+}
+
+// SubmitDeposit validates the signed txn against the prepared deposit and sends
+// the deposit to the network, waiting for its confirmation. progress is notified
+// once the deposit is submitted
+func SubmitDeposit(ctx context.Context, s DepositSubmission, progress Progress,
+) (*DepositResult, error) {
+	signedTxnBytes, err := base64.StdEncoding.DecodeString(s.SignedTxn)
+	if err != nil {
+		slog.InfoContext(ctx, "Error decoding signed transaction", "error", err)
+		return nil, newError(CodeBadRequest, http.StatusBadRequest,
+			"The signed transaction is malformed")
+	}
+	var signedTxn types.SignedTxn
+	if err := msgpack.Decode(signedTxnBytes, &signedTxn); err != nil {
+		slog.InfoContext(ctx, "Error decoding signed transaction", "error", err)
+		return nil, newError(CodeBadRequest, http.StatusBadRequest,
+			"The signed transaction is malformed")
+	}
+
+	groupId := signedTxn.Txn.Group
+	ms := memstore.UserSessions
+	depositData, err := ms.RetrieveDeposit(groupId)
+	if err != nil {
+		slog.InfoContext(ctx, "Error retrieving deposit data", "error", err)
+		return nil, newError(CodeSessionExpired, http.StatusUnprocessableEntity,
+			"Your deposit session has expired.\nPlease start the deposit again.")
+	}
+	if err := checkDepositSubmission(ctx, s, depositData); err != nil {
+		return nil, err
+	}
+
+	err = avm.ValidateUserSignedTxn(&signedTxn, depositData.Txns, depositData.IndexTxnToSign)
+	if err != nil {
+		slog.InfoContext(ctx, "Invalid signed deposit transaction", "error", err)
+		return nil, signedTxnError(err)
+	}
+	// the session is kept until the signed txn is validated, so that the user can
+	// sign again with the right account or retry after a node error
+	ms.DeleteDeposit(groupId)
+
+	noteId, err := db.RegisterUnconfirmedNote(depositData.Note, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving unconfirmed deposit", "error", err)
+		return nil, internalError
+	}
+	confirmation, confirmationError := avm.SendDepositToNetwork(depositData.Txns,
+		msgpack.Encode(signedTxn), progress.submitted())
+	if confirmationError != nil {
+		finishUnconfirmedNote(noteId, confirmationError, nil)
+		return nil, depositTxnError(ctx, confirmationError)
+	}
+
+	depositData.Note.LeafIndex = int(confirmation.LeafIndex)
+	if confirmation.TxnID != depositData.Note.TxnID {
+		slog.WarnContext(ctx, "Deposit txnId mismatch", "confirmed", confirmation.TxnID,
+			"expected", depositData.Note.TxnID)
+	}
+	saveErr := db.SaveNote(depositData.Note)
+	if saveErr != nil {
+		slog.ErrorContext(ctx, "Error saving deposit to db", "error", saveErr)
+	}
+	finishUnconfirmedNote(noteId, nil, saveErr)
+
+	return &DepositResult{
+		TxnID:     depositData.Note.TxnID,
+		LeafIndex: depositData.Note.LeafIndex,
+		Round:     confirmation.Round,
+		Note:      depositData.Note,
+	}, nil
+}
+
+// checkDepositSubmission checks the optional fields of the submission against the
+// prepared deposit
+func checkDepositSubmission(ctx context.Context, s DepositSubmission,
+	d *models.DepositData) error {
+	var v validation
+	if s.Amount != "" {
+		amount, err := models.Input(s.Amount).ToAmount()
+		if err != nil {
+			slog.InfoContext(ctx, "Error parsing deposit amount", "error", err)
+			v.add(CodeInvalidAmount, "Invalid deposit amount")
+		} else if amount.Microalgos != d.Amount.Microalgos {
+			v.add(CodeDepositMismatch, "The deposit amount does not match")
+		}
+	}
+	if s.Address != "" {
+		address, err := models.Input(s.Address).ToAddress()
+		if err != nil {
+			slog.InfoContext(ctx, "Error parsing deposit address", "error", err)
+			v.add(CodeInvalidAddress, "Invalid Algorand address")
+		} else if address != d.Address {
+			v.add(CodeDepositMismatch, "The deposit address does not match")
+		}
+	}
+	if s.Note != "" {
+		note, err := models.Input(s.Note).ToNote()
+		if err != nil {
+			slog.InfoContext(ctx, "Error parsing deposit note", "error", err)
+			v.add(CodeInvalidNote, "Invalid note")
+		} else if note.Text() != d.Note.Text() {
+			v.add(CodeDepositMismatch, "The deposit note does not match")
+		}
+	}
+	if err := v.err(); err != nil {
+		slog.InfoContext(ctx, "Deposit submission does not match the prepared deposit",
+			"error", err, "deposit", d)
+		return err
+	}
+	return nil
+}
+
+// finishUnconfirmedNote deletes the unconfirmed note registered before sending its
+// txn if the txn was rejected or the note saved. If we timed out waiting for the
+// confirmation or failed to save the confirmed note we keep it, the txns watcher
+// and the cleanup will eventually handle it
+func finishUnconfirmedNote(noteId int64, confirmationError *avm.TxnConfirmationError,
+	saveErr error) {
+	if confirmationError != nil && confirmationError.Type == avm.ErrWaitTimeout {
+		return
+	}
+	if confirmationError == nil && saveErr != nil {
+		return
+	}
+	db.DeleteUnconfirmedNote(noteId)
+}
+
+// depositTxnError returns the error for a deposit the network did not confirm
+func depositTxnError(ctx context.Context, e *avm.TxnConfirmationError) error {
+	code := txnCode(e.Type)
+	switch e.Type {
+	case avm.ErrRejected:
+		slog.InfoContext(ctx, "Deposit transaction rejected", "error", e)
+		return newError(code, http.StatusUnprocessableEntity,
+			"Your deposit transaction was rejected by the network.\nPlease try again")
+	case avm.ErrOverSpend:
+		slog.InfoContext(ctx, "Deposit transaction overspent", "error", e)
+		return newError(code, http.StatusUnprocessableEntity,
+			"You do not have enough funds in your wallet")
+	case avm.ErrMinimumBalanceRequirement:
+		slog.InfoContext(ctx, "Deposit transaction below minimum balance", "error", e)
+		return newError(code, http.StatusUnprocessableEntity,
+			"Your wallet would go below its minimum balance")
+	case avm.ErrExpired:
+		slog.InfoContext(ctx, "Deposit transaction expired", "error", e)
+		return newError(code, http.StatusRequestTimeout,
+			"Too much time has passed and your deposit transaction has expired.\n"+
+				"Please try again")
+	case avm.ErrWaitTimeout:
+		slog.WarnContext(ctx, "Deposit transaction timed out", "error", e)
+		return newError(code, http.StatusRequestTimeout,
+			"Your deposit has not been confirmed by the network yet.\n"+
+				"Please wait a few minutes and check your wallet to see if the deposit "+
+				"was sent.\nIf not, please try again.")
+	default:
+		slog.ErrorContext(ctx, "Internal error sending deposit transaction", "error", e)
+		return newError(code, http.StatusInternalServerError,
+			"Something went wrong. Your deposit was not processed.\nPlease try again.")
+	}
+}
@@ -0,0 +1,176 @@
+// Package service implements the deposit, withdrawal and note operations shared
+// by the HTML handlers and the JSON API
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// Code identifies the kind of a service error. The codes are part of the API and
+// must not change
+type Code string
+
+const (
+	CodeBadRequest          Code = "bad_request"
+	CodeInvalidAmount       Code = "invalid_amount"
+	CodeAmountBelowMinimum  Code = "amount_below_minimum"
+	CodeInvalidAddress      Code = "invalid_address"
+	CodeInvalidNote         Code = "invalid_note"
+	CodeInvalidSeed         Code = "invalid_seed"
+	CodeUnknownNote         Code = "unknown_note"
+	CodeInsufficientBalance Code = "insufficient_balance"
+	CodeInvalidSignedTxn    Code = "invalid_signed_txn"
+	CodeDepositMismatch     Code = "deposit_mismatch"
+	CodeSessionExpired      Code = "session_expired"
+	CodeChangeNoteMismatch  Code = "change_note_mismatch"
+	CodeFeeChanged          Code = "fee_changed"
+	CodeIndexerBehind       Code = "indexer_behind"
+	CodeBusy                Code = "busy"
+	CodeRateLimited         Code = "rate_limited"
+	CodeInternal            Code = "internal"
+)
+
+// Codes returns all the error codes, including the ones of the avm.SendTxnErrorType
+// errors sending a txn to the network
+func Codes() []Code {
+	codes := []Code{CodeBadRequest, CodeInvalidAmount, CodeAmountBelowMinimum,
+		CodeInvalidAddress, CodeInvalidNote, CodeInvalidSeed, CodeUnknownNote,
+		CodeInsufficientBalance,
+		CodeInvalidSignedTxn, CodeDepositMismatch, CodeSessionExpired,
+		CodeChangeNoteMismatch, CodeFeeChanged, CodeIndexerBehind, CodeBusy, CodeRateLimited,
+		CodeInternal}
+	for _, t := range avm.SendTxnErrorTypes {
+		codes = append(codes, txnCode(t))
+	}
+	return codes
+}
+
+// txnCode returns the code of an error sending a txn to the network
+func txnCode(t avm.SendTxnErrorType) Code {
+	return Code(t.Code())
+}
+
+// Error is an error of a service operation to show the user. Message is plain
+// text, with a new line between sentences
+type Error struct {
+	Code    Code
+	Message string
+	Status  int // the HTTP status to return
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// newError returns a new service error
+func newError(code Code, status int, message string) *Error {
+	return &Error{Code: code, Message: message, Status: status}
+}
+
+// internalError is the error for failures the user cannot act on
+var internalError = newError(CodeInternal, http.StatusInternalServerError,
+	"Something went wrong. Please try again")
+
+// AsError returns err as a service error, or an internal error if it is not one
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return internalError
+}
+
+// validation collects the input errors of a request, reported together
+type validation struct {
+	code     Code
+	messages []string
+}
+
+// add records an input error, the code of the first one is the error code
+func (v *validation) add(code Code, message string) {
+	if v.code == "" {
+		v.code = code
+	}
+	v.messages = append(v.messages, message)
+}
+
+// err returns the collected input errors as a service error, or nil
+func (v *validation) err() error {
+	if v.code == "" {
+		return nil
+	}
+	return newError(v.code, http.StatusUnprocessableEntity, strings.Join(v.messages, "\n"))
+}
+
+// NoteErrorMessage returns the message to show the user for a note that
+// could not be parsed
+func NoteErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrNoteChecksum):
+		return "The note you provided has a typo, please check it"
+	case errors.Is(err, models.ErrNoteWrongNetwork):
+		return "The note you provided belongs to a different network"
+	case errors.Is(err, models.ErrNoteWrongApp):
+		return "The note you provided belongs to a different app"
+	default:
+		return "The note you provided is not valid"
+	}
+}
+
+// overdraftError returns the error for a withdrawal that exceeds the note balance
+func overdraftError(quote models.WithdrawalQuote) error {
+	return newError(CodeInsufficientBalance, http.StatusUnprocessableEntity,
+		fmt.Sprintf("Insufficient balance\nThe note holds %s algo, "+
+			"you can withdraw at most %s algo (fee %s algo)",
+			quote.Balance.Algostring, quote.MaxWithdrawable.Algostring,
+			quote.MaxFee.Algostring))
+}
+
+// indexerBehindError is returned for withdrawals while the subscriber service is
+// too far behind
+var indexerBehindError = newError(CodeIndexerBehind, http.StatusServiceUnavailable,
+	"The indexer is catching up with the blockchain.\n"+
+		"Withdrawals are paused until it does, please try again in a few minutes.")
+
+// RateLimitedError returns the error for a client making too many requests, which
+// can retry after the given duration
+func RateLimitedError(retryAfter time.Duration) *Error {
+	wait := "a second"
+	if seconds := int(math.Ceil(retryAfter.Seconds())); seconds > 1 {
+		wait = fmt.Sprintf("%d seconds", seconds)
+	}
+	return newError(CodeRateLimited, http.StatusTooManyRequests,
+		"Too many requests.\nPlease try again in "+wait+".")
+}
+
+// signedTxnError returns the error for a signed txn that failed validation
+func signedTxnError(err error) error {
+	var message string
+	switch {
+	case errors.Is(err, avm.ErrSignedTxnMismatch):
+		message = "The transaction you signed is different from the one prepared.\n" +
+			"Please start the deposit again"
+	case errors.Is(err, avm.ErrSignedTxnGroup):
+		message = "The transaction you signed is not part of the deposit group.\n" +
+			"Please start the deposit again"
+	case errors.Is(err, avm.ErrSignedTxnSigner):
+		message = "The transaction is not signed by the depositing account " +
+			"or the account it is rekeyed to"
+	case errors.Is(err, avm.ErrSignedTxnSignature):
+		message = "The transaction signature is not valid"
+	case errors.Is(err, avm.ErrSignedTxnUnsupported):
+		message = "Logic signatures are not supported, please sign with " +
+			"a single or multisig account"
+	default:
+		return internalError
+	}
+	return newError(CodeInvalidSignedTxn, http.StatusUnprocessableEntity, message)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// NoteState is the state of a note on chain
+type NoteState string
+
+const (
+	// NotePending is a note not in the merkle tree, its txn is not confirmed yet or
+	// was never sent
+	NotePending NoteState = "pending"
+	// NoteUnspent is a note in the merkle tree that can be withdrawn from
+	NoteUnspent NoteState = "unspent"
+	// NoteSpent is a note already withdrawn from
+	NoteSpent NoteState = "spent"
+)
+
+// NoteStatus is the state of a note as recorded by the subscriber service
+type NoteStatus struct {
+	State     NoteState
+	Balance   models.Amount
+	LeafIndex int    // EmptyLeafIndex if pending
+	TxnID     string // txn inserting the note, empty if pending
+	SpentBy   string // withdrawal txn spending the note, empty if not spent
+}
+
+// GetNoteStatus returns the state of the note
+func GetNoteStatus(ctx context.Context, noteInput string) (*NoteStatus, error) {
+	note, err := models.Input(noteInput).ToNote()
+	if err != nil {
+		return nil, newError(CodeInvalidNote, http.StatusUnprocessableEntity,
+			NoteErrorMessage(err))
+	}
+	status := &NoteStatus{
+		State:     NotePending,
+		Balance:   models.NewAmount(note.Amount),
+		LeafIndex: models.EmptyLeafIndex,
+	}
+	txn, err := db.GetTxnByCommitment(note.Commitment())
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting note txn", "error", err)
+		return nil, internalError
+	}
+	status.State = NoteUnspent
+	status.LeafIndex = txn.LeafIndex
+	status.TxnID = txn.TxnID
+
+	spentBy, err := db.GetTxnBySpentNullifier(note.Nullifier())
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting note spending txn", "error", err)
+		return nil, internalError
+	}
+	status.State = NoteSpent
+	status.SpentBy = spentBy.TxnID
+	return status, nil
+}
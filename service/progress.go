@@ -0,0 +1,21 @@
+package service
+
+import "github.com/giuliop/HermesVault-frontend/models"
+
+// Progress is notified of the stages of a deposit or withdrawal being submitted,
+// to run them as jobs. It can be nil
+type Progress func(models.JobEvent)
+
+// report notifies the event, if p is not nil
+func (p Progress) report(e models.JobEvent) {
+	if p != nil {
+		p(e)
+	}
+}
+
+// submitted returns the callback reporting that the node accepted the txn group
+func (p Progress) submitted() func(txnId string) {
+	return func(txnId string) {
+		p.report(models.JobEvent{Stage: models.JobSubmitted, TxnID: txnId})
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/memstore"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/recovery"
+)
+
+// SeedInput is the optional seed mnemonic and counter to derive the note of a
+// deposit, or the change note of a withdrawal, from. With no mnemonic the note has
+// random nonces. With no counter the next one not used by the notes of the seed
+// in the txns, nor reserved in the session store by a deposit or withdrawal in
+// progress, is reserved; a counter given is used as is.
+// The server derives the notes, so it sees the seed and can derive every note of
+// it, as it sees every note it makes
+type SeedInput struct {
+	Mnemonic string
+	Counter  string
+}
+
+// parse validates the seed input, adding its errors to v. It returns a nil seed
+// if there is no mnemonic, and a nil counter if the counter is to be found
+func (in SeedInput) parse(ctx context.Context, v *validation) (*models.Seed, *uint64) {
+	if strings.TrimSpace(in.Mnemonic) == "" {
+		return nil, nil
+	}
+	seed, err := models.SeedFromMnemonic(strings.TrimSpace(in.Mnemonic))
+	if err != nil {
+		slog.InfoContext(ctx, "Error parsing seed mnemonic", "error", err)
+		v.add(CodeInvalidSeed, "Invalid seed phrase")
+	}
+	if strings.TrimSpace(in.Counter) == "" {
+		return seed, nil
+	}
+	counter, err := strconv.ParseUint(strings.TrimSpace(in.Counter), 10, 64)
+	if err != nil {
+		slog.InfoContext(ctx, "Error parsing seed counter", "error", err)
+		v.add(CodeInvalidSeed, "Invalid seed counter")
+	}
+	return seed, &counter
+}
+
+// noteSeed returns the seed and counter to derive a note from, reserving the next
+// counter of the seed if counter is nil. It returns nil if seed is nil
+func noteSeed(ctx context.Context, seed *models.Seed, counter *uint64,
+) (*models.NoteSeed, error) {
+	if seed == nil {
+		return nil, nil
+	}
+	if counter != nil {
+		return &models.NoteSeed{Seed: seed, Counter: *counter}, nil
+	}
+	params, err := db.GetProtocolParamsHistory()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting protocol params history", "error", err)
+		return nil, internalError
+	}
+	params = append(params, models.DefaultProtocolParams, avm.GetProtocolParams())
+	result, err := recovery.Recover(seed, txnsSource{}, params, recovery.DefaultGapLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error finding the next seed counter", "error", err)
+		return nil, internalError
+	}
+	next, err := memstore.UserSessions.ReserveSeedCounter(seed.Id(), result.NextCounter)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reserving the next seed counter", "error", err)
+		return nil, internalError
+	}
+	return &models.NoteSeed{Seed: seed, Counter: next}, nil
+}
+
+// txnsSource provides the txns of the txns database to the recovery
+type txnsSource struct{}
+
+func (txnsSource) GetAllTxns() ([]*models.Txn, error) {
+	return db.GetAllTxns()
+}
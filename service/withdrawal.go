@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/memstore"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+)
+
+// QuoteWithdrawal returns how much can be withdrawn from the note
+func QuoteWithdrawal(noteInput string) (models.WithdrawalQuote, error) {
+	note, err := models.Input(noteInput).ToNote()
+	if err != nil {
+		return models.WithdrawalQuote{}, newError(CodeInvalidNote,
+			http.StatusUnprocessableEntity, NoteErrorMessage(err))
+	}
+	return avm.GetProtocolParams().QuoteWithdrawal(note), nil
+}
+
+// PrepareWithdrawal validates the withdrawal request and generates its change
+// note, from the seed if given. The withdrawal is kept in the user sessions, under
+// its SessionId, until the user confirms it
+func PrepareWithdrawal(ctx context.Context, amountInput, addressInput, noteInput string,
+	seedInput SeedInput) (*models.WithdrawalData, error) {
+	var v validation
+	amount, errAmount := models.Input(amountInput).ToAmount()
+	address, errAddress := models.Input(addressInput).ToAddress()
+	note, errNote := models.Input(noteInput).ToNote()
+	if errAmount != nil {
+		slog.InfoContext(ctx, "Error parsing withdrawal amount", "error", errAmount)
+		v.add(CodeInvalidAmount, "Invalid algo amount")
+	}
+	if errAddress != nil {
+		slog.InfoContext(ctx, "Error parsing withdrawal address", "error", errAddress)
+		v.add(CodeInvalidAddress, "Invalid Algorand address")
+	}
+	if errNote != nil {
+		slog.InfoContext(ctx, "Error parsing withdrawal note", "error", errNote)
+		v.add(CodeInvalidNote, NoteErrorMessage(errNote))
+	}
+	seed, counter := seedInput.parse(ctx, &v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if status := avm.GetIndexerStatus(); status.Stale(avm.IndexerLimits) {
+		slog.WarnContext(ctx, "Withdrawal blocked, indexer behind",
+			"rounds", status.Lag())
+		return nil, indexerBehindError
+	}
+	params := avm.GetProtocolParams()
+	if _, err := params.ChangeAmount(amount, note); err != nil {
+		slog.InfoContext(ctx, "Error checking withdrawal amount", "error", err)
+		if errors.Is(err, models.ErrInsufficientBalance) {
+			return nil, overdraftError(params.QuoteWithdrawal(note))
+		}
+		return nil, newError(CodeInvalidAmount, http.StatusUnprocessableEntity,
+			"Invalid algo amount")
+	}
+
+	withdrawData := &models.WithdrawalData{
+		Amount:   amount,
+		Fee:      models.NewAmount(params.Fee(amount.Microalgos)),
+		Address:  address,
+		FromNote: note,
+	}
+	var err error
+	withdrawData.FromNote.LeafIndex, err = db.GetLeafIndexByCommitment(note.Commitment())
+	if err == sql.ErrNoRows {
+		return nil, newError(CodeUnknownNote, http.StatusUnprocessableEntity,
+			"The note you provided is not valid")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting note leaf index", "error", err)
+		return nil, internalError
+	}
+	changeSeed, err := noteSeed(ctx, seed, counter)
+	if err != nil {
+		return nil, err
+	}
+	withdrawData.ChangeNote, err = models.GenerateChangeNote(params, amount, note,
+		changeSeed)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating new note", "error", err)
+		return nil, internalError
+	}
+	withdrawData.AssociationSet = avm.DefaultAssociationPolicy()
+	_, err = memstore.UserSessions.StoreWithdrawal(withdrawData)
+	if errors.Is(err, memstore.ErrStoreFull) {
+		slog.WarnContext(ctx, "Error storing withdrawal", "error", err)
+		return nil, newError(CodeBusy, http.StatusServiceUnavailable,
+			"Too many withdrawals in progress. Please try again in a few minutes")
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Error storing withdrawal", "error", err)
+		return nil, internalError
+	}
+	return withdrawData, nil
+}
+
+// WithdrawalResult is a withdrawal confirmed by the network
+type WithdrawalResult struct {
+	TxnID      string
+	LeafIndex  int
+	Round      uint64
+	ChangeNote *models.Note
+}
+
+// SubmitWithdrawal builds the proof of the prepared withdrawal and sends it to the
+// network, waiting for its confirmation. changeNoteInput is the change note shown
+// to the user, which must match the prepared one. progress is notified as the
+// tree proof and the zk proof are built and the withdrawal submitted
+func SubmitWithdrawal(ctx context.Context, sessionId, changeNoteInput string,
+	progress Progress,
+) (*WithdrawalResult, error) {
+	changeNote, err := models.Input(changeNoteInput).ToNote()
+	if err != nil {
+		slog.InfoContext(ctx, "Error parsing withdrawal new note", "error", err)
+		return nil, newError(CodeInvalidNote, http.StatusUnprocessableEntity,
+			"Invalid new secret note")
+	}
+
+	ms := memstore.UserSessions
+	withdrawData, err := ms.RetrieveWithdrawal(sessionId)
+	if err != nil {
+		slog.InfoContext(ctx, "Error retrieving withdrawal data", "error", err)
+		return nil, newError(CodeSessionExpired, http.StatusUnprocessableEntity,
+			"Your withdrawal session has expired.\nPlease start the withdrawal again.")
+	}
+
+	if changeNote.Text() != withdrawData.ChangeNote.Text() {
+		slog.InfoContext(ctx, "Withdrawal change note does not match the session",
+			"session", sessionId)
+		return nil, newError(CodeChangeNoteMismatch, http.StatusUnprocessableEntity,
+			"The new secret note does not match")
+	}
+	fee := models.NewAmount(avm.GetProtocolParams().Fee(withdrawData.Amount.Microalgos))
+	if fee.Microalgos != withdrawData.Fee.Microalgos {
+		slog.InfoContext(ctx, "Withdrawal fee changed", "from", withdrawData.Fee.Algostring,
+			"to", fee.Algostring)
+		return nil, newError(CodeFeeChanged, http.StatusUnprocessableEntity,
+			"The protocol fee has changed since your withdrawal was quoted.\n"+
+				"Please start the withdrawal again.")
+	}
+	if status := avm.GetIndexerStatus(); status.Stale(avm.IndexerLimits) {
+		slog.WarnContext(ctx, "Withdrawal blocked, indexer behind",
+			"rounds", status.Lag())
+		return nil, indexerBehindError
+	}
+	// the session is kept until the checks pass, so that the user can correct a
+	// mistyped change note or retry once the indexer catches up
+	ms.DeleteWithdrawal(sessionId)
+
+	progress.report(models.JobEvent{Stage: models.JobBuildingProof})
+	treeProof, err := avm.BuildTreeProof(withdrawData)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error building withdrawal tree proof", "error", err)
+		return nil, internalError
+	}
+	progress.report(models.JobEvent{Stage: models.JobProving})
+	txns, err := avm.CreateWithdrawalTxnsWithProof(withdrawData, treeProof)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating withdrawal transactions", "error", err)
+		return nil, internalError
+	}
+	withdrawData.ChangeNote.TxnID = crypto.GetTxID(txns[0])
+	noteId, err := db.RegisterUnconfirmedNote(withdrawData.ChangeNote,
+		withdrawData.FromNote.Commitment())
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving unconfirmed withdrawal", "error", err)
+		return nil, internalError
+	}
+	confirmation, confirmationError := avm.SendWithdrawalToNetwork(txns,
+		progress.submitted())
+	if confirmationError != nil {
+		finishUnconfirmedNote(noteId, confirmationError, nil)
+		return nil, withdrawalTxnError(ctx, confirmationError)
+	}
+
+	withdrawData.ChangeNote.LeafIndex = int(confirmation.LeafIndex)
+	if confirmation.TxnID != withdrawData.ChangeNote.TxnID {
+		slog.WarnContext(ctx, "Withdrawal txnId mismatch", "confirmed", confirmation.TxnID,
+			"expected", withdrawData.ChangeNote.TxnID)
+	}
+	// if either save fails the unconfirmed note is kept, and the txns watcher or the
+	// cleanup write the missing note and receipt
+	receipt := &models.Receipt{
+		WithdrawalTxnID:  withdrawData.ChangeNote.TxnID,
+		Nullifier:        withdrawData.FromNote.Nullifier(),
+		SpentCommitment:  withdrawData.FromNote.Commitment(),
+		ChangeCommitment: withdrawData.ChangeNote.Commitment(),
+	}
+	saveErr := db.SaveNote(withdrawData.ChangeNote)
+	if saveErr != nil {
+		slog.ErrorContext(ctx, "Error saving withdrawal to db", "error", saveErr)
+	} else if saveErr = db.SaveReceipt(receipt); saveErr != nil {
+		slog.ErrorContext(ctx, "Error saving withdrawal receipt", "error", saveErr,
+			"txn_id", receipt.WithdrawalTxnID)
+	}
+	finishUnconfirmedNote(noteId, nil, saveErr)
+
+	return &WithdrawalResult{
+		TxnID:      withdrawData.ChangeNote.TxnID,
+		LeafIndex:  withdrawData.ChangeNote.LeafIndex,
+		Round:      confirmation.Round,
+		ChangeNote: withdrawData.ChangeNote,
+	}, nil
+}
+
+// withdrawalTxnError returns the error for a withdrawal the network did not confirm
+func withdrawalTxnError(ctx context.Context, e *avm.TxnConfirmationError) error {
+	code := txnCode(e.Type)
+	switch e.Type {
+	case avm.ErrRejected:
+		slog.InfoContext(ctx, "Withdrawal transaction rejected", "error", e)
+		return newError(code, http.StatusUnprocessableEntity,
+			"Your withdrawal was rejected by the network.\n"+
+				"Please check your secret note and try again.")
+	case avm.ErrWaitTimeout:
+		slog.WarnContext(ctx, "Withdrawal transaction timed out", "error", e)
+		return newError(code, http.StatusRequestTimeout,
+			"Your withdrawal has not been confirmed by the blockchain yet.\n"+
+				"Please wait a few minutes and check your wallet to see if the "+
+				"withdrawal was received.\nIf not, please try again.")
+	default:
+		slog.ErrorContext(ctx, "Error sending withdrawal transaction", "error", e)
+		return newError(code, http.StatusInternalServerError,
+			"Something went wrong. Your withdrawal was not processed.\nPlease try again.")
+	}
+}
@@ -0,0 +1,68 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/accumulator/merkle"
+	"github.com/consensys/gnark/std/hash/mimc"
+)
+
+// AssociationWithdrawalCircuit is a withdrawal circuit that also proves that the
+// note being spent belongs to an association set, i.e. a subset of the leaves of
+// the main tree chosen by a published policy, without revealing which leaf.
+// The association tree has the same depth and leaf positions as the main tree,
+// leaves not in the set are replaced by the empty leaf, so the same Index is
+// used for both Merkle proofs.
+type AssociationWithdrawalCircuit struct {
+	Recipient       frontend.Variable `gnark:",public"`
+	Withdrawal      frontend.Variable `gnark:",public"`
+	Fee             frontend.Variable `gnark:",public"`
+	Commitment      frontend.Variable `gnark:",public"`
+	Nullifier       frontend.Variable `gnark:",public"`
+	Root            frontend.Variable `gnark:",public"`
+	AssociationRoot frontend.Variable `gnark:",public"`
+	K               frontend.Variable
+	R               frontend.Variable
+	Amount          frontend.Variable
+	Change          frontend.Variable
+	K2              frontend.Variable
+	R2              frontend.Variable
+	Index           frontend.Variable
+	Path            [MerkleTreeLevels + 1]frontend.Variable
+	AssociationPath [MerkleTreeLevels + 1]frontend.Variable
+}
+
+func (c *AssociationWithdrawalCircuit) Define(api frontend.API) error {
+	withdrawal := WithdrawalCircuit{
+		Recipient:  c.Recipient,
+		Withdrawal: c.Withdrawal,
+		Fee:        c.Fee,
+		Commitment: c.Commitment,
+		Nullifier:  c.Nullifier,
+		Root:       c.Root,
+		K:          c.K,
+		R:          c.R,
+		Amount:     c.Amount,
+		Change:     c.Change,
+		K2:         c.K2,
+		R2:         c.R2,
+		Index:      c.Index,
+		Path:       c.Path,
+	}
+	if err := withdrawal.Define(api); err != nil {
+		return err
+	}
+
+	mimc, _ := mimc.NewMiMC(api)
+
+	// AssociationPath[0] == Path[0], i.e. the same leaf is in the association tree
+	api.AssertIsEqual(c.AssociationPath[0], c.Path[0])
+
+	// Amount,K,R is in the association tree at index
+	mp := merkle.MerkleProof{
+		RootHash: c.AssociationRoot,
+		Path:     c.AssociationPath[:],
+	}
+	mp.VerifyProof(api, &mimc, c.Index)
+
+	return nil
+}
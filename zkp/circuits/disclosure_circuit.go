@@ -0,0 +1,42 @@
+package circuits
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/mimc"
+)
+
+// DisclosureCircuit proves that the note with the public Commitment is the one
+// with the public Nullifier, without revealing the note secrets K and R.
+// A chain of these proofs, together with the public withdrawal txns linking each
+// spent nullifier to the change note commitment, links a withdrawal to its deposit
+type DisclosureCircuit struct {
+	Commitment frontend.Variable `gnark:",public"`
+	Nullifier  frontend.Variable `gnark:",public"`
+	Amount     frontend.Variable
+	K          frontend.Variable
+	R          frontend.Variable
+}
+
+func (c *DisclosureCircuit) Define(api frontend.API) error {
+	mimc, _ := mimc.NewMiMC(api)
+
+	// hash(Amount,K) == Nullifier
+	mimc.Write(c.Amount)
+	mimc.Write(c.K)
+	api.AssertIsEqual(c.Nullifier, mimc.Sum())
+
+	mimc.Reset()
+
+	// hash(hash(Amount, K, R)) == Commitment
+	mimc.Write(c.Amount)
+	mimc.Write(c.K)
+	mimc.Write(c.R)
+	h := mimc.Sum()
+
+	mimc.Reset()
+
+	mimc.Write(h)
+	api.AssertIsEqual(c.Commitment, mimc.Sum())
+
+	return nil
+}
@@ -1,14 +1,14 @@
 package circuits
 
 import (
-	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/constants"
 
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/accumulator/merkle"
 	"github.com/consensys/gnark/std/hash/mimc"
 )
 
-const MerkleTreeLevels = config.MerkleTreeLevels
+const MerkleTreeLevels = constants.MerkleTreeLevels
 
 type WithdrawalCircuit struct {
 	Recipient  frontend.Variable `gnark:",public"`